@@ -0,0 +1,207 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"backend/auth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Intervalo minimo entre actualizaciones de last_used_at, evita una escritura por peticion
+const DefaultTouchInterval = time.Minute
+
+// Caracteres de la key que se guardan en claro para reconocerla en los listados
+const displayPrefixLength = 12
+
+// Error de una key inexistente o ya revocada
+var ErrNotFound = errors.New("api key no encontrada")
+
+// API key de un cliente de maquina. Solo se guarda el hash SHA-256 de la key; la key en
+// claro se retorna una unica vez al emitirla o rotarla.
+type Key struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+	Prefix string             `json:"prefix" bson:"prefix"`
+	Hash   string             `json:"-" bson:"hash"`
+	Scopes []string           `json:"scopes" bson:"scopes"`
+
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at" bson:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at" bson:"revoked_at,omitempty"`
+
+	// Key en claro, solo en la respuesta de la emision y la rotacion
+	Secret string `json:"key,omitempty" bson:"-"`
+}
+
+// Servicio de API keys: emision, listado, rotacion, revocacion y validacion
+type Service struct {
+	Coll *mongo.Collection
+	// Intervalo minimo entre actualizaciones de last_used_at, DefaultTouchInterval si es cero
+	TouchInterval time.Duration
+	Logger        *slog.Logger
+	// Reloj, time.Now si es nil
+	Now func() time.Time
+}
+
+// Valida que los alcances existan y no esten vacios
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("la api key necesita al menos un alcance")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			return fmt.Errorf("alcance desconocido: %s", scope)
+		}
+	}
+	return nil
+}
+
+// Emite una key nueva
+func (s *Service) Issue(ctx context.Context, name string, scopes []string) (Key, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, err
+	}
+
+	key := Key{
+		Name:      name,
+		Prefix:    secret[:displayPrefixLength],
+		Hash:      Hash(secret),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedAt: s.now(),
+	}
+	res, err := s.Coll.InsertOne(ctx, key)
+	if err != nil {
+		return Key{}, err
+	}
+	key.ID, _ = res.InsertedID.(primitive.ObjectID)
+	key.Secret = secret
+	return key, nil
+}
+
+// Lista las keys, incluidas las revocadas, de la mas reciente a la mas antigua
+func (s *Service) List(ctx context.Context) ([]Key, error) {
+	cur, err := s.Coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []Key{}
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Reemplaza la key por una nueva con el mismo id y alcances; la anterior deja de valer
+func (s *Service) Rotate(ctx context.Context, id primitive.ObjectID) (Key, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, err
+	}
+
+	var key Key
+	err = s.Coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revoked_at": nil},
+		bson.M{"$set": bson.M{"hash": Hash(secret), "prefix": secret[:displayPrefixLength], "rotated_at": s.now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Key{}, ErrNotFound
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// Revoca la key. Se conserva para el listado y la auditoria.
+func (s *Service) Revoke(ctx context.Context, id primitive.ObjectID) (Key, error) {
+	var key Key
+	err := s.Coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": s.now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Key{}, ErrNotFound
+	}
+	return key, err
+}
+
+// Valida una key y retorna la identidad con sus alcances. Implementa auth.KeyAuthenticator.
+func (s *Service) AuthenticateKey(ctx context.Context, secret string) (*auth.Principal, error) {
+	var key Key
+	err := s.Coll.FindOne(ctx, bson.M{"hash": Hash(secret), "revoked_at": nil}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, auth.ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.touch(ctx, key)
+	return &auth.Principal{ID: key.ID.Hex(), Kind: auth.KindAPIKey, Scopes: key.Scopes}, nil
+}
+
+// Registra el ultimo uso como mucho una vez por intervalo
+func (s *Service) touch(ctx context.Context, key Key) {
+	now := s.now()
+	interval := s.TouchInterval
+	if interval <= 0 {
+		interval = DefaultTouchInterval
+	}
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < interval {
+		return
+	}
+
+	filter := bson.M{"_id": key.ID, "$or": bson.A{
+		bson.M{"last_used_at": nil},
+		bson.M{"last_used_at": bson.M{"$lt": now.Add(-interval)}},
+	}}
+	if _, err := s.Coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_used_at": now}}); err != nil {
+		s.log().WarnContext(ctx, "api key last use not recorded", "key_id", key.ID.Hex(), "error", err)
+	}
+}
+
+// Hash con el que se guarda y busca una key. Las keys son aleatorias de 256 bits,
+// un hash rapido basta para que la base no contenga keys utilizables.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return auth.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Service) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Service) log() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Alcances de las credenciales: <recurso>:read para consultas y <recurso>:write para cambios
+const (
+	ScopeBooksRead  = "books:read"
+	ScopeBooksWrite = "books:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeLoansRead  = "loans:read"
+	ScopeLoansWrite = "loans:write"
+	ScopeEventsRead = "events:read"
+)
+
+// Alcances que se pueden conceder a una API key
+var Scopes = []string{
+	ScopeBooksRead, ScopeBooksWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeLoansRead, ScopeLoansWrite,
+	ScopeEventsRead,
+}
+
+// Alcances de los tokens de usuario
+var UserScopes = []string{ScopeBooksRead, ScopeEventsRead}
+
+// Encabezado y prefijo de las API keys. El prefijo distingue una key de un token
+// cuando se envia en Authorization: Bearer.
+const (
+	HeaderAPIKey = "X-API-Key"
+	APIKeyPrefix = "lib_"
+)
+
+// Error de una API key inexistente o revocada
+var ErrInvalidKey = errors.New("api key invalida")
+
+// Valida una API key y retorna la identidad con sus alcances
+type KeyAuthenticator interface {
+	AuthenticateKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// Identifica al cliente por API key, token de usuario o token de administrador
+type Authenticator struct {
+	Keys       KeyAuthenticator
+	Tokens     *TokenSigner
+	AdminToken string
+	// Exige credenciales con el alcance en las rutas con alcance; sin esto los alcances no
+	// restringen y toda peticion tiene el mismo acceso que una anonima
+	Required bool
+	// Exige credenciales con el alcance en los cambios aunque Required sea falso, para que la
+	// auditoria registre quien hizo cada cambio
+	RequiredForWrites bool
+	Logger            *slog.Logger
+}
+
+// Middleware que identifica al cliente y guarda la identidad en el contexto. Las peticiones
+// sin credenciales siguen como anonimas; las credenciales invalidas responden 401.
+func (a *Authenticator) Identify() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if a == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			key := strings.TrimSpace(c.Request().Header.Get(HeaderAPIKey))
+			bearer, hasBearer := bearerToken(c)
+			if key == "" && hasBearer && strings.HasPrefix(bearer, APIKeyPrefix) {
+				key, hasBearer = bearer, false
+			}
+
+			switch {
+			case key != "":
+				if a.Keys == nil {
+					return deny(c, http.StatusUnauthorized, "API keys deshabilitadas")
+				}
+				p, err := a.Keys.AuthenticateKey(c.Request().Context(), key)
+				if errors.Is(err, ErrInvalidKey) {
+					return deny(c, http.StatusUnauthorized, "API key invalida")
+				}
+				if err != nil {
+					a.log().ErrorContext(c.Request().Context(), "api key not validated", "error", err)
+					return deny(c, http.StatusInternalServerError, "No se pudo validar la API key")
+				}
+				SetPrincipal(c, p)
+
+			case hasBearer:
+				if a.AdminToken != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(a.AdminToken)) == 1 {
+					SetPrincipal(c, &Principal{ID: "admin", Kind: KindAdmin})
+					break
+				}
+				if a.Tokens == nil {
+					return deny(c, http.StatusUnauthorized, "Token invalido")
+				}
+				userId, err := a.Tokens.Verify(bearer)
+				if errors.Is(err, ErrExpiredToken) {
+					return deny(c, http.StatusUnauthorized, "Token vencido")
+				}
+				if err != nil {
+					return deny(c, http.StatusUnauthorized, "Token invalido")
+				}
+				SetPrincipal(c, &Principal{ID: userId, Kind: KindUser, Scopes: UserScopes})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// Middleware que exige el alcance a la identidad de la peticion. Debe ejecutarse despues de Identify.
+// Si las credenciales no son obligatorias una credencial sin el alcance se trata igual que una
+// peticion anonima, para que identificarse nunca de menos acceso que no hacerlo.
+func (a *Authenticator) RequireScope(scope string) echo.MiddlewareFunc {
+	return a.requireScope(scope, a != nil && a.Required)
+}
+
+func (a *Authenticator) requireScope(scope string, required bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if a == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			p := PrincipalFrom(c)
+			switch {
+			case p != nil && p.Allows(scope), !required:
+				return next(c)
+			case p == nil:
+				return deny(c, http.StatusUnauthorized, "Credenciales requeridas")
+			}
+			return deny(c, http.StatusForbidden, "La credencial no tiene el alcance "+scope)
+		}
+	}
+}
+
+// Middleware que exige <resource>:read en las consultas GET y HEAD y <resource>:write en el resto.
+// Con RequiredForWrites los cambios exigen credenciales aunque Required sea falso.
+func (a *Authenticator) RequireAccess(resource string) echo.MiddlewareFunc {
+	read := a.RequireScope(resource + ":read")
+	write := a.requireScope(resource+":write", a != nil && (a.Required || a.RequiredForWrites))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		onRead, onWrite := read(next), write(next)
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead:
+				return onRead(c)
+			}
+			return onWrite(c)
+		}
+	}
+}
+
+func (a *Authenticator) log() *slog.Logger {
+	if a.Logger != nil {
+		return a.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/migrations"
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Las tareas de mantenimiento escriben directo en las colecciones: no generan eventos
+// del outbox ni entradas de auditoria.
+
+// Carga datos de demostracion. Es idempotente: los libros se identifican por isbn,
+// los usuarios por correo y el prestamo por usuario y libro.
+func seed(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	if err := parseFlags("seed", args); err != nil {
+		return err
+	}
+
+	books := []models.Book{
+		{Title: "Cien años de soledad", Author: "Gabriel García Márquez", Isbn: "9780307474728", Availability: 3, Copies: 3},
+		{Title: "Rayuela", Author: "Julio Cortázar", Isbn: "9788437604572", Availability: 2, Copies: 2},
+		{Title: "Ficciones", Author: "Jorge Luis Borges", Isbn: "9788499089515", Availability: 1, Copies: 1},
+		{Title: "Pedro Páramo", Author: "Juan Rulfo", Isbn: "9788437604183", Availability: 2, Copies: 2},
+	}
+	users := []models.User{
+		{Name: "Ana Torres", Email: "ana.torres@example.com"},
+		{Name: "Luis Pérez", Email: "luis.perez@example.com"},
+	}
+
+	upsert := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	for i := range books {
+		b := &books[i]
+		update := bson.M{"$setOnInsert": bson.M{
+			"title": b.Title, "author": b.Author, "availability": b.Availability, "copies": b.Copies,
+		}}
+		if err := db.Collection(database.BooksCollection).FindOneAndUpdate(ctx, bson.M{"isbn": b.Isbn}, update, upsert).Decode(b); err != nil {
+			return err
+		}
+	}
+	for i := range users {
+		u := &users[i]
+		update := bson.M{"$setOnInsert": bson.M{"name": u.Name}}
+		if err := db.Collection(database.UsersCollection).FindOneAndUpdate(ctx, bson.M{"email": u.Email}, update, upsert).Decode(u); err != nil {
+			return err
+		}
+	}
+
+	// Un prestamo vencido para probar los reportes
+	created := time.Now().UTC().AddDate(0, 0, -(config.DefaultLoanDays + 3))
+	loan := bson.M{"$setOnInsert": bson.M{
+		"name":        "Prestamo de demostracion",
+		"description": "Prestamo vencido de ejemplo",
+		"is_returned": false,
+		"created_at":  created,
+		"due_date":    created.AddDate(0, 0, config.DefaultLoanDays),
+	}}
+	filter := bson.M{"user_id": users[0].ID.Hex(), "book_id": books[2].ID.Hex()}
+	if _, err := db.Collection(database.LoansCollection).UpdateOne(ctx, filter, loan, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "datos de demostracion cargados: %d libros, %d usuarios, 1 prestamo\n", len(books), len(users))
+	return nil
+}
+
+// Sincroniza los indices con el registro e imprime las diferencias
+func indexes(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "muestra las diferencias sin aplicarlas")
+	dropUnknown := flags.Bool("drop-unknown", false, "elimina los indices que no estan en el registro")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	drift, err := database.SyncIndexes(ctx, db, database.IndexRegistry, database.SyncOptions{DryRun: *dryRun, DropUnknown: *dropUnknown})
+	if len(drift) > 0 {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COLECCION\tINDICE\tACCION\tMOTIVO\tAPLICADO")
+		for _, d := range drift {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", d.Collection, d.Name, d.Action, d.Reason, d.Applied)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	} else if err == nil {
+		fmt.Fprintln(out, "indices al dia")
+	}
+	return err
+}
+
+// Aplica las migraciones pendientes (up), deshace las ultimas (down) o lista su estado (status)
+func migrate(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "migraciones a deshacer con down")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	runner := &migrations.Runner{DB: db, LockTTL: cfg.Migrations.LockTTL}
+	switch action {
+	case "up":
+		applied, err := runner.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "aplicada %d: %s\n", m.Version, m.Description)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "sin migraciones pendientes")
+		}
+		return err
+	case "down":
+		reverted, err := runner.Down(ctx, *steps)
+		for _, m := range reverted {
+			fmt.Fprintf(out, "deshecha %d: %s\n", m.Version, m.Description)
+		}
+		return err
+	case "status":
+		status, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPCION\tAPLICADA")
+		for _, s := range status {
+			applied := "pendiente"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Description, applied)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("accion desconocida %q, use up, down o status", action)
+	}
+}
+
+// Lista los prestamos vencidos
+func overdue(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("overdue", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "imprime el resultado en JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	loans, err := database.OverdueLoans(ctx, db.Collection(database.LoansCollection), now)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, loans)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PRESTAMO\tUSUARIO\tLIBRO\tVENCIO\tDIAS")
+	for _, l := range loans {
+		days := int(now.Sub(l.DueDate).Hours() / 24)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", l.ID.Hex(), l.UserId, l.BookId, l.DueDate.Format("2006-01-02"), days)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d prestamos vencidos\n", len(loans))
+	return nil
+}
+
+// Recalcula la disponibilidad de los libros con los prestamos abiertos
+func recompute(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("recompute", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "muestra los cambios sin aplicarlos")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	changes, err := database.RecomputeAvailability(ctx, db.Collection(database.BooksCollection), db.Collection(database.LoansCollection), *dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LIBRO\tISBN\tEJEMPLARES\tPRESTADOS\tANTES\tDESPUES")
+	for _, ch := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", ch.BookId, ch.Isbn, ch.Copies, ch.OpenLoans, ch.Before, ch.After)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "%d libros cambiarian (sin aplicar)\n", len(changes))
+	} else {
+		fmt.Fprintf(out, "%d libros actualizados\n", len(changes))
+	}
+	return nil
+}
+
+// Exporta libros, usuarios y prestamos en JSON
+func export(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	path := flags.String("o", "-", "archivo de salida, - para la salida estandar")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dump, err := database.Export(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if *path == "-" {
+		return writeJSON(out, dump)
+	}
+	f, err := os.Create(*path)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, dump); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "exportados %d libros, %d usuarios y %d prestamos a %s\n", len(dump.Books), len(dump.Users), len(dump.Loans), *path)
+	return nil
+}
+
+// Importa un respaldo JSON generado por export
+func importDump(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	path := flags.String("i", "-", "archivo de entrada, - para la entrada estandar")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *path != "-" {
+		f, err := os.Open(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var dump database.Dump
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&dump); err != nil {
+		return fmt.Errorf("respaldo invalido: %w", err)
+	}
+
+	counts, err := database.Import(ctx, db, dump)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "importados %d libros, %d usuarios y %d prestamos\n", counts.Books, counts.Users, counts.Loans)
+	return nil
+}
+
+// Valida que un comando sin opciones no reciba argumentos
+func parseFlags(name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("argumentos inesperados: %v", flags.Args())
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
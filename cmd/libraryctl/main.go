@@ -0,0 +1,96 @@
+// Comando libraryctl: tareas de administracion y mantenimiento de la base de la biblioteca.
+// Usa la misma configuracion y la misma capa de datos que el servidor.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"backend/config"
+	"backend/database"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const usage = `Uso: libraryctl [-config archivo] <comando> [opciones]
+
+Comandos:
+  seed                     carga libros, usuarios y un prestamo de demostracion
+  indexes [-dry-run] [-drop-unknown]
+                           sincroniza los indices con el registro y muestra las diferencias
+  migrate [up|down|status] [-steps n]
+                           aplica las migraciones pendientes, deshace las ultimas o muestra su estado
+  overdue [-json]          lista los prestamos vencidos
+  recompute [-dry-run]     recalcula la disponibilidad de los libros con los prestamos abiertos
+  export [-o archivo]      exporta libros, usuarios y prestamos en JSON
+  import [-i archivo]      importa un respaldo JSON, reemplazando los documentos con el mismo id
+`
+
+// Comando con acceso a la base de datos y a la configuracion cargada
+type command func(ctx context.Context, db *mongo.Database, cfg *config.Config, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"seed":      seed,
+	"indexes":   indexes,
+	"migrate":   migrate,
+	"overdue":   overdue,
+	"recompute": recompute,
+	"export":    export,
+	"import":    importDump,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("libraryctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := flags.String("config", "", "archivo de configuracion YAML o TOML (por defecto CONFIG_FILE)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "libraryctl: comando desconocido %q\n\n", name)
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "libraryctl:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := database.Connect(ctx, cfg.Mongo)
+	if err != nil {
+		fmt.Fprintln(stderr, "libraryctl:", err)
+		return 1
+	}
+	defer func() {
+		disconnectCtx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
+		defer cancel()
+		_ = database.Disconnect(disconnectCtx, client)
+	}()
+
+	if err := cmd(ctx, client.Database(cfg.Mongo.Database), &cfg, flags.Args()[1:], stdout); err != nil {
+		fmt.Fprintf(stderr, "libraryctl %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
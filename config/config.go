@@ -0,0 +1,736 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Valores por defecto de la configuracion
+const (
+	DefaultMongoURI       = "mongodb://localhost:27017"
+	DefaultDatabase       = "practica_parcial_final"
+	DefaultAddr           = ":8080"
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultDBReadTimeout  = 5 * time.Second
+	DefaultDBWriteTimeout = 10 * time.Second
+	DefaultReadTimeout    = 15 * time.Second
+	DefaultWriteTimeout   = 15 * time.Second
+	DefaultShutdown       = 15 * time.Second
+	DefaultLogLevel       = "info"
+	DefaultLoanDays       = 14
+	DefaultMaxActiveLoans = 3
+	DefaultMaxRenewals    = 2
+	DefaultFinePerDay     = 25
+	DefaultMaxFine        = 2000
+	DefaultHoldPickupDays = 7
+	DefaultServiceName    = "library-api"
+	DefaultOTLPEndpoint   = "localhost:4318"
+)
+
+// Configuracion completa del servicio
+type Config struct {
+	Mongo         MongoConfig         `yaml:"mongo" toml:"mongo"`
+	Server        ServerConfig        `yaml:"server" toml:"server"`
+	Log           LogConfig           `yaml:"log" toml:"log"`
+	Loans         LoanPolicy          `yaml:"loans" toml:"loans"`
+	Tracing       TracingConfig       `yaml:"tracing" toml:"tracing"`
+	Auth          AuthConfig          `yaml:"auth" toml:"auth"`
+	Audit         AuditConfig         `yaml:"audit" toml:"audit"`
+	Outbox        OutboxConfig        `yaml:"outbox" toml:"outbox"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks" toml:"webhooks"`
+	Stream        StreamConfig        `yaml:"stream" toml:"stream"`
+	Migrations    MigrationsConfig    `yaml:"migrations" toml:"migrations"`
+	Indexes       IndexesConfig       `yaml:"indexes" toml:"indexes"`
+	API           APIConfig           `yaml:"api" toml:"api"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit" toml:"rate_limit"`
+	Idempotency   IdempotencyConfig   `yaml:"idempotency" toml:"idempotency"`
+	Notifications NotificationsConfig `yaml:"notifications" toml:"notifications"`
+	Jobs          JobsConfig          `yaml:"jobs" toml:"jobs"`
+}
+
+// Configuracion de la conexion a MongoDB
+type MongoConfig struct {
+	URI            string        `yaml:"uri" toml:"uri"`
+	Database       string        `yaml:"database" toml:"database"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	Timeouts       DBTimeouts    `yaml:"timeouts" toml:"timeouts"`
+}
+
+// Limites de tiempo por operacion de base de datos
+type DBTimeouts struct {
+	// Consultas: Find, FindOne, CountDocuments
+	Read time.Duration `yaml:"read" toml:"read"`
+	// Escrituras: InsertOne, UpdateOne, DeleteOne
+	Write time.Duration `yaml:"write" toml:"write"`
+}
+
+// Configuracion del servidor HTTP
+type ServerConfig struct {
+	Addr         string        `yaml:"addr" toml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	// Tiempo maximo para drenar las peticiones en curso al apagar el servidor
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// Toma la IP del cliente de X-Forwarded-For, solo detras de un proxy de confianza
+	TrustProxy bool `yaml:"trust_proxy" toml:"trust_proxy"`
+}
+
+// Configuracion de los logs
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+// Configuracion de las trazas de OpenTelemetry
+type TracingConfig struct {
+	// Exportador: none, stdout u otlp
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Direccion host:puerto del colector OTLP/HTTP
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Configuracion de la autenticacion
+type AuthConfig struct {
+	// Token de las rutas de administracion, vacio las deshabilita
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+	// Secreto HMAC de los tokens de usuario, vacio deshabilita los tokens
+	TokenSecret string `yaml:"token_secret" toml:"token_secret"`
+	// Vigencia de los tokens de usuario
+	TokenTTL time.Duration `yaml:"token_ttl" toml:"token_ttl"`
+	// Exige API key o token con el alcance en las rutas de libros, usuarios, prestamos y
+	// eventos; sin esto los alcances no restringen el acceso
+	Required bool `yaml:"required" toml:"required"`
+}
+
+// Configuracion de la auditoria de cambios
+type AuditConfig struct {
+	// Registra cada cambio con la identidad que lo hizo. Mientras esta activa los cambios
+	// exigen credenciales aunque AUTH_REQUIRED sea falso, para no registrar cambios anonimos.
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+}
+
+// Largo minimo del secreto de los tokens de usuario
+const MinTokenSecretLength = 32
+
+// Configuracion del despachador de eventos del outbox
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts"`
+	BaseBackoff  time.Duration `yaml:"base_backoff" toml:"base_backoff"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// Configuracion de las entregas de webhooks salientes
+type WebhooksConfig struct {
+	// Limite de tiempo de cada peticion al receptor
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// Intervalo con que los workers consultan las entregas pendientes
+	RetryInterval time.Duration `yaml:"retry_interval" toml:"retry_interval"`
+	// Entregas que se envian en paralelo en cada instancia
+	Workers int `yaml:"workers" toml:"workers"`
+	// Vigencia del reclamo de una entrega, debe superar el limite de tiempo del envio
+	Lease       time.Duration `yaml:"lease" toml:"lease"`
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	BaseBackoff time.Duration `yaml:"base_backoff" toml:"base_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// Configuracion del flujo de eventos en vivo
+type StreamConfig struct {
+	// Intervalo de consulta del outbox cuando Mongo no soporta change streams
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	// Intervalo de los comentarios de keep-alive enviados a los clientes
+	Heartbeat time.Duration `yaml:"heartbeat" toml:"heartbeat"`
+	// Espera de un evento de la secuencia que aun no se confirma antes de saltarlo al consultar
+	GapTimeout time.Duration `yaml:"gap_timeout" toml:"gap_timeout"`
+}
+
+// Configuracion de las migraciones de esquema
+type MigrationsConfig struct {
+	// Aplica las migraciones pendientes al iniciar el servidor
+	OnStartup bool `yaml:"on_startup" toml:"on_startup"`
+	// Vigencia del bloqueo de migraciones, se renueva mientras se migra
+	LockTTL time.Duration `yaml:"lock_ttl" toml:"lock_ttl"`
+	// Tiempo maximo para esperar el bloqueo y aplicar las migraciones al iniciar
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Configuracion de la sincronizacion de indices con el registro
+type IndexesConfig struct {
+	// Sincroniza los indices al iniciar el servidor
+	SyncOnStartup bool `yaml:"sync_on_startup" toml:"sync_on_startup"`
+	// Elimina los indices que no estan en el registro, por defecto solo se reportan
+	DropUnknown bool `yaml:"drop_unknown" toml:"drop_unknown"`
+	// Detiene el inicio si la sincronizacion falla, por defecto se reporta y el servidor continua
+	Strict bool `yaml:"strict" toml:"strict"`
+	// Tiempo maximo de la sincronizacion al iniciar
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Formato de las fechas de la configuracion
+const DateLayout = "2006-01-02"
+
+// Configuracion de las versiones de la API
+type APIConfig struct {
+	// Fecha desde la que las rutas sin version se anuncian como obsoletas (AAAA-MM-DD)
+	LegacyDeprecation string `yaml:"legacy_deprecation" toml:"legacy_deprecation"`
+	// Fecha en la que se retiraran las rutas sin version (AAAA-MM-DD)
+	LegacySunset string `yaml:"legacy_sunset" toml:"legacy_sunset"`
+}
+
+// Retorna las fechas de obsolescencia y retiro de las rutas sin version
+func (a APIConfig) LegacyDates() (deprecation, sunset time.Time, err error) {
+	deprecation, err = time.Parse(DateLayout, strings.TrimSpace(a.LegacyDeprecation))
+	if err != nil {
+		return deprecation, sunset, fmt.Errorf("config: fecha de obsolescencia invalida: %q", a.LegacyDeprecation)
+	}
+	sunset, err = time.Parse(DateLayout, strings.TrimSpace(a.LegacySunset))
+	if err != nil {
+		return deprecation, sunset, fmt.Errorf("config: fecha de retiro invalida: %q", a.LegacySunset)
+	}
+	if !sunset.After(deprecation) {
+		return deprecation, sunset, errors.New("config: la fecha de retiro debe ser posterior a la de obsolescencia")
+	}
+	return deprecation, sunset, nil
+}
+
+// Configuracion del limite de tasa por grupo de rutas
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Limite de los grupos sin limite propio
+	Default RateLimit `yaml:"default" toml:"default"`
+	// Limites por grupo de rutas: books, users, loans, events, me, admin
+	Groups map[string]RateLimit `yaml:"groups" toml:"groups"`
+}
+
+// Token bucket: se recargan Requests fichas cada Period, con capacidad Burst
+type RateLimit struct {
+	// Fichas por periodo, 0 significa sin limite
+	Requests int           `yaml:"requests" toml:"requests"`
+	Period   time.Duration `yaml:"period" toml:"period"`
+	// Capacidad del bucket, Requests si es 0
+	Burst int `yaml:"burst" toml:"burst"`
+}
+
+// Valida un limite de tasa, un limite sin fichas no necesita periodo
+func (r RateLimit) validate(name string) error {
+	if r.Requests < 0 || r.Burst < 0 {
+		return fmt.Errorf("config: el limite de tasa %s no puede ser negativo", name)
+	}
+	if r.Requests > 0 && r.Period <= 0 {
+		return fmt.Errorf("config: el periodo del limite de tasa %s debe ser positivo", name)
+	}
+	return nil
+}
+
+// Configuracion de las Idempotency-Key de las rutas de creacion
+type IdempotencyConfig struct {
+	// Vigencia de las respuestas guardadas
+	TTL time.Duration `yaml:"ttl" toml:"ttl"`
+	// Vigencia de la reserva de una clave mientras se procesa la primera peticion
+	LockTTL time.Duration `yaml:"lock_ttl" toml:"lock_ttl"`
+	// Tamaño maximo en bytes del cuerpo de las peticiones con Idempotency-Key
+	MaxRequestBody int `yaml:"max_request_body" toml:"max_request_body"`
+}
+
+// Configuracion de las notificaciones por correo a los usuarios
+type NotificationsConfig struct {
+	// Habilita el envio, deshabilitado por defecto hasta configurar el servidor SMTP
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Dias de anticipacion de los recordatorios de vencimiento
+	DueSoonDays int `yaml:"due_soon_days" toml:"due_soon_days"`
+	// Idioma de los usuarios sin idioma: es o en
+	Language string     `yaml:"language" toml:"language"`
+	SMTP     SMTPConfig `yaml:"smtp" toml:"smtp"`
+}
+
+// Configuracion del planificador de tareas periodicas. Los horarios son expresiones cron
+// de cinco campos en UTC o descriptores como @hourly.
+type JobsConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Vigencia de los bloqueos del lider y de cada tarea
+	LockTTL time.Duration `yaml:"lock_ttl" toml:"lock_ttl"`
+	// Limite de cada ejecucion
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// Marca los prestamos vencidos
+	Overdue string `yaml:"overdue" toml:"overdue"`
+	// Vence las reservas listas que no se retiraron
+	HoldExpiry string `yaml:"hold_expiry" toml:"hold_expiry"`
+	// Envia las notificaciones por correo, si estan habilitadas
+	Notifications string `yaml:"notifications" toml:"notifications"`
+}
+
+// Servidor SMTP de las notificaciones
+type SMTPConfig struct {
+	// Direccion host:puerto
+	Addr     string        `yaml:"addr" toml:"addr"`
+	From     string        `yaml:"from" toml:"from"`
+	Username string        `yaml:"username" toml:"username"`
+	Password string        `yaml:"password" toml:"password"`
+	Timeout  time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Politica de prestamos de la biblioteca
+type LoanPolicy struct {
+	// Dias de duracion de un prestamo
+	LoanDays int `yaml:"loan_days" toml:"loan_days"`
+	// Prestamos activos permitidos por usuario, 0 significa sin limite
+	MaxActiveLoans int `yaml:"max_active_loans" toml:"max_active_loans"`
+	// Renovaciones permitidas por prestamo, 0 deshabilita las renovaciones
+	MaxRenewals int `yaml:"max_renewals" toml:"max_renewals"`
+	// Multa por dia de atraso en centavos
+	FinePerDay int `yaml:"fine_per_day" toml:"fine_per_day"`
+	// Multa maxima por prestamo en centavos, 0 significa sin tope
+	MaxFine int `yaml:"max_fine" toml:"max_fine"`
+	// Dias para retirar una reserva lista antes de que venza
+	HoldPickupDays int `yaml:"hold_pickup_days" toml:"hold_pickup_days"`
+}
+
+// Retorna la configuracion con todos los valores por defecto
+func Default() Config {
+	return Config{
+		Mongo: MongoConfig{
+			URI:            DefaultMongoURI,
+			Database:       DefaultDatabase,
+			ConnectTimeout: DefaultConnectTimeout,
+			Timeouts: DBTimeouts{
+				Read:  DefaultDBReadTimeout,
+				Write: DefaultDBWriteTimeout,
+			},
+		},
+		Server: ServerConfig{
+			Addr:            DefaultAddr,
+			ReadTimeout:     DefaultReadTimeout,
+			WriteTimeout:    DefaultWriteTimeout,
+			ShutdownTimeout: DefaultShutdown,
+		},
+		Log: LogConfig{
+			Level: DefaultLogLevel,
+		},
+		Loans: LoanPolicy{
+			LoanDays:       DefaultLoanDays,
+			MaxActiveLoans: DefaultMaxActiveLoans,
+			MaxRenewals:    DefaultMaxRenewals,
+			FinePerDay:     DefaultFinePerDay,
+			MaxFine:        DefaultMaxFine,
+			HoldPickupDays: DefaultHoldPickupDays,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    DefaultOTLPEndpoint,
+			Insecure:    true,
+			ServiceName: DefaultServiceName,
+			SampleRatio: 1,
+		},
+		Auth: AuthConfig{
+			TokenTTL: 24 * time.Hour,
+		},
+		Audit: AuditConfig{
+			Enabled: true,
+		},
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			MaxAttempts:  10,
+			BaseBackoff:  time.Second,
+			MaxBackoff:   5 * time.Minute,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:       10 * time.Second,
+			RetryInterval: 5 * time.Second,
+			Workers:       4,
+			Lease:         time.Minute,
+			MaxAttempts:   8,
+			BaseBackoff:   10 * time.Second,
+			MaxBackoff:    time.Hour,
+		},
+		Stream: StreamConfig{
+			PollInterval: time.Second,
+			Heartbeat:    15 * time.Second,
+			GapTimeout:   5 * time.Second,
+		},
+		Migrations: MigrationsConfig{
+			OnStartup: true,
+			LockTTL:   time.Minute,
+			Timeout:   5 * time.Minute,
+		},
+		Indexes: IndexesConfig{
+			SyncOnStartup: true,
+			Timeout:       5 * time.Minute,
+		},
+		API: APIConfig{
+			LegacyDeprecation: "2026-11-01",
+			LegacySunset:      "2027-05-01",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Default: RateLimit{Requests: 120, Period: time.Minute, Burst: 60},
+			Groups: map[string]RateLimit{
+				"loans": {Requests: 30, Period: time.Minute, Burst: 10},
+			},
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:            24 * time.Hour,
+			LockTTL:        time.Minute,
+			MaxRequestBody: 1 << 20,
+		},
+		Notifications: NotificationsConfig{
+			DueSoonDays: 3,
+			Language:    "es",
+			SMTP: SMTPConfig{
+				Addr:    "localhost:25",
+				From:    "Biblioteca <biblioteca@localhost>",
+				Timeout: 30 * time.Second,
+			},
+		},
+		Jobs: JobsConfig{
+			Enabled:       true,
+			LockTTL:       time.Minute,
+			Timeout:       10 * time.Minute,
+			Overdue:       "*/15 * * * *",
+			HoldExpiry:    "0 * * * *",
+			Notifications: "0 9 * * *",
+		},
+	}
+}
+
+// Carga la configuracion: valores por defecto, luego el archivo opcional y por ultimo
+// las variables de entorno. Si path esta vacio se usa la variable CONFIG_FILE.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Lee el archivo de configuracion segun su extension (YAML o TOML)
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: no se pudo leer %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config: formato de archivo no soportado: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: archivo %s invalido: %w", path, err)
+	}
+
+	return nil
+}
+
+// Sobrescribe la configuracion con las variables de entorno definidas
+func loadEnv(cfg *Config) error {
+	var errs []error
+
+	setString(&cfg.Mongo.URI, "MONGO_URI")
+	setString(&cfg.Mongo.Database, "MONGO_DATABASE")
+	errs = append(errs, setDuration(&cfg.Mongo.ConnectTimeout, "MONGO_CONNECT_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Mongo.Timeouts.Read, "MONGO_READ_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Mongo.Timeouts.Write, "MONGO_WRITE_TIMEOUT"))
+
+	setString(&cfg.Server.Addr, "HTTP_ADDR")
+	errs = append(errs, setDuration(&cfg.Server.ReadTimeout, "HTTP_READ_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.WriteTimeout, "HTTP_WRITE_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.ShutdownTimeout, "HTTP_SHUTDOWN_TIMEOUT"))
+	errs = append(errs, setBool(&cfg.Server.TrustProxy, "HTTP_TRUST_PROXY"))
+
+	setString(&cfg.Log.Level, "LOG_LEVEL")
+
+	errs = append(errs, setInt(&cfg.Loans.LoanDays, "LOAN_DAYS"))
+	errs = append(errs, setInt(&cfg.Loans.MaxActiveLoans, "LOAN_MAX_ACTIVE"))
+	errs = append(errs, setInt(&cfg.Loans.MaxRenewals, "LOAN_MAX_RENEWALS"))
+	errs = append(errs, setInt(&cfg.Loans.FinePerDay, "LOAN_FINE_PER_DAY"))
+	errs = append(errs, setInt(&cfg.Loans.MaxFine, "LOAN_MAX_FINE"))
+	errs = append(errs, setInt(&cfg.Loans.HoldPickupDays, "LOAN_HOLD_PICKUP_DAYS"))
+
+	setString(&cfg.Tracing.Exporter, "TRACING_EXPORTER")
+	setString(&cfg.Tracing.Endpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	errs = append(errs, setBool(&cfg.Tracing.Insecure, "OTEL_EXPORTER_OTLP_INSECURE"))
+	setString(&cfg.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+	errs = append(errs, setFloat(&cfg.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO"))
+
+	setString(&cfg.Auth.AdminToken, "ADMIN_TOKEN")
+	setString(&cfg.Auth.TokenSecret, "AUTH_TOKEN_SECRET")
+
+	errs = append(errs, setDuration(&cfg.Outbox.PollInterval, "OUTBOX_POLL_INTERVAL"))
+	errs = append(errs, setInt(&cfg.Outbox.BatchSize, "OUTBOX_BATCH_SIZE"))
+	errs = append(errs, setInt(&cfg.Outbox.MaxAttempts, "OUTBOX_MAX_ATTEMPTS"))
+	errs = append(errs, setDuration(&cfg.Outbox.BaseBackoff, "OUTBOX_BASE_BACKOFF"))
+	errs = append(errs, setDuration(&cfg.Outbox.MaxBackoff, "OUTBOX_MAX_BACKOFF"))
+	errs = append(errs, setDuration(&cfg.Webhooks.Timeout, "WEBHOOKS_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Webhooks.RetryInterval, "WEBHOOKS_RETRY_INTERVAL"))
+	errs = append(errs, setInt(&cfg.Webhooks.Workers, "WEBHOOKS_WORKERS"))
+	errs = append(errs, setDuration(&cfg.Webhooks.Lease, "WEBHOOKS_LEASE"))
+	errs = append(errs, setInt(&cfg.Webhooks.MaxAttempts, "WEBHOOKS_MAX_ATTEMPTS"))
+	errs = append(errs, setDuration(&cfg.Webhooks.BaseBackoff, "WEBHOOKS_BASE_BACKOFF"))
+	errs = append(errs, setDuration(&cfg.Webhooks.MaxBackoff, "WEBHOOKS_MAX_BACKOFF"))
+	errs = append(errs, setDuration(&cfg.Stream.PollInterval, "STREAM_POLL_INTERVAL"))
+	errs = append(errs, setDuration(&cfg.Stream.Heartbeat, "STREAM_HEARTBEAT"))
+	errs = append(errs, setDuration(&cfg.Stream.GapTimeout, "STREAM_GAP_TIMEOUT"))
+	errs = append(errs, setBool(&cfg.Migrations.OnStartup, "MIGRATE_ON_STARTUP"))
+	errs = append(errs, setDuration(&cfg.Migrations.LockTTL, "MIGRATIONS_LOCK_TTL"))
+	errs = append(errs, setDuration(&cfg.Migrations.Timeout, "MIGRATIONS_TIMEOUT"))
+	errs = append(errs, setBool(&cfg.Indexes.SyncOnStartup, "INDEXES_SYNC_ON_STARTUP"))
+	errs = append(errs, setBool(&cfg.Indexes.DropUnknown, "INDEXES_DROP_UNKNOWN"))
+	errs = append(errs, setBool(&cfg.Indexes.Strict, "INDEXES_STRICT"))
+	errs = append(errs, setDuration(&cfg.Indexes.Timeout, "INDEXES_TIMEOUT"))
+	setString(&cfg.API.LegacyDeprecation, "API_LEGACY_DEPRECATION")
+	setString(&cfg.API.LegacySunset, "API_LEGACY_SUNSET")
+	errs = append(errs, setBool(&cfg.RateLimit.Enabled, "RATE_LIMIT_ENABLED"))
+	errs = append(errs, setInt(&cfg.RateLimit.Default.Requests, "RATE_LIMIT_REQUESTS"))
+	errs = append(errs, setDuration(&cfg.RateLimit.Default.Period, "RATE_LIMIT_PERIOD"))
+	errs = append(errs, setInt(&cfg.RateLimit.Default.Burst, "RATE_LIMIT_BURST"))
+	errs = append(errs, setDuration(&cfg.Idempotency.TTL, "IDEMPOTENCY_TTL"))
+	errs = append(errs, setDuration(&cfg.Idempotency.LockTTL, "IDEMPOTENCY_LOCK_TTL"))
+	errs = append(errs, setInt(&cfg.Idempotency.MaxRequestBody, "IDEMPOTENCY_MAX_REQUEST_BODY"))
+	errs = append(errs, setDuration(&cfg.Auth.TokenTTL, "AUTH_TOKEN_TTL"))
+	errs = append(errs, setBool(&cfg.Notifications.Enabled, "NOTIFY_ENABLED"))
+	errs = append(errs, setInt(&cfg.Notifications.DueSoonDays, "NOTIFY_DUE_SOON_DAYS"))
+	setString(&cfg.Notifications.Language, "NOTIFY_LANGUAGE")
+	setString(&cfg.Notifications.SMTP.Addr, "SMTP_ADDR")
+	setString(&cfg.Notifications.SMTP.From, "SMTP_FROM")
+	setString(&cfg.Notifications.SMTP.Username, "SMTP_USERNAME")
+	setString(&cfg.Notifications.SMTP.Password, "SMTP_PASSWORD")
+	errs = append(errs, setDuration(&cfg.Notifications.SMTP.Timeout, "SMTP_TIMEOUT"))
+	errs = append(errs, setBool(&cfg.Jobs.Enabled, "JOBS_ENABLED"))
+	errs = append(errs, setDuration(&cfg.Jobs.LockTTL, "JOBS_LOCK_TTL"))
+	errs = append(errs, setDuration(&cfg.Jobs.Timeout, "JOBS_TIMEOUT"))
+	setString(&cfg.Jobs.Overdue, "JOBS_OVERDUE_SCHEDULE")
+	setString(&cfg.Jobs.HoldExpiry, "JOBS_HOLD_EXPIRY_SCHEDULE")
+	setString(&cfg.Jobs.Notifications, "JOBS_NOTIFICATIONS_SCHEDULE")
+	errs = append(errs, setBool(&cfg.Auth.Required, "AUTH_REQUIRED"))
+	errs = append(errs, setBool(&cfg.Audit.Enabled, "AUDIT_ENABLED"))
+
+	return errors.Join(errs...)
+}
+
+func setString(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok && strings.TrimSpace(v) != "" {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+func setInt(dst *int, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("config: %s debe ser un entero: %q", key, v)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("config: %s debe ser true o false: %q", key, v)
+	}
+	*dst = b
+	return nil
+}
+
+func setFloat(dst *float64, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return fmt.Errorf("config: %s debe ser un numero: %q", key, v)
+	}
+	*dst = f
+	return nil
+}
+
+func setDuration(dst *time.Duration, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("config: %s debe ser una duracion (ej. 5s): %q", key, v)
+	}
+	*dst = d
+	return nil
+}
+
+// Valida que la configuracion sea utilizable
+func (c Config) Validate() error {
+	var errs []error
+
+	if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		errs = append(errs, fmt.Errorf("config: la uri de mongo es invalida: %q", c.Mongo.URI))
+	}
+	if strings.TrimSpace(c.Mongo.Database) == "" {
+		errs = append(errs, errors.New("config: la base de datos es obligatoria"))
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		errs = append(errs, errors.New("config: el timeout de conexion debe ser positivo"))
+	}
+	if c.Mongo.Timeouts.Read <= 0 || c.Mongo.Timeouts.Write <= 0 {
+		errs = append(errs, errors.New("config: los timeouts de operaciones de mongo deben ser positivos"))
+	}
+
+	if strings.TrimSpace(c.Server.Addr) == "" {
+		errs = append(errs, errors.New("config: la direccion del servidor es obligatoria"))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 {
+		errs = append(errs, errors.New("config: los timeouts del servidor no pueden ser negativos"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("config: el timeout de apagado debe ser positivo"))
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("config: nivel de log invalido: %q", c.Log.Level))
+	}
+
+	if c.Loans.LoanDays <= 0 {
+		errs = append(errs, errors.New("config: los dias de prestamo deben ser positivos"))
+	}
+	if c.Loans.MaxActiveLoans < 0 {
+		errs = append(errs, errors.New("config: el maximo de prestamos activos no puede ser negativo"))
+	}
+	if c.Loans.MaxRenewals < 0 || c.Loans.FinePerDay < 0 || c.Loans.MaxFine < 0 {
+		errs = append(errs, errors.New("config: las renovaciones y multas de prestamos no pueden ser negativas"))
+	}
+	if c.Loans.HoldPickupDays <= 0 {
+		errs = append(errs, errors.New("config: los dias para retirar una reserva deben ser positivos"))
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "none", "stdout":
+	case "otlp":
+		if strings.TrimSpace(c.Tracing.Endpoint) == "" {
+			errs = append(errs, errors.New("config: el endpoint OTLP es obligatorio con el exportador otlp"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("config: exportador de trazas invalido: %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("config: la proporcion de muestreo debe estar entre 0 y 1"))
+	}
+
+	if c.Auth.TokenSecret != "" && len(c.Auth.TokenSecret) < MinTokenSecretLength {
+		errs = append(errs, fmt.Errorf("config: el secreto de los tokens debe tener al menos %d caracteres", MinTokenSecretLength))
+	}
+	if c.Auth.TokenTTL <= 0 {
+		errs = append(errs, errors.New("config: la vigencia de los tokens de usuario debe ser positiva"))
+	}
+
+	if c.Outbox.PollInterval <= 0 || c.Outbox.BaseBackoff <= 0 || c.Outbox.MaxBackoff < c.Outbox.BaseBackoff {
+		errs = append(errs, errors.New("config: los intervalos del outbox son invalidos"))
+	}
+	if c.Outbox.BatchSize <= 0 || c.Outbox.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("config: el lote y los intentos del outbox deben ser positivos"))
+	}
+	if c.Webhooks.Timeout <= 0 || c.Webhooks.RetryInterval <= 0 || c.Webhooks.BaseBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.BaseBackoff {
+		errs = append(errs, errors.New("config: los intervalos de los webhooks son invalidos"))
+	}
+	if c.Webhooks.MaxAttempts <= 0 || c.Webhooks.Workers <= 0 {
+		errs = append(errs, errors.New("config: los intentos y los workers de los webhooks deben ser positivos"))
+	}
+	if c.Webhooks.Lease <= c.Webhooks.Timeout {
+		errs = append(errs, errors.New("config: el reclamo de las entregas de webhooks debe superar su limite de tiempo"))
+	}
+	if c.Stream.PollInterval <= 0 || c.Stream.Heartbeat <= 0 || c.Stream.GapTimeout <= 0 {
+		errs = append(errs, errors.New("config: los intervalos del flujo de eventos deben ser positivos"))
+	}
+	if c.Migrations.LockTTL <= 0 || c.Migrations.Timeout <= 0 {
+		errs = append(errs, errors.New("config: el bloqueo y el limite de las migraciones deben ser positivos"))
+	}
+	if c.Indexes.Timeout <= 0 {
+		errs = append(errs, errors.New("config: el limite de la sincronizacion de indices debe ser positivo"))
+	}
+	if _, _, err := c.API.LegacyDates(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.RateLimit.Default.validate("default"); err != nil {
+		errs = append(errs, err)
+	}
+	for group, limit := range c.RateLimit.Groups {
+		if err := limit.validate(group); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Idempotency.TTL <= 0 || c.Idempotency.LockTTL <= 0 {
+		errs = append(errs, errors.New("config: la vigencia de las claves de idempotencia debe ser positiva"))
+	}
+	if c.Idempotency.MaxRequestBody <= 0 {
+		errs = append(errs, errors.New("config: el tamaño maximo del cuerpo con Idempotency-Key debe ser positivo"))
+	}
+	if c.Notifications.DueSoonDays <= 0 || c.Notifications.SMTP.Timeout <= 0 {
+		errs = append(errs, errors.New("config: la anticipacion y el timeout de las notificaciones deben ser positivos"))
+	}
+	switch c.Notifications.Language {
+	case "es", "en":
+	default:
+		errs = append(errs, fmt.Errorf("config: idioma de notificaciones invalido: %q", c.Notifications.Language))
+	}
+	if c.Notifications.Enabled {
+		if _, _, err := net.SplitHostPort(c.Notifications.SMTP.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("config: la direccion smtp debe ser host:puerto: %q", c.Notifications.SMTP.Addr))
+		}
+		if _, err := mail.ParseAddress(c.Notifications.SMTP.From); err != nil {
+			errs = append(errs, fmt.Errorf("config: el remitente smtp es invalido: %q", c.Notifications.SMTP.From))
+		}
+	}
+	if c.Jobs.LockTTL <= 0 || c.Jobs.Timeout <= 0 {
+		errs = append(errs, errors.New("config: el bloqueo y el limite de las tareas deben ser positivos"))
+	}
+	for name, spec := range map[string]string{"overdue": c.Jobs.Overdue, "hold_expiry": c.Jobs.HoldExpiry, "notifications": c.Jobs.Notifications} {
+		if _, err := cron.ParseStandard(spec); err != nil {
+			errs = append(errs, fmt.Errorf("config: horario invalido de la tarea %s: %q", name, spec))
+		}
+	}
+
+	return errors.Join(errs...)
+}
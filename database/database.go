@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"backend/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Nombres de las colecciones del servicio
+const (
+	BooksCollection             = "books"
+	UsersCollection             = "users"
+	LoansCollection             = "loans"
+	AuditCollection             = "audit_log"
+	OutboxCollection            = "outbox"
+	WebhooksCollection          = "webhooks"
+	WebhookDeliveriesCollection = "webhook_deliveries"
+	IdempotencyCollection       = "idempotency_keys"
+	APIKeysCollection           = "api_keys"
+	HoldsCollection             = "holds"
+	NotificationsCollection     = "notifications_sent"
+	JobRunsCollection           = "job_runs"
+	CountersCollection          = "counters"
+)
+
+// Conecta a MongoDB y valida la conexion con un ping dentro del timeout de conexion.
+// Las opciones extra (por ejemplo monitores de comandos) se aplican despues de la uri.
+func Connect(ctx context.Context, cfg config.MongoConfig, opts ...*options.ClientOptions) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	opts = append([]*options.ClientOptions{options.Client().ApplyURI(cfg.URI)}, opts...)
+	client, err := mongo.Connect(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("database: no se pudo conectar a mongo: %w", err)
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("database: mongo no responde: %w", err)
+	}
+
+	return client, nil
+}
+
+// Cierra la conexion con MongoDB esperando a que terminen las operaciones en curso
+// hasta que venza el contexto recibido
+func Disconnect(ctx context.Context, client *mongo.Client) error {
+	if client == nil {
+		return nil
+	}
+	if err := client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("database: error al desconectar mongo: %w", err)
+	}
+	return nil
+}
+
+// Indica si el despliegue soporta transacciones: un replica set o un router mongos.
+// Un servidor standalone no las soporta.
+func SupportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, fmt.Errorf("database: no se pudo consultar la topologia: %w", err)
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
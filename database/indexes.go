@@ -0,0 +1,357 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Definicion declarativa de un indice. Las claves con valor "text" definen un indice de texto;
+// TTL mayor a cero define un indice que expira los documentos segun el campo de fecha;
+// ExpireAt expira cada documento exactamente en la fecha de su campo. Sparse omite los documentos
+// sin el campo, asi un indice unico solo restringe a los documentos que lo tienen.
+type IndexSpec struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	Sparse     bool
+	TTL        time.Duration
+	ExpireAt   bool
+}
+
+// Registro de los indices del servicio. Es la unica fuente de verdad: al sincronizar se
+// crean los que faltan y se recrean los que cambiaron.
+var IndexRegistry = []IndexSpec{
+	{Collection: BooksCollection, Name: "isbn_unique", Keys: bson.D{{Key: "isbn", Value: 1}}, Unique: true},
+	{Collection: BooksCollection, Name: "title_author_text", Keys: bson.D{{Key: "title", Value: "text"}, {Key: "author", Value: "text"}}},
+	{Collection: UsersCollection, Name: "email", Keys: bson.D{{Key: "email", Value: 1}}},
+	{Collection: LoansCollection, Name: "book_id", Keys: bson.D{{Key: "book_id", Value: 1}}},
+	{Collection: LoansCollection, Name: "user_open", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "is_returned", Value: 1}}},
+	{Collection: LoansCollection, Name: "open_due_date", Keys: bson.D{{Key: "is_returned", Value: 1}, {Key: "due_date", Value: 1}}},
+	// Cada prestamo activo ocupa un lugar del usuario, el limite de prestamos activos no se puede superar
+	{Collection: LoansCollection, Name: "active_slot_unique", Keys: bson.D{{Key: "active_slot", Value: 1}}, Unique: true, Sparse: true},
+	{Collection: HoldsCollection, Name: "user_status", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+	// Un usuario tiene a lo sumo una reserva vigente por libro
+	{Collection: HoldsCollection, Name: "active_key_unique", Keys: bson.D{{Key: "active_key", Value: 1}}, Unique: true, Sparse: true},
+	{Collection: HoldsCollection, Name: "book_status_created", Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	{Collection: AuditCollection, Name: "timestamp", Keys: bson.D{{Key: "timestamp", Value: -1}}},
+	{Collection: AuditCollection, Name: "entity", Keys: bson.D{{Key: "entity", Value: 1}, {Key: "entity_id", Value: 1}}},
+	{Collection: OutboxCollection, Name: "seq", Keys: bson.D{{Key: "seq", Value: 1}}},
+	{Collection: OutboxCollection, Name: "status_next_attempt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+	// Los eventos publicados se conservan una semana para reanudar el flujo de eventos
+	{Collection: OutboxCollection, Name: "published_ttl", Keys: bson.D{{Key: "published_at", Value: 1}}, TTL: 7 * 24 * time.Hour},
+	{Collection: WebhookDeliveriesCollection, Name: "subscription_event_unique", Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}}, Unique: true},
+	{Collection: WebhookDeliveriesCollection, Name: "status_next_attempt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+	{Collection: WebhookDeliveriesCollection, Name: "status_locked_until", Keys: bson.D{{Key: "status", Value: 1}, {Key: "locked_until", Value: 1}}},
+	{Collection: IdempotencyCollection, Name: "expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAt: true},
+	{Collection: APIKeysCollection, Name: "hash_unique", Keys: bson.D{{Key: "hash", Value: 1}}, Unique: true},
+	{Collection: JobRunsCollection, Name: "job_started", Keys: bson.D{{Key: "job", Value: 1}, {Key: "started_at", Value: -1}}},
+	// El historial de ejecuciones se conserva un mes
+	{Collection: JobRunsCollection, Name: "started_ttl", Keys: bson.D{{Key: "started_at", Value: 1}}, TTL: 30 * 24 * time.Hour},
+}
+
+// Acciones de una diferencia entre el registro y la base
+const (
+	DriftCreate   = "create"
+	DriftRecreate = "recreate"
+	DriftUnknown  = "unknown"
+	DriftDrop     = "drop"
+)
+
+// Diferencia entre el registro de indices y los indices existentes
+type IndexDrift struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason"`
+	// Indica si la accion se aplico, falso en modo de prueba y para indices desconocidos que se conservan
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Opciones de la sincronizacion de indices
+type SyncOptions struct {
+	// Solo reporta las diferencias sin modificar la base
+	DryRun bool
+	// Elimina los indices que no estan en el registro, por defecto solo se reportan
+	DropUnknown bool
+}
+
+// Sincroniza los indices con el registro y retorna las diferencias encontradas. Un indice
+// que no se puede crear no detiene la sincronizacion de los demas; los errores se combinan.
+func SyncIndexes(ctx context.Context, db *mongo.Database, registry []IndexSpec, opts SyncOptions) ([]IndexDrift, error) {
+	byCollection := map[string][]IndexSpec{}
+	for _, spec := range registry {
+		byCollection[spec.Collection] = append(byCollection[spec.Collection], spec)
+	}
+	collections := make([]string, 0, len(byCollection))
+	for name := range byCollection {
+		collections = append(collections, name)
+	}
+	sort.Strings(collections)
+
+	drift := []IndexDrift{}
+	var errs []error
+	fail := func(d *IndexDrift, err error) {
+		d.Error = err.Error()
+		errs = append(errs, fmt.Errorf("database: indice %s.%s: %w", d.Collection, d.Name, err))
+	}
+	for _, name := range collections {
+		coll := db.Collection(name)
+		existing, err := listIndexes(ctx, coll)
+		if err != nil {
+			return drift, fmt.Errorf("database: no se pudieron listar los indices de %s: %w", name, err)
+		}
+
+		declared := map[string]bool{}
+		for _, spec := range byCollection[name] {
+			declared[spec.Name] = true
+
+			current, ok := existing[spec.Name]
+			var d IndexDrift
+			switch {
+			case !ok:
+				d = IndexDrift{Collection: name, Name: spec.Name, Action: DriftCreate, Reason: "no existe"}
+			case !sameIndex(spec, current):
+				d = IndexDrift{Collection: name, Name: spec.Name, Action: DriftRecreate, Reason: "la definicion cambio"}
+			default:
+				continue
+			}
+
+			if !opts.DryRun {
+				if d.Action == DriftRecreate {
+					err = recreateIndex(ctx, coll, spec, current)
+				} else {
+					_, err = coll.Indexes().CreateOne(ctx, spec.model())
+				}
+				if err != nil {
+					fail(&d, err)
+				} else {
+					d.Applied = true
+				}
+			}
+			drift = append(drift, d)
+		}
+
+		// Indices que existen en la base pero no en el registro
+		unknown := make([]string, 0)
+		for indexName := range existing {
+			if indexName != "_id_" && !declared[indexName] {
+				unknown = append(unknown, indexName)
+			}
+		}
+		sort.Strings(unknown)
+		for _, indexName := range unknown {
+			d := IndexDrift{Collection: name, Name: indexName, Action: DriftUnknown, Reason: "no esta en el registro"}
+			if opts.DropUnknown {
+				d.Action = DriftDrop
+				if !opts.DryRun {
+					if _, err := coll.Indexes().DropOne(ctx, indexName); err != nil {
+						fail(&d, err)
+					} else {
+						d.Applied = true
+					}
+				}
+			}
+			drift = append(drift, d)
+		}
+	}
+	return drift, errors.Join(errs...)
+}
+
+// Sufijo del nombre con el que se prueba la nueva definicion de un indice antes de
+// eliminar la anterior
+const tempIndexSuffix = "_sync"
+
+// Recrea un indice cuya definicion cambio sin dejar la coleccion sin el. Si las claves
+// cambiaron, la nueva definicion se construye primero con un nombre temporal mientras el
+// indice anterior sigue vigente, de modo que una definicion que no se puede construir (por
+// ejemplo un indice unico con duplicados) no elimina el anterior. Mongo no admite dos indices
+// con las mismas claves con distinto nombre ni dos indices de texto, por eso el temporal se
+// elimina antes del reemplazo; si el reemplazo falla se restaura la definicion anterior.
+func recreateIndex(ctx context.Context, coll *mongo.Collection, spec IndexSpec, current indexInfo) error {
+	if !spec.isText() && !sameKeys(spec, current) {
+		temp := spec
+		temp.Name = spec.Name + tempIndexSuffix
+		if _, err := coll.Indexes().CreateOne(ctx, temp.model()); err != nil {
+			return err
+		}
+		if _, err := coll.Indexes().DropOne(ctx, temp.Name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := coll.Indexes().DropOne(ctx, spec.Name); err != nil {
+		return err
+	}
+	if _, err := coll.Indexes().CreateOne(ctx, spec.model()); err != nil {
+		if _, restoreErr := coll.Indexes().CreateOne(ctx, current.model()); restoreErr != nil {
+			return errors.Join(err, fmt.Errorf("no se pudo restaurar la definicion anterior: %w", restoreErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// Indice existente segun listIndexes
+type indexInfo struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	Weights            bson.M `bson:"weights"`
+}
+
+func listIndexes(ctx context.Context, coll *mongo.Collection) (map[string]indexInfo, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var list []indexInfo
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]indexInfo, len(list))
+	for _, info := range list {
+		indexes[info.Name] = info
+	}
+	return indexes, nil
+}
+
+// Compara la definicion declarada con la existente
+func sameIndex(spec IndexSpec, info indexInfo) bool {
+	if spec.Unique != info.Unique || spec.Sparse != info.Sparse {
+		return false
+	}
+
+	ttl, expires := spec.expireAfter()
+	if expires != (info.ExpireAfterSeconds != nil) || (info.ExpireAfterSeconds != nil && *info.ExpireAfterSeconds != ttl) {
+		return false
+	}
+
+	// Mongo guarda los indices de texto como {_fts: "text", _ftsx: 1} con los campos en weights
+	if spec.isText() {
+		fields := map[string]bool{}
+		for _, k := range spec.Keys {
+			fields[k.Key] = true
+		}
+		if len(fields) != len(info.Weights) {
+			return false
+		}
+		for field := range info.Weights {
+			if !fields[field] {
+				return false
+			}
+		}
+		return true
+	}
+
+	return sameKeys(spec, info)
+}
+
+// Compara las claves de un indice que no es de texto
+func sameKeys(spec IndexSpec, info indexInfo) bool {
+	if len(spec.Keys) != len(info.Key) {
+		return false
+	}
+	for i, k := range spec.Keys {
+		if k.Key != info.Key[i].Key || !sameDirection(k.Value, info.Key[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Las direcciones pueden venir como int32, int64 o double segun quien creo el indice
+func sameDirection(a, b interface{}) bool {
+	toFloat := func(v interface{}) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int32:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (s IndexSpec) isText() bool {
+	for _, k := range s.Keys {
+		if k.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Sparse {
+		opts.SetSparse(true)
+	}
+	if ttl, ok := s.expireAfter(); ok {
+		opts.SetExpireAfterSeconds(int32(ttl))
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// Definicion de un indice existente, para restaurarlo si su reemplazo falla
+func (i indexInfo) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name)
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if i.Sparse {
+		opts.SetSparse(true)
+	}
+	if i.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(int32(*i.ExpireAfterSeconds))
+	}
+
+	keys := i.Key
+	if len(i.Weights) > 0 {
+		// Los indices de texto se declaran con sus campos, no con las claves internas _fts
+		fields := make([]string, 0, len(i.Weights))
+		for field := range i.Weights {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		keys = bson.D{}
+		for _, field := range fields {
+			keys = append(keys, bson.E{Key: field, Value: "text"})
+		}
+		opts.SetWeights(i.Weights)
+	}
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
+// Segundos de expireAfterSeconds del indice, o falso si no es un indice TTL
+func (s IndexSpec) expireAfter() (int64, bool) {
+	if s.ExpireAt {
+		return 0, true
+	}
+	return int64(s.TTL / time.Second), s.TTL > 0
+}
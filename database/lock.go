@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Coleccion de los bloqueos distribuidos
+const LocksCollection = "locks"
+
+// Bloqueo distribuido guardado como un documento por nombre. Lo tiene un solo dueño a la
+// vez hasta que lo libera o vence su TTL, de modo que un proceso caido no lo retiene.
+type Lock struct {
+	Coll  *mongo.Collection
+	Name  string
+	Owner string
+	TTL   time.Duration
+}
+
+// Documento de un bloqueo
+type LockState struct {
+	Name      string    `json:"name" bson:"_id"`
+	Owner     string    `json:"owner" bson:"owner"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// Intenta tomar o renovar el bloqueo, retorna false si lo tiene otro dueño
+func (l *Lock) Acquire(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": l.Name,
+		"$or": bson.A{
+			bson.M{"owner": l.Owner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": l.Owner, "expires_at": now.Add(l.TTL)}}
+
+	// Si el documento existe pero no cumple el filtro, el upsert choca con el _id existente
+	_, err := l.Coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Espera hasta tomar el bloqueo consultando cada intervalo, o hasta que venza el contexto
+func (l *Lock) Wait(ctx context.Context, interval time.Duration) error {
+	for {
+		ok, err := l.Acquire(ctx)
+		if err != nil || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Renueva el bloqueo periodicamente hasta que se cancele el contexto. Retorna un canal que
+// se cierra si el bloqueo se pierde: otro dueño lo tomo o no se pudo renovar antes del TTL.
+func (l *Lock) KeepAlive(ctx context.Context) <-chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		ticker := time.NewTicker(l.TTL / 3)
+		defer ticker.Stop()
+
+		renewed := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := l.Acquire(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err == nil && !ok:
+				return
+			case err == nil:
+				renewed = time.Now()
+			case time.Since(renewed) >= l.TTL:
+				return
+			}
+		}
+	}()
+	return lost
+}
+
+// Libera el bloqueo si aun pertenece a este dueño
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.Coll.DeleteOne(ctx, bson.M{"_id": l.Name, "owner": l.Owner})
+	return err
+}
+
+// Retorna el estado actual del bloqueo, nil si nadie lo tiene
+func (l *Lock) State(ctx context.Context) (*LockState, error) {
+	var state LockState
+	err := l.Coll.FindOne(ctx, bson.M{"_id": l.Name}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
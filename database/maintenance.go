@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Filtro de los prestamos abiertos
+func OpenLoansFilter() bson.M {
+	return bson.M{"is_returned": false}
+}
+
+// Filtro de los prestamos abiertos con la fecha de devolucion vencida a la fecha indicada
+func OverdueLoansFilter(now time.Time) bson.M {
+	return bson.M{"is_returned": false, "due_date": bson.M{"$lt": now.UTC()}}
+}
+
+// Recupera los prestamos vencidos, los mas atrasados primero
+func OverdueLoans(ctx context.Context, loans *mongo.Collection, now time.Time) ([]models.Loan, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}})
+	cur, err := loans.Find(ctx, OverdueLoansFilter(now), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	overdue := []models.Loan{}
+	if err := cur.All(ctx, &overdue); err != nil {
+		return nil, err
+	}
+	return overdue, nil
+}
+
+// Cuenta los prestamos abiertos por libro
+func OpenLoansByBook(ctx context.Context, loans *mongo.Collection) (map[string]int, error) {
+	cur, err := loans.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: OpenLoansFilter()}},
+		{{Key: "$group", Value: bson.M{"_id": "$book_id", "open": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		BookId string `bson:"_id"`
+		Open   int    `bson:"open"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	open := make(map[string]int, len(rows))
+	for _, r := range rows {
+		open[r.BookId] = r.Open
+	}
+	return open, nil
+}
+
+// Cambio de disponibilidad de un libro al recalcularla
+type AvailabilityChange struct {
+	BookId    string `json:"book_id"`
+	Isbn      string `json:"isbn"`
+	Copies    int    `json:"copies"`
+	OpenLoans int    `json:"open_loans"`
+	Before    int    `json:"before"`
+	After     int    `json:"after"`
+}
+
+// Recalcula la disponibilidad de cada libro como ejemplares menos prestamos abiertos.
+// Los libros sin ejemplares registrados toman su disponibilidad actual como total de
+// ejemplares, asi la operacion es idempotente. Con dryRun solo retorna los cambios.
+func RecomputeAvailability(ctx context.Context, books, loans *mongo.Collection, dryRun bool) ([]AvailabilityChange, error) {
+	open, err := OpenLoansByBook(ctx, loans)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := books.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	changes := []AvailabilityChange{}
+	var writes []mongo.WriteModel
+	for cur.Next(ctx) {
+		var book models.Book
+		if err := cur.Decode(&book); err != nil {
+			return nil, err
+		}
+
+		copies := book.Copies
+		if copies <= 0 {
+			copies = book.Availability
+		}
+		after := max(copies-open[book.ID.Hex()], 0)
+		if after == book.Availability && copies == book.Copies {
+			continue
+		}
+
+		changes = append(changes, AvailabilityChange{
+			BookId:    book.ID.Hex(),
+			Isbn:      book.Isbn,
+			Copies:    copies,
+			OpenLoans: open[book.ID.Hex()],
+			Before:    book.Availability,
+			After:     after,
+		})
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": book.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"availability": after, "copies": copies}}))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if dryRun || len(writes) == 0 {
+		return changes, nil
+	}
+	if _, err := books.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Respaldo en JSON de las colecciones del catalogo
+type Dump struct {
+	Books []models.Book `json:"books"`
+	Users []models.User `json:"users"`
+	Loans []models.Loan `json:"loans"`
+}
+
+// Lee todos los libros, usuarios y prestamos de la base
+func Export(ctx context.Context, db *mongo.Database) (Dump, error) {
+	dump := Dump{Books: []models.Book{}, Users: []models.User{}, Loans: []models.Loan{}}
+	for name, dst := range map[string]interface{}{
+		BooksCollection: &dump.Books,
+		UsersCollection: &dump.Users,
+		LoansCollection: &dump.Loans,
+	} {
+		cur, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			return Dump{}, err
+		}
+		if err := cur.All(ctx, dst); err != nil {
+			return Dump{}, err
+		}
+	}
+	return dump, nil
+}
+
+// Documentos escritos por coleccion al importar
+type ImportCounts struct {
+	Books int `json:"books"`
+	Users int `json:"users"`
+	Loans int `json:"loans"`
+}
+
+// Importa un respaldo reemplazando los documentos con el mismo id; los documentos
+// sin id reciben uno nuevo. Importar dos veces el mismo respaldo no duplica datos.
+func Import(ctx context.Context, db *mongo.Database, dump Dump) (ImportCounts, error) {
+	var counts ImportCounts
+	var err error
+	if counts.Books, err = replaceAll(ctx, db.Collection(BooksCollection), dump.Books, func(b *models.Book) *primitive.ObjectID { return &b.ID }); err != nil {
+		return counts, err
+	}
+	if counts.Users, err = replaceAll(ctx, db.Collection(UsersCollection), dump.Users, func(u *models.User) *primitive.ObjectID { return &u.ID }); err != nil {
+		return counts, err
+	}
+	if counts.Loans, err = replaceAll(ctx, db.Collection(LoansCollection), dump.Loans, func(l *models.Loan) *primitive.ObjectID { return &l.ID }); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+func replaceAll[T any](ctx context.Context, coll *mongo.Collection, docs []T, id func(*T) *primitive.ObjectID) (int, error) {
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(docs))
+	for i := range docs {
+		docId := id(&docs[i])
+		if docId.IsZero() {
+			*docId = primitive.NewObjectID()
+		}
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": *docId}).
+			SetReplacement(docs[i]).
+			SetUpsert(true))
+	}
+
+	if _, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Combina varios monitores de comandos en uno solo, ya que el cliente de Mongo acepta
+// un unico monitor. Los eventos se entregan en el orden recibido.
+func ChainMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Started != nil {
+					m.Started(ctx, evt)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Succeeded != nil {
+					m.Succeeded(ctx, evt)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Failed != nil {
+					m.Failed(ctx, evt)
+				}
+			}
+		},
+	}
+}
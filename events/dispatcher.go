@@ -0,0 +1,214 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Despachador que lee los eventos pendientes del outbox y los publica con entrega
+// al menos una vez. Los eventos fallidos se reintentan con espera exponencial hasta
+// MaxAttempts, luego quedan en estado failed.
+type Dispatcher struct {
+	Outbox    *mongo.Collection
+	Publisher Publisher
+	Logger    *slog.Logger
+
+	// Intervalo entre lecturas del outbox cuando no hay eventos
+	Interval time.Duration
+	// Eventos procesados por lectura
+	BatchSize int
+	// Intentos antes de marcar el evento como failed
+	MaxAttempts int
+	// Espera base y maxima entre reintentos
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Tiempo durante el cual un evento reclamado no puede ser tomado por otra instancia
+	Lease time.Duration
+	// Identifica a la instancia que reclama los eventos, por defecto host:pid
+	Owner string
+}
+
+// Ejecuta el despachador hasta que se cancele el contexto
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger().Info("outbox dispatcher started")
+	defer d.logger().Info("outbox dispatcher stopped")
+
+	for {
+		n, err := d.DispatchOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger().Error("outbox dispatch failed", "error", err)
+		}
+
+		// Si el lote estaba lleno continua sin esperar
+		wait := d.Interval
+		if err == nil && n >= d.batchSize() {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Publica un lote de eventos pendientes y retorna cuantos proceso
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	processed := 0
+	for processed < d.batchSize() {
+		evt, err := d.claim(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return processed, nil
+		}
+		if err != nil {
+			return processed, err
+		}
+
+		processed++
+		if err := d.Publisher.Publish(ctx, evt); err != nil {
+			if err := d.fail(ctx, evt, err); err != nil {
+				return processed, err
+			}
+			continue
+		}
+
+		if err := d.markPublished(ctx, evt); err != nil {
+			return processed, err
+		}
+	}
+	return processed, nil
+}
+
+// Reclama el siguiente evento listo para publicar, incluidos los reclamados por
+// una instancia que no termino dentro del lease
+func (d *Dispatcher) claim(ctx context.Context) (Event, error) {
+	now := time.Now().UTC()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"status": StatusProcessing, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":       StatusProcessing,
+		"locked_until": now.Add(d.lease()),
+		"owner":        d.owner(),
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "occurred_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var evt Event
+	err := d.Outbox.FindOneAndUpdate(ctx, filter, update, opts).Decode(&evt)
+	return evt, err
+}
+
+func (d *Dispatcher) markPublished(ctx context.Context, evt Event) error {
+	return d.release(ctx, evt, bson.M{
+		"$set":   bson.M{"status": StatusPublished, "published_at": time.Now().UTC()},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"locked_until": "", "owner": "", "last_error": ""},
+	})
+}
+
+// Registra el fallo y programa el siguiente intento con espera exponencial
+func (d *Dispatcher) fail(ctx context.Context, evt Event, cause error) error {
+	attempts := evt.Attempts + 1
+	status := StatusPending
+	if d.MaxAttempts > 0 && attempts >= d.MaxAttempts {
+		status = StatusFailed
+	}
+
+	d.logger().Warn("event publish failed",
+		"event_id", evt.ID.Hex(), "type", evt.Type, "attempts", attempts, "status", status, "error", cause)
+
+	return d.release(ctx, evt, bson.M{
+		"$set": bson.M{
+			"status":          status,
+			"attempts":        attempts,
+			"last_error":      cause.Error(),
+			"next_attempt_at": time.Now().UTC().Add(Backoff(attempts, d.BaseBackoff, d.MaxBackoff)),
+		},
+		"$unset": bson.M{"locked_until": "", "owner": ""},
+	})
+}
+
+// Guarda el resultado de un evento reclamado solo si el reclamo sigue siendo de esta instancia;
+// si el lease vencio y otra instancia lo reclamo, su resultado prevalece
+func (d *Dispatcher) release(ctx context.Context, evt Event, update bson.M) error {
+	res, err := d.Outbox.UpdateOne(ctx, bson.M{"_id": evt.ID, "status": StatusProcessing, "owner": d.owner()}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		d.logger().Warn("outbox event lease lost", "event_id", evt.ID.Hex(), "type", evt.Type)
+	}
+	return nil
+}
+
+// Calcula la espera antes del intento numero attempt: base * 2^(attempt-1), con tope max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if max > 0 && d >= max {
+			return max
+		}
+	}
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+func (d *Dispatcher) batchSize() int {
+	if d.BatchSize <= 0 {
+		return 100
+	}
+	return d.BatchSize
+}
+
+func (d *Dispatcher) lease() time.Duration {
+	if d.Lease <= 0 {
+		return 30 * time.Second
+	}
+	return d.Lease
+}
+
+func (d *Dispatcher) owner() string {
+	if d.Owner != "" {
+		return d.Owner
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (d *Dispatcher) logger() *slog.Logger {
+	if d.Logger == nil {
+		return slog.Default()
+	}
+	return d.Logger
+}
+
+// Publisher que solo registra los eventos en el log, util mientras no hay otros destinos
+func LogPublisher(logger *slog.Logger) Publisher {
+	return PublisherFunc(func(ctx context.Context, evt Event) error {
+		logger.InfoContext(ctx, "domain event published",
+			"event_id", evt.ID.Hex(), "type", evt.Type, "aggregate_id", evt.AggregateId)
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tipos de eventos de dominio
+const (
+	BookCreated  = "BookCreated"
+	BookUpdated  = "BookUpdated"
+	BookDeleted  = "BookDeleted"
+	UserCreated  = "UserCreated"
+	UserUpdated  = "UserUpdated"
+	UserDeleted  = "UserDeleted"
+	LoanCreated  = "LoanCreated"
+	LoanReturned = "LoanReturned"
+	LoanRenewed  = "LoanRenewed"
+	LoanOverdue  = "LoanOverdue"
+	HoldPlaced   = "HoldPlaced"
+	HoldCanceled = "HoldCanceled"
+	HoldReady    = "HoldReady"
+	HoldExpired  = "HoldExpired"
+)
+
+// Todos los tipos de eventos de dominio
+var Types = []string{
+	BookCreated, BookUpdated, BookDeleted,
+	UserCreated, UserUpdated, UserDeleted,
+	LoanCreated, LoanReturned, LoanRenewed, LoanOverdue,
+	HoldPlaced, HoldCanceled, HoldReady, HoldExpired,
+}
+
+// Estados de un evento en el outbox
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusPublished  = "published"
+	StatusFailed     = "failed"
+)
+
+// Evento de dominio guardado en el outbox. Seq es su numero en la secuencia del outbox,
+// que sigue el orden en que se confirman los eventos.
+type Event struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Seq         int64              `json:"seq" bson:"seq,omitempty"`
+	Type        string             `json:"type" bson:"type"`
+	AggregateId string             `json:"aggregate_id" bson:"aggregate_id"`
+	Payload     bson.M             `json:"payload" bson:"payload"`
+	RequestId   string             `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	OccurredAt  time.Time          `json:"occurred_at" bson:"occurred_at"`
+
+	// Estado de la entrega
+	Status        string    `json:"-" bson:"status"`
+	Attempts      int       `json:"-" bson:"attempts"`
+	NextAttemptAt time.Time `json:"-" bson:"next_attempt_at"`
+	LockedUntil   time.Time `json:"-" bson:"locked_until,omitempty"`
+	Owner         string    `json:"-" bson:"owner,omitempty"`
+	LastError     string    `json:"-" bson:"last_error,omitempty"`
+	PublishedAt   time.Time `json:"-" bson:"published_at,omitempty"`
+}
+
+// Crea un evento pendiente de publicacion
+func New(eventType, aggregateId string, payload bson.M, requestId string) Event {
+	now := time.Now().UTC()
+	return Event{
+		ID:            primitive.NewObjectID(),
+		Type:          eventType,
+		AggregateId:   aggregateId,
+		Payload:       payload,
+		RequestId:     requestId,
+		OccurredAt:    now,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+	}
+}
+
+// Destino de los eventos despachados desde el outbox. Debe ser idempotente:
+// la entrega es al menos una vez, un mismo evento puede publicarse mas de una vez.
+type Publisher interface {
+	Publish(ctx context.Context, evt Event) error
+}
+
+// Adaptador para usar una funcion como Publisher
+type PublisherFunc func(ctx context.Context, evt Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, evt Event) error {
+	return f(ctx, evt)
+}
+
+// Publica cada evento en todos los destinos, retorna los errores combinados
+type Fanout []Publisher
+
+func (f Fanout) Publish(ctx context.Context, evt Event) error {
+	var errs []error
+	for _, p := range f {
+		if err := p.Publish(ctx, evt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
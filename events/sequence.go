@@ -0,0 +1,70 @@
+package events
+
+import (
+	"context"
+
+	"backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Contador de la secuencia del outbox en la coleccion de contadores
+const sequenceCounter = "outbox"
+
+// Guarda un evento en el outbox con el siguiente numero de la secuencia. Dentro de una
+// transaccion el contador queda bloqueado hasta el commit y las demas transacciones que
+// emiten eventos se reintentan, de modo que la secuencia sigue el orden en que se confirman
+// los eventos y un abort no deja huecos. Sin transacciones un evento puede ser visible antes
+// que otro con un numero menor, o un numero puede quedar sin evento; el flujo espera esos
+// huecos antes de saltarlos.
+//
+// Todas las transacciones que emiten eventos actualizan el mismo documento del contador, por
+// lo que se confirman de a una: las escrituras por segundo quedan limitadas por lo que tarda
+// cada transaccion entre su Append y su commit, y con mucha concurrencia aumentan los
+// reintentos por conflicto de escritura. Conviene llamarlo al final de la transaccion para
+// acortar ese intervalo.
+func Append(ctx context.Context, outbox *mongo.Collection, evt *Event) error {
+	seq, err := reserve(ctx, outbox, 1)
+	if err != nil {
+		return err
+	}
+
+	evt.Seq = seq
+	_, err = outbox.InsertOne(ctx, evt)
+	return err
+}
+
+// Guarda varios eventos en el outbox con numeros consecutivos de la secuencia, en el orden
+// del slice, usando una sola actualizacion del contador y una sola insercion. Asigna Seq a
+// cada evento y tiene las mismas garantias que Append.
+func AppendAll(ctx context.Context, outbox *mongo.Collection, evts []Event) error {
+	if len(evts) == 0 {
+		return nil
+	}
+
+	last, err := reserve(ctx, outbox, len(evts))
+	if err != nil {
+		return err
+	}
+
+	docs := make([]interface{}, len(evts))
+	for i := range evts {
+		evts[i].Seq = last - int64(len(evts)-1-i)
+		docs[i] = evts[i]
+	}
+	_, err = outbox.InsertMany(ctx, docs)
+	return err
+}
+
+// Reserva n numeros de la secuencia y retorna el ultimo
+func reserve(ctx context.Context, outbox *mongo.Collection, n int) (int64, error) {
+	counters := outbox.Database().Collection(database.CountersCollection)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := counters.FindOneAndUpdate(ctx, bson.M{"_id": sequenceCounter}, bson.M{"$inc": bson.M{"seq": n}}, opts).Decode(&counter)
+	return counter.Seq, err
+}
@@ -0,0 +1,268 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tipos de eventos que cambian la disponibilidad de los libros o el estado de los prestamos
+var AvailabilityTypes = []string{BookCreated, BookUpdated, BookDeleted, LoanCreated, LoanReturned}
+
+// Flujo de eventos en vivo leido del outbox. Usa change streams cuando Mongo los soporta
+// y si no consulta el outbox periodicamente. El id de cada evento es su numero de secuencia,
+// asignado al confirmarse, de modo que un cliente puede reanudar el flujo desde el ultimo
+// evento recibido sin perder los de otras instancias ni los de transacciones lentas.
+type Stream struct {
+	Outbox *mongo.Collection
+	Logger *slog.Logger
+
+	// Intervalo entre consultas cuando no hay change streams
+	PollInterval time.Duration
+	// Eventos leidos por consulta
+	BatchSize int
+	// Espera maxima de un numero de secuencia que aun no es visible antes de saltarlo
+	GapTimeout time.Duration
+}
+
+// Posicion de un cliente en la secuencia del outbox
+type cursor struct {
+	last int64
+	// Primer numero faltante de la secuencia y desde cuando se espera
+	gap      int64
+	gapSince time.Time
+}
+
+// Filtro de los eventos de disponibilidad, opcionalmente de un solo libro
+func AvailabilityFilter(bookId string) bson.M {
+	filter := bson.M{"type": bson.M{"$in": AvailabilityTypes}}
+	if bookId != "" {
+		// Los eventos de libros usan el libro como agregado, los de prestamos lo llevan en el payload
+		filter["$or"] = bson.A{
+			bson.M{"aggregate_id": bookId},
+			bson.M{"payload.book_id": bookId},
+		}
+	}
+	return filter
+}
+
+// Envia a fn los eventos que cumplen el filtro hasta que se cancele el contexto o fn falle.
+// Si after no es cero primero reproduce los eventos con secuencia posterior a after, si es
+// cero solo envia los eventos nuevos.
+func (s *Stream) Tail(ctx context.Context, after int64, filter bson.M, fn func(Event) error) error {
+	// La posicion inicial se lee antes de abrir el change stream: los eventos confirmados entre
+	// ambos pasos los envia la reproduccion y los repetidos por el change stream se descartan
+	if after == 0 {
+		latest, err := s.latest(ctx)
+		if err != nil {
+			return err
+		}
+		after = latest
+	}
+
+	// El change stream se abre antes de reproducir para no perder eventos entre ambos pasos
+	cs, err := s.Outbox.Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: changeFilter(filter)}}})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logger().Debug("change streams not available, polling the outbox", "error", err)
+		cs = nil
+	} else {
+		defer cs.Close(context.Background())
+	}
+
+	cur := &cursor{last: after}
+	if err := s.replay(ctx, cur, filter, fn); err != nil {
+		return err
+	}
+
+	if cs != nil {
+		// Con change streams Mongo soporta transacciones y los eventos llegan en el orden de
+		// la secuencia, sin huecos
+		for cs.Next(ctx) {
+			var change struct {
+				FullDocument Event `bson:"fullDocument"`
+			}
+			if err := cs.Decode(&change); err != nil {
+				return err
+			}
+			// Descarta los eventos ya enviados durante la reproduccion
+			if change.FullDocument.Seq <= cur.last {
+				continue
+			}
+			if err := fn(change.FullDocument); err != nil {
+				return err
+			}
+			cur.last = change.FullDocument.Seq
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logger().Warn("change stream closed, polling the outbox", "error", cs.Err())
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.pollInterval()):
+		}
+
+		if err := s.replay(ctx, cur, filter, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// Envia en orden los eventos con secuencia posterior a la del cursor y lo avanza. Un numero
+// faltante puede ser un evento que aun no se confirma: el cursor se detiene en el hueco
+// hasta GapTimeout y luego lo salta, para no bloquear el flujo por un evento que no se guardo.
+func (s *Stream) replay(ctx context.Context, cur *cursor, filter bson.M, fn func(Event) error) error {
+	for {
+		// Secuencias visibles sin filtrar, para detectar los huecos
+		opts := options.Find().
+			SetSort(bson.D{{Key: "seq", Value: 1}}).
+			SetLimit(int64(s.batchSize())).
+			SetProjection(bson.M{"seq": 1})
+		found, err := s.Outbox.Find(ctx, bson.M{"seq": bson.M{"$gt": cur.last}}, opts)
+		if err != nil {
+			return err
+		}
+		var seqs []struct {
+			Seq int64 `bson:"seq"`
+		}
+		if err := found.All(ctx, &seqs); err != nil {
+			return err
+		}
+
+		upto := cur.last
+		for _, doc := range seqs {
+			if doc.Seq != upto+1 && !cur.skipGap(upto+1, s.gapTimeout()) {
+				break
+			}
+			upto = doc.Seq
+		}
+		if upto == cur.last {
+			return nil
+		}
+
+		query := bson.M{"$and": bson.A{filter, bson.M{"seq": bson.M{"$gt": cur.last, "$lte": upto}}}}
+		found, err = s.Outbox.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+		if err != nil {
+			return err
+		}
+		var batch []Event
+		if err := found.All(ctx, &batch); err != nil {
+			return err
+		}
+		for _, evt := range batch {
+			if err := fn(evt); err != nil {
+				return err
+			}
+		}
+		cur.last = upto
+
+		if len(seqs) < s.batchSize() {
+			return nil
+		}
+	}
+}
+
+// Indica si el hueco que empieza en seq ya se espero lo suficiente para saltarlo
+func (c *cursor) skipGap(seq int64, timeout time.Duration) bool {
+	now := time.Now()
+	if c.gap != seq {
+		c.gap, c.gapSince = seq, now
+		return false
+	}
+	return now.Sub(c.gapSince) >= timeout
+}
+
+// Retorna la secuencia del evento mas reciente del outbox, cero si esta vacio
+func (s *Stream) latest(ctx context.Context) (int64, error) {
+	var evt Event
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}).SetProjection(bson.M{"seq": 1})
+	err := s.Outbox.FindOne(ctx, bson.M{}, opts).Decode(&evt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return evt.Seq, err
+}
+
+// Retorna la secuencia de un evento por su id, para los clientes que reanudan con el id
+// del evento. Retorna cero si el evento no existe.
+func (s *Stream) SeqOf(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var evt Event
+	err := s.Outbox.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"seq": 1})).Decode(&evt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return evt.Seq, err
+}
+
+// Traduce un filtro del outbox a un filtro sobre las inserciones del change stream
+func changeFilter(filter bson.M) bson.M {
+	prefixed := prefixFields(filter, "fullDocument.")
+	prefixed["operationType"] = "insert"
+	return prefixed
+}
+
+func prefixFields(filter bson.M, prefix string) bson.M {
+	out := bson.M{}
+	for k, v := range filter {
+		if !strings.HasPrefix(k, "$") {
+			out[prefix+k] = v
+			continue
+		}
+		// Operadores logicos como $or y $and contienen filtros anidados
+		if list, ok := v.(bson.A); ok {
+			nested := bson.A{}
+			for _, item := range list {
+				if m, ok := item.(bson.M); ok {
+					nested = append(nested, prefixFields(m, prefix))
+				} else {
+					nested = append(nested, item)
+				}
+			}
+			v = nested
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func (s *Stream) pollInterval() time.Duration {
+	if s.PollInterval <= 0 {
+		return time.Second
+	}
+	return s.PollInterval
+}
+
+func (s *Stream) gapTimeout() time.Duration {
+	if s.GapTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return s.GapTimeout
+}
+
+func (s *Stream) batchSize() int {
+	if s.BatchSize <= 0 {
+		return 100
+	}
+	return s.BatchSize
+}
+
+func (s *Stream) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
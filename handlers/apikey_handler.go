@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"backend/apikeys"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cuerpo de la emision de una API key
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// Emite una API key con sus alcances. La key en claro solo se retorna en esta respuesta.
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.APIKeys == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var req apiKeyRequest
+
+	if err := c.Bind(&req); err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errorJSON(c, http.StatusBadRequest, "El nombre es obligatorio")
+	}
+	if err := apikeys.ValidateScopes(req.Scopes); err != nil {
+		return errorJSON(c, http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := h.dbContext(c, "api_keys.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	var key apikeys.Key
+	err := h.withTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if key, err = h.APIKeys.Issue(ctx, req.Name, req.Scopes); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCreate, "api_key", key.ID.Hex(), nil, withoutKey(key))
+	})
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "api key created", "key_id", key.ID.Hex(), "scopes", key.Scopes)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "API key creada exitosamente",
+		"data"    : key,
+	})
+}
+
+// Recupera las API keys, sin las keys en claro
+func (h *Handler) GetAPIKeys(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.APIKeys == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	ctx, cancel := h.dbContext(c, "api_keys.Find", h.Timeouts.Read)
+	defer cancel()
+	keys, err := h.APIKeys.List(ctx)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Lista de API keys encontrada",
+		"data"    : keys,
+	})
+}
+
+// Reemplaza una API key por una nueva con los mismos alcances; la anterior deja de valer
+func (h *Handler) RotateAPIKey(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.APIKeys == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	ctx, cancel := h.dbContext(c, "api_keys.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	var key apikeys.Key
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if key, err = h.APIKeys.Rotate(ctx, id); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditRotate, "api_key", id.Hex(), nil, withoutKey(key))
+	})
+	if err == apikeys.ErrNotFound {
+		return errorJSON(c, http.StatusNotFound, "API key no encontrada")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "api key rotated", "key_id", id.Hex())
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "API key rotada exitosamente",
+		"data"    : key,
+	})
+}
+
+// Revoca una API key. La key se conserva en el listado con su fecha de revocacion.
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.APIKeys == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	ctx, cancel := h.dbContext(c, "api_keys.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	var key apikeys.Key
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if key, err = h.APIKeys.Revoke(ctx, id); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditRevoke, "api_key", id.Hex(), nil, key)
+	})
+	if err == apikeys.ErrNotFound {
+		return errorJSON(c, http.StatusNotFound, "API key no encontrada")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "api key revoked", "key_id", id.Hex())
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "API key revocada exitosamente",
+		"data"    : key,
+	})
+}
+
+// Emite un token de usuario firmado para un usuario existente
+func (h *Handler) CreateUserToken(c echo.Context) error {
+	// Valida la conexion a la coleccion y la firma de tokens
+	if h.Users == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+	if h.Tokens == nil {
+		return errorJSON(c, http.StatusNotFound, "Tokens de usuario deshabilitados")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	ctx, cancel := h.dbContext(c, "users.CountDocuments", h.Timeouts.Read)
+	defer cancel()
+	n, err := h.Users.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return h.dbError(c, err)
+	}
+	if n == 0 {
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
+	}
+
+	token, expires, err := h.Tokens.Issue(id.Hex())
+	if err != nil {
+		h.log().ErrorContext(c.Request().Context(), "user token not issued", "user_id", id.Hex(), "error", err)
+		return errorJSON(c, http.StatusInternalServerError, "No se pudo emitir el token")
+	}
+
+	h.log().InfoContext(c.Request().Context(), "user token issued", "user_id", id.Hex(), "expires_at", expires)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "Token emitido exitosamente",
+		"data"    : echo.Map{"token": token, "expires_at": expires.UTC()},
+	})
+}
+
+// Oculta la key en claro en la auditoria
+func withoutKey(key apikeys.Key) apikeys.Key {
+	key.Secret = ""
+	return key
+}
\ No newline at end of file
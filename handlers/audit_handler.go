@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+	"backend/logging"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Acciones registradas en la auditoria
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditReturn = "return"
+	AuditRotate = "rotate"
+	AuditRevoke = "revoke"
+	AuditRenew  = "renew"
+	AuditCancel = "cancel"
+	AuditRun    = "run"
+)
+
+// Limite de entradas por consulta de auditoria
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// Registra una entrada de auditoria para una mutacion. La coleccion es de solo insercion:
+// ningun handler actualiza ni elimina entradas. Se llama con el contexto de la transaccion
+// del cambio para que ambos se confirmen juntos; sin transaccion la entrada se escribe
+// aunque el cliente se desconecte y el error se retorna para no ocultar la falta.
+func (h *Handler) recordAudit(ctx context.Context, c echo.Context, action, entity, entityId string, before, after interface{}) error {
+	return h.recordAudits(ctx, c, []models.AuditEntry{auditEntry(c, action, entity, entityId, before, after)})
+}
+
+// Crea una entrada de auditoria con el actor y el request id de la peticion
+func auditEntry(c echo.Context, action, entity, entityId string, before, after interface{}) models.AuditEntry {
+	return models.AuditEntry{
+		Actor:     auth.Actor(c),
+		Action:    action,
+		Entity:    entity,
+		EntityId:  entityId,
+		Before:    snapshot(before),
+		After:     snapshot(after),
+		RequestId: logging.RequestID(c),
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// Registra varias entradas de auditoria con una sola escritura, como recordAudit
+func (h *Handler) recordAudits(ctx context.Context, c echo.Context, entries []models.AuditEntry) error {
+	if h.Audit == nil || len(entries) == 0 {
+		return nil
+	}
+
+	if mongo.SessionFromContext(ctx) == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithoutCancel(ctx), func() {}
+		if h.Timeouts.Write > 0 {
+			ctx, cancel = context.WithTimeout(ctx, h.Timeouts.Write)
+		}
+		defer cancel()
+	}
+
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+	if _, err := h.Audit.InsertMany(ctx, docs); err != nil {
+		h.log().ErrorContext(c.Request().Context(), "audit entry not recorded",
+			"action", entries[0].Action, "entity", entries[0].Entity, "entity_id", entries[0].EntityId,
+			"entries", len(entries), "error", err)
+		return err
+	}
+	return nil
+}
+
+// Recupera las entradas de auditoria filtradas por entidad, id, accion, actor, request id y fechas
+func (h *Handler) GetAudit(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Audit == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	// Construye el filtro con los parametros de consulta
+	filter := bson.M{}
+	for param, field := range map[string]string{
+		"entity":     "entity",
+		"entity_id":  "entity_id",
+		"action":     "action",
+		"actor":      "actor",
+		"request_id": "request_id",
+	} {
+		if v := c.QueryParam(param); v != "" {
+			filter[field] = v
+		}
+	}
+
+	timestamp := bson.M{}
+	if v := c.QueryParam("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errorJSON(c, http.StatusBadRequest, "Fecha from invalida, use RFC3339")
+		}
+		timestamp["$gte"] = from
+	}
+	if v := c.QueryParam("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errorJSON(c, http.StatusBadRequest, "Fecha to invalida, use RFC3339")
+		}
+		timestamp["$lte"] = to
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	limit := defaultAuditLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			return errorJSON(c, http.StatusBadRequest, "Limite invalido")
+		}
+		limit = n
+	}
+
+	// Recupera las entradas mas recientes primero
+	ctx, cancel := h.dbContext(c, "audit.Find", h.Timeouts.Read)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
+	cur, err := h.Audit.Find(ctx, filter, opts)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	entries := []models.AuditEntry{}
+	if err := cur.All(ctx, &entries); err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Auditoria encontrada",
+		"data"    : entries,
+	})
+}
+
+// Convierte un modelo en un documento para guardarlo como snapshot, nil si no existe
+func snapshot(v interface{}) bson.M {
+	if v == nil {
+		return nil
+	}
+	if doc, ok := v.(bson.M); ok {
+		return doc
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
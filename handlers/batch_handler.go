@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/events"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operaciones de un lote
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// Elementos permitidos por lote
+const maxBatchSize = 1000
+
+// Peticion de un lote. En modo ordenado (por defecto) el lote se detiene en el primer
+// fallo y los elementos siguientes se reportan como omitidos; en modo no ordenado se
+// ejecutan todos los elementos validos.
+type BatchRequest struct {
+	Ordered    *bool       `json:"ordered"`
+	Operations []BatchItem `json:"operations"`
+}
+
+// Elemento de un lote
+type BatchItem struct {
+	Op   string          `json:"op"`
+	Id   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Resultado de un elemento del lote, Index es su posicion en la peticion
+type BatchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Id      string `json:"id,omitempty"`
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+}
+
+// Resumen de un lote
+type BatchReport struct {
+	Ordered   bool          `json:"ordered"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Results   []BatchResult `json:"results"`
+}
+
+// Describe como validar y guardar una entidad en un lote
+type batchSpec struct {
+	entity string
+	coll   *mongo.Collection
+	// Tipos de evento para creacion, actualizacion y eliminacion
+	created, updated, deleted string
+	// Valida los datos de un elemento y retorna los campos a guardar, o el mensaje de error
+	fields func(data json.RawMessage) (bson.M, string)
+}
+
+// Crea, actualiza y elimina libros en un lote
+func (h *Handler) BatchBooks(c echo.Context) error {
+	return h.batch(c, batchSpec{
+		entity:  "book",
+		coll:    h.Books,
+		created: events.BookCreated,
+		updated: events.BookUpdated,
+		deleted: events.BookDeleted,
+		fields: func(data json.RawMessage) (bson.M, string) {
+			var book models.Book
+			if err := json.Unmarshal(data, &book); err != nil {
+				return nil, "Input invalido"
+			}
+			book.Isbn = models.NormalizeIsbn(book.Isbn)
+			if _, message := validateBook(book); message != "" {
+				return nil, message
+			}
+			return bson.M{"title": book.Title, "author": book.Author, "isbn": book.Isbn, "availability": book.Availability}, ""
+		},
+	})
+}
+
+// Crea, actualiza y elimina usuarios en un lote
+func (h *Handler) BatchUsers(c echo.Context) error {
+	return h.batch(c, batchSpec{
+		entity:  "user",
+		coll:    h.Users,
+		created: events.UserCreated,
+		updated: events.UserUpdated,
+		deleted: events.UserDeleted,
+		fields: func(data json.RawMessage) (bson.M, string) {
+			var user models.User
+			if err := json.Unmarshal(data, &user); err != nil {
+				return nil, "Input invalido"
+			}
+			if strings.TrimSpace(user.Name) == "" {
+				return nil, "El nombre es obligatorio"
+			}
+			if strings.TrimSpace(user.Email) == "" {
+				return nil, "El correo electronico es obligatorio"
+			}
+			return bson.M{"name": user.Name, "email": user.Email}, ""
+		},
+	})
+}
+
+// Ejecuta un lote: valida cada elemento, aplica los validos y reporta el resultado de cada
+// uno con su indice. Los elementos se escriben con un BulkWrite y se confirman junto con sus
+// eventos del outbox y su auditoria, de modo que un elemento aplicado nunca queda sin evento.
+func (h *Handler) batch(c echo.Context, spec batchSpec) error {
+	// Valida la conexion a la coleccion
+	if spec.coll == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var req BatchRequest
+	if err := c.Bind(&req); err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
+	}
+	if len(req.Operations) == 0 {
+		return errorJSON(c, http.StatusBadRequest, "El lote esta vacio")
+	}
+	if len(req.Operations) > maxBatchSize {
+		return errorJSON(c, http.StatusBadRequest, "El lote supera el maximo de elementos")
+	}
+	ordered := req.Ordered == nil || *req.Ordered
+
+	report := BatchReport{Ordered: ordered, Results: make([]BatchResult, len(req.Operations))}
+	ids := make([]primitive.ObjectID, len(req.Operations))
+	sets := make([]bson.M, len(req.Operations))
+
+	// Valida los elementos y reune los ids de los documentos existentes
+	var existingIds []primitive.ObjectID
+	for i, item := range req.Operations {
+		result := &report.Results[i]
+		result.Index, result.Op, result.Status = i, item.Op, http.StatusBadRequest
+
+		switch item.Op {
+		case BatchCreate:
+			ids[i] = primitive.NewObjectID()
+		case BatchUpdate, BatchDelete:
+			id, err := primitive.ObjectIDFromHex(item.Id)
+			if err != nil {
+				result.Error = "Id invalido"
+				continue
+			}
+			ids[i] = id
+			existingIds = append(existingIds, id)
+		default:
+			result.Error = "Operacion invalida, use create, update o delete"
+			continue
+		}
+		result.Id = ids[i].Hex()
+
+		if item.Op != BatchDelete {
+			set, message := spec.fields(item.Data)
+			if message != "" {
+				result.Error = message
+				continue
+			}
+			sets[i] = set
+		}
+		result.Status = 0
+	}
+
+	// Recupera los documentos a actualizar o eliminar para detectar los inexistentes y auditar
+	before := map[primitive.ObjectID]bson.M{}
+	if len(existingIds) > 0 {
+		ctx, cancel := h.dbContext(c, spec.entity+"s.Find", h.Timeouts.Read)
+		cur, err := spec.coll.Find(ctx, bson.M{"_id": bson.M{"$in": existingIds}})
+		var docs []bson.M
+		if err == nil {
+			err = cur.All(ctx, &docs)
+		}
+		cancel()
+		if err != nil {
+			return h.dbError(c, err)
+		}
+		for _, doc := range docs {
+			id, _ := doc["_id"].(primitive.ObjectID)
+			before[id] = doc
+		}
+	}
+
+	// Reune los elementos validos. En modo ordenado el lote se detiene en el primer fallo.
+	var pending []int
+	stopped := false
+	for i, item := range req.Operations {
+		result := &report.Results[i]
+		if stopped {
+			result.Status, result.Error, result.Skipped = 0, "", true
+			continue
+		}
+		if result.Status == 0 && item.Op != BatchCreate && before[ids[i]] == nil {
+			result.Status, result.Error = http.StatusNotFound, "Documento no encontrado"
+		}
+		if result.Status != 0 {
+			stopped = ordered
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// Aplica los elementos validos con un BulkWrite. Dentro de una transaccion un error de
+	// escritura la aborta: los elementos que fallaron se reportan y el resto se reintenta sin
+	// ellos, en modo ordenado sin los siguientes al primer fallo, que se omiten.
+	ctx, cancel := h.dbContext(c, spec.entity+"s.BulkWrite", h.Timeouts.Write)
+	defer cancel()
+	for len(pending) > 0 {
+		var out batchOutcome
+		err := h.withTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			out, err = h.batchWrite(ctx, c, spec, ordered, req.Operations, pending, ids, sets, before)
+			return err
+		})
+		if err != nil && !errors.Is(err, errBatchRetry) && !writeConcernOnly(err) {
+			return h.dbError(c, err)
+		} else if err != nil && writeConcernOnly(err) {
+			h.log().WarnContext(ctx, "batch write concern not satisfied", "entity", spec.entity, "error", err)
+		}
+
+		var remaining []int
+		for n, i := range pending {
+			result := &report.Results[i]
+			switch {
+			case out.failed[n] != nil:
+				result.Status, result.Error = http.StatusInternalServerError, "Error al guardar el elemento"
+				if mongo.IsDuplicateKeyError(out.failed[n]) {
+					result.Status, result.Error = http.StatusConflict, "Ya existe un documento con los mismos datos"
+				} else {
+					h.log().ErrorContext(c.Request().Context(), "batch item failed",
+						"entity", spec.entity, "index", i, "error", out.failed[n])
+				}
+			case out.skipFrom >= 0 && n > out.skipFrom:
+				result.Skipped = true
+			case out.missing[n]:
+				result.Status, result.Error = http.StatusNotFound, "Documento no encontrado"
+			case errors.Is(err, errBatchRetry):
+				remaining = append(remaining, i)
+			case req.Operations[i].Op == BatchCreate:
+				result.Status = http.StatusCreated
+			default:
+				result.Status = http.StatusOK
+			}
+		}
+		pending = remaining
+	}
+
+	for _, r := range report.Results {
+		switch {
+		case r.Skipped:
+			report.Skipped++
+		case r.Status >= 400:
+			report.Failed++
+		default:
+			report.Succeeded++
+		}
+	}
+
+	h.log().InfoContext(c.Request().Context(), "batch applied", "entity", spec.entity, "ordered", ordered,
+		"succeeded", report.Succeeded, "failed", report.Failed, "skipped", report.Skipped)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Lote procesado",
+		"data"    : report,
+	})
+}
+
+// Copia los campos de un documento agregando su id
+func withId(id primitive.ObjectID, fields bson.M) bson.M {
+	doc := bson.M{"_id": id}
+	for k, v := range fields {
+		doc[k] = v
+	}
+	return doc
+}
+
+// Indica que la transaccion del lote se aborto por errores de escritura y los demas
+// elementos deben reintentarse
+var errBatchRetry = errors.New("batch write aborted")
+
+// Resultado de un BulkWrite del lote, indexado por la posicion del elemento en pending
+type batchOutcome struct {
+	// Error de escritura de cada elemento que fallo
+	failed map[int]error
+	// Elementos a actualizar que ya no existian al escribir
+	missing map[int]bool
+	// En modo ordenado, posicion del primer fallo: los elementos siguientes no se ejecutaron
+	skipFrom int
+}
+
+// Escribe los elementos pending del lote con un BulkWrite y registra los eventos del outbox
+// y la auditoria de los aplicados con una escritura cada uno. Dentro de una transaccion un
+// error de escritura retorna errBatchRetry para abortarla; sin transaccion el BulkWrite ya
+// aplico los demas elementos y solo esos se registran. Las actualizaciones se releen despues
+// de escribir para detectar los documentos eliminados en paralelo y auditar el resultado.
+func (h *Handler) batchWrite(ctx context.Context, c echo.Context, spec batchSpec, ordered bool, ops []BatchItem, pending []int, ids []primitive.ObjectID, sets []bson.M, before map[primitive.ObjectID]bson.M) (batchOutcome, error) {
+	out := batchOutcome{failed: map[int]error{}, missing: map[int]bool{}, skipFrom: -1}
+
+	writes := make([]mongo.WriteModel, len(pending))
+	for n, i := range pending {
+		switch ops[i].Op {
+		case BatchCreate:
+			writes[n] = mongo.NewInsertOneModel().SetDocument(withId(ids[i], sets[i]))
+		case BatchUpdate:
+			writes[n] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": ids[i]}).SetUpdate(bson.M{"$set": sets[i]})
+		case BatchDelete:
+			writes[n] = mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": ids[i]})
+		}
+	}
+
+	_, err := spec.coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case writeConcernOnly(err):
+		h.log().WarnContext(ctx, "batch write concern not satisfied", "entity", spec.entity, "error", err)
+	case errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0:
+		for _, we := range bulkErr.WriteErrors {
+			out.failed[we.Index] = we
+			if ordered && (out.skipFrom < 0 || we.Index < out.skipFrom) {
+				out.skipFrom = we.Index
+			}
+		}
+		if mongo.SessionFromContext(ctx) != nil {
+			return out, errBatchRetry
+		}
+	default:
+		return out, err
+	}
+
+	// Relee las actualizaciones aplicadas para obtener el documento resultante
+	var updatedIds []primitive.ObjectID
+	for n, i := range pending {
+		if ops[i].Op == BatchUpdate && out.failed[n] == nil && (out.skipFrom < 0 || n < out.skipFrom) {
+			updatedIds = append(updatedIds, ids[i])
+		}
+	}
+	after := map[primitive.ObjectID]bson.M{}
+	if len(updatedIds) > 0 {
+		cur, err := spec.coll.Find(ctx, bson.M{"_id": bson.M{"$in": updatedIds}})
+		if err != nil {
+			return out, err
+		}
+		var docs []bson.M
+		if err := cur.All(ctx, &docs); err != nil {
+			return out, err
+		}
+		for _, doc := range docs {
+			id, _ := doc["_id"].(primitive.ObjectID)
+			after[id] = doc
+		}
+	}
+
+	var evts []events.Event
+	var entries []models.AuditEntry
+	for n, i := range pending {
+		if out.failed[n] != nil || (out.skipFrom >= 0 && n > out.skipFrom) {
+			continue
+		}
+		id := ids[i]
+		switch ops[i].Op {
+		case BatchCreate:
+			doc := withId(id, sets[i])
+			evts = append(evts, newEvent(ctx, spec.created, id.Hex(), doc))
+			entries = append(entries, auditEntry(c, AuditCreate, spec.entity, id.Hex(), nil, doc))
+		case BatchUpdate:
+			if after[id] == nil {
+				out.missing[n] = true
+				continue
+			}
+			evts = append(evts, newEvent(ctx, spec.updated, id.Hex(), after[id]))
+			entries = append(entries, auditEntry(c, AuditUpdate, spec.entity, id.Hex(), before[id], after[id]))
+		case BatchDelete:
+			evts = append(evts, newEvent(ctx, spec.deleted, id.Hex(), before[id]))
+			entries = append(entries, auditEntry(c, AuditDelete, spec.entity, id.Hex(), before[id], nil))
+		}
+	}
+
+	if err := h.emitAll(ctx, evts); err != nil {
+		return out, err
+	}
+	return out, h.recordAudits(ctx, c, entries)
+}
+
+// Indica si el error solo reporta que no se cumplio el write concern, sin errores de escritura
+func writeConcernOnly(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		return we.WriteConcernError != nil && len(we.WriteErrors) == 0
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		return bwe.WriteConcernError != nil && len(bwe.WriteErrors) == 0
+	}
+	return false
+}
\ No newline at end of file
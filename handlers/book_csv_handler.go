@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/events"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Columnas del CSV del catalogo
+var bookCSVColumns = []string{"title", "author", "isbn", "availability"}
+
+// Resultado de una fila importada
+const (
+	RowCreated   = "created"
+	RowUpdated   = "updated"
+	RowRejected  = "rejected"
+	RowDuplicate = "duplicate"
+)
+
+// Resultado de la importacion de una fila del CSV o de un registro MARC
+type ImportRow struct {
+	// Numero de linea en el CSV (la cabecera es la linea 1) o numero de registro MARC
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	Id     string `json:"id,omitempty"`
+	Isbn   string `json:"isbn,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Reporte de una importacion
+type ImportReport struct {
+	Created    int `json:"created"`
+	Updated    int `json:"updated"`
+	Rejected   int `json:"rejected"`
+	Duplicates int `json:"duplicates,omitempty"`
+	// En modo de prueba los resultados indican lo que ocurriria, sin escribir nada
+	DryRun bool        `json:"dry_run,omitempty"`
+	Rows   []ImportRow `json:"rows"`
+}
+
+// Agrega el resultado de una fila al reporte
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Status {
+	case RowCreated:
+		r.Created++
+	case RowUpdated:
+		r.Updated++
+	case RowRejected:
+		r.Rejected++
+	case RowDuplicate:
+		r.Duplicates++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// Importa libros desde un CSV con cabecera title,author,isbn,availability en cualquier orden.
+// El archivo se lee fila por fila, cada fila se valida como en CreateBook y se inserta o
+// actualiza por isbn. Acepta el CSV como cuerpo o como archivo "file" de un formulario.
+func (h *Handler) ImportBooksCSV(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Books == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	body := io.Reader(c.Request().Body)
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return errorJSON(c, http.StatusBadRequest, "Falta el archivo file")
+		}
+		src, err := file.Open()
+		if err != nil {
+			return errorJSON(c, http.StatusBadRequest, "Archivo invalido")
+		}
+		defer src.Close()
+		body = src
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	// Ubica las columnas segun la cabecera
+	header, err := reader.Read()
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "El CSV no tiene cabecera")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range bookCSVColumns {
+		if _, ok := columns[name]; !ok {
+			return errorJSON(c, http.StatusBadRequest, "Falta la columna "+name)
+		}
+	}
+
+	report := ImportReport{Rows: []ImportRow{}}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Con un error de lectura FieldPos no tiene campos; la linea viene en el error
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return errorJSON(c, http.StatusBadRequest, "No se pudo leer el CSV")
+			}
+			report.add(ImportRow{Row: parseErr.Line, Status: RowRejected, Reason: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := ImportRow{Row: line}
+		book, reason := bookFromRecord(record, columns)
+		row.Isbn = book.Isbn
+		if reason == "" {
+			_, reason = validateBook(book)
+		}
+		if reason != "" {
+			row.Status, row.Reason = RowRejected, reason
+			report.add(row)
+			continue
+		}
+
+		created, err := h.upsertBookByIsbn(c, &book)
+		if err != nil {
+			if c.Request().Context().Err() != nil {
+				return h.dbError(c, err)
+			}
+			row.Status, row.Reason = RowRejected, "Error al guardar el libro"
+			if mongo.IsDuplicateKeyError(err) {
+				row.Reason = "Ya existe un libro con el mismo isbn"
+			} else {
+				h.log().ErrorContext(c.Request().Context(), "csv row not saved", "row", row.Row, "error", err)
+			}
+			report.add(row)
+			continue
+		}
+
+		row.Id = book.ID.Hex()
+		if created {
+			row.Status = RowCreated
+		} else {
+			row.Status = RowUpdated
+		}
+		report.add(row)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "books imported",
+		"created", report.Created, "updated", report.Updated, "rejected", report.Rejected)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Importacion completada",
+		"data"    : report,
+	})
+}
+
+// Exporta el catalogo como CSV, escribiendo los libros a medida que se leen del cursor
+func (h *Handler) ExportBooksCSV(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Books == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	// La exportacion completa puede tardar mas que una lectura normal
+	ctx, cancel := h.dbContext(c, "books.Find", 0)
+	defer cancel()
+	cur, err := h.Books.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return h.dbError(c, err)
+	}
+	defer cur.Close(ctx)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="books.csv"`)
+	res.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(res)
+	_ = writer.Write(append([]string{"id"}, bookCSVColumns...))
+	for n := 1; cur.Next(ctx); n++ {
+		var book models.Book
+		if err := cur.Decode(&book); err != nil {
+			h.log().ErrorContext(c.Request().Context(), "books export failed", "error", err)
+			break
+		}
+		if err := writer.Write([]string{book.ID.Hex(), book.Title, book.Author, book.Isbn, strconv.Itoa(book.Availability)}); err != nil {
+			return nil
+		}
+		// Envia los datos al cliente por bloques
+		if n%500 == 0 {
+			writer.Flush()
+			res.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		// Los encabezados ya se enviaron, solo queda registrar el error
+		h.log().ErrorContext(c.Request().Context(), "books export failed", "error", err)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Construye un libro a partir de una fila del CSV, retorna la razon si la fila es invalida
+func bookFromRecord(record []string, columns map[string]int) (models.Book, string) {
+	field := func(name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	book := models.Book{
+		Title:  field("title"),
+		Author: field("author"),
+		Isbn:   models.NormalizeIsbn(field("isbn")),
+	}
+	if v := field("availability"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return book, "La disponibilidad debe ser un numero entero"
+		}
+		book.Availability = n
+	}
+	return book, ""
+}
+
+// Inserta el libro o actualiza el existente con el mismo isbn y retorna si fue creado.
+func (h *Handler) upsertBookByIsbn(c echo.Context, book *models.Book) (bool, error) {
+	newId := primitive.NewObjectID()
+	filter := bson.M{"isbn": book.Isbn}
+	update := bson.M{
+		"$set": bson.M{
+			"title":        book.Title,
+			"author":       book.Author,
+			"availability": book.Availability,
+		},
+		"$setOnInsert": bson.M{"_id": newId},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	ctx, cancel := h.dbContext(c, "books.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+
+	created := false
+	err := h.withTransaction(ctx, func(ctx context.Context) error {
+		var existing models.Book
+		err := h.Books.FindOneAndUpdate(ctx, filter, update, opts).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			// Sin documento anterior: el upsert inserto el libro
+			created, book.ID = true, newId
+			if err := h.emit(ctx, events.BookCreated, book.ID.Hex(), book); err != nil {
+				return err
+			}
+			return h.recordAudit(ctx, c, AuditCreate, "book", book.ID.Hex(), nil, book)
+		} else if err != nil {
+			return err
+		}
+
+		book.ID = existing.ID
+		if err := h.emit(ctx, events.BookUpdated, book.ID.Hex(), book); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditUpdate, "book", book.ID.Hex(), existing, book)
+	})
+	return created, err
+}
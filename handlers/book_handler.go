@@ -5,53 +5,41 @@ import (
 	"net/http"
 	"strings"
 
+	"backend/events"
 	"backend/models"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Recupera todos los inventarios junto con sus objetos anidados
 func (h *Handler) GetBooks(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Books == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera todos los inventarios
-	cur, err := h.Books.Find(context.Background(), bson.M{})
+	ctx, cancel := h.dbContext(c, "books.Find", h.Timeouts.Read)
+	defer cancel()
+	cur, err := h.Books.Find(ctx, bson.M{})
 	// Valuda si recupera los inventarios
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil, 
-		})
+		return h.dbError(c, err)
 	}
 
 	// Lista de libros
 	var books []models.Book
 
 	// Almacena en la lista de inventarios todos los inventarios recuperados y valida si la operacion es exitosa
-	if err := cur.All(context.Background(), &books); err != nil{
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil, 
-		})
+	if err := cur.All(ctx, &books); err != nil{
+		return h.dbError(c, err)
 	}
 
 	if len(books) == 0 {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "No se encontraron libros",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "No se encontraron libros")
 	}
 
 	// Retorna estado de respuesta ok y todos los inventarios recuperados
@@ -66,11 +54,7 @@ func (h *Handler) GetBooks(c echo.Context) error {
 func (h *Handler) GetBookById(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Books == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -80,31 +64,21 @@ func (h *Handler) GetBookById(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Instancia de Book
 	var book models.Book
 
 	// Recupera el inventario mediante su id y lo decodifica en el espacio de memoria de la instancia de inventario
-	err = h.Books.FindOne(context.Background(), bson.M{"_id": id}).Decode(&book)
+	ctx, cancel := h.dbContext(c, "books.FindOne", h.Timeouts.Read)
+	defer cancel()
+	err = h.Books.FindOne(ctx, bson.M{"_id": id}).Decode(&book)
 	// Valuda si no existe el documento
 	if err == mongo.ErrNoDocuments {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Libro no encontrado",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "Libro no encontrado")
 	} else if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil, 
-		})
+		return h.dbError(c, err)
 	}
 
 	// Retorna estado de respuesta ok y todos los inventarios recuperados
@@ -121,53 +95,36 @@ func (h *Handler) CreateBook(c echo.Context) error {
 	var book models.Book
 
 	if err := c.Bind(&book); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Input invalido",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
 	}
+	book.Isbn = models.NormalizeIsbn(book.Isbn)
 
-	if strings.TrimSpace(book.Title) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El titulo es obligatorio",
-			"data"	  : nil, 
-		})
-	}
-
-	if strings.TrimSpace(book.Author) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El autor es obligatorio",
-			"data"	  : nil, 
-		})
-	}
-
-	if strings.TrimSpace(book.Isbn) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El isbn es obligatorio",
-			"data"	  : nil, 
-		})
+	if status, message := validateBook(book); status != 0 {
+		return errorJSON(c, status, message)
 	}
 
-	if book.Availability <= 0 {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "La disponibilidad es obligatoria y valida",
-			"data"	  : nil, 
-		})
+	// Inserta el documento y su evento en el outbox dentro de la misma transaccion
+	ctx, cancel := h.dbContext(c, "books.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	err := h.withTransaction(ctx, func(ctx context.Context) error {
+		res, err := h.Books.InsertOne(ctx, book)
+		if err != nil {
+			return err
+		}
+		book.ID, _ = res.InsertedID.(primitive.ObjectID)
+		if err := h.emit(ctx, events.BookCreated, book.ID.Hex(), book); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCreate, "book", book.ID.Hex(), nil, book)
+	})
+	// El indice unico de isbn rechaza los libros repetidos
+	if mongo.IsDuplicateKeyError(err) {
+		return errorJSON(c, http.StatusConflict, "Ya existe un libro con el mismo isbn")
+	} else if err != nil {
+		return h.dbError(c, err)
 	}
 
-	_, err := h.Books.InsertOne(context.Background(), book)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil, 
-		})
-	}
+	h.log().InfoContext(c.Request().Context(), "book created", "book_id", book.ID.Hex(), "isbn", book.Isbn)
 
 	return c.JSON(http.StatusCreated, echo.Map{
 		"status":  http.StatusCreated,
@@ -180,11 +137,7 @@ func (h *Handler) CreateBook(c echo.Context) error {
 func (h *Handler) UpdateBook(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Books == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -194,53 +147,18 @@ func (h *Handler) UpdateBook(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	var book models.Book
 
 	if err := c.Bind(&book); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Input invalido",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
 	}
+	book.Isbn = models.NormalizeIsbn(book.Isbn)
 
-	if strings.TrimSpace(book.Title) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El titulo es obligatorio",
-			"data"	  : nil, 
-		})
-	}
-
-	if strings.TrimSpace(book.Author) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El autor es obligatorio",
-			"data"	  : nil, 
-		})
-	}
-
-	if strings.TrimSpace(book.Isbn) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El isbn es obligatorio",
-			"data"	  : nil, 
-		})
-	}
-
-	if book.Availability <= 0 {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "La disponibilidad es obligatoria y valida",
-			"data"	  : nil, 
-		})
+	if status, message := validateBook(book); status != 0 {
+		return errorJSON(c, status, message)
 	}
 
 	// Prepara filtro y documento de actualización
@@ -254,23 +172,30 @@ func (h *Handler) UpdateBook(c echo.Context) error {
         },
     }
 
-	// Actualiza el documento
-    res, err := h.Books.UpdateOne(context.Background(), filter, update)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status":  http.StatusInternalServerError,
-            "message": err.Error(),
-            "data":    nil,
-        })
-    }
+	// Actualiza el documento y recupera su version anterior para la auditoria
+	ctx, cancel := h.dbContext(c, "books.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	book.ID = id
+	var before models.Book
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		err := h.Books.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+		if err != nil {
+			return err
+		}
+		if err := h.emit(ctx, events.BookUpdated, id.Hex(), book); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditUpdate, "book", id.Hex(), before, book)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Libro no encontrado")
+	} else if mongo.IsDuplicateKeyError(err) {
+		return errorJSON(c, http.StatusConflict, "Ya existe un libro con el mismo isbn")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
 
-    if res.MatchedCount == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "status":  http.StatusNotFound,
-			"message": "Libro no encontrado",
-            "data":    nil,
-        })
-    }
+	h.log().InfoContext(c.Request().Context(), "book updated", "book_id", id.Hex(), "availability", book.Availability)
 
 	return c.JSON(http.StatusCreated, echo.Map{
 		"status":  http.StatusCreated,
@@ -283,11 +208,7 @@ func (h *Handler) UpdateBook(c echo.Context) error {
 func (h *Handler) DeleteBook(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Books == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil, 
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -297,36 +218,56 @@ func (h *Handler) DeleteBook(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Realiza operacion de eliminado mediante el id recuperado del parametro de consulta
-	res, err := h.Books.DeleteOne(context.Background(), bson.M{"_id": id})
-	// Valida si la operacion fue exitosa
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil, 
-		})
-	}
-	
+	ctx, cancel := h.dbContext(c, "books.FindOneAndDelete", h.Timeouts.Write)
+	defer cancel()
+	var before models.Book
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		if err := h.Books.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&before); err != nil {
+			return err
+		}
+		if err := h.emit(ctx, events.BookDeleted, id.Hex(), before); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditDelete, "book", id.Hex(), before, nil)
+	})
 	// Valida si se elimino algun documento
-	if res.DeletedCount == 0 {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Libro no encontrado",
-			"data"	  : nil, 
-		})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Libro no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
 	}
 
+	h.log().InfoContext(c.Request().Context(), "book deleted", "book_id", id.Hex())
+
 	return c.JSON(http.StatusOK, echo.Map{
 		"status"  : http.StatusOK,
         "message" : "Libro eliminado exitosamente",
         "data"    : nil,
     })
+}
+
+// Valida los campos obligatorios de un libro, retorna el estado y mensaje de error o 0 si es valido.
+// Son las mismas reglas para la creacion, la actualizacion y la importacion.
+func validateBook(book models.Book) (int, string) {
+	if strings.TrimSpace(book.Title) == "" {
+		return http.StatusBadRequest, "El titulo es obligatorio"
+	}
+
+	if strings.TrimSpace(book.Author) == "" {
+		return http.StatusBadRequest, "El autor es obligatorio"
+	}
+
+	if strings.TrimSpace(book.Isbn) == "" {
+		return http.StatusBadRequest, "El isbn es obligatorio"
+	}
+
+	if book.Availability <= 0 {
+		return http.StatusBadRequest, "La disponibilidad es obligatoria y valida"
+	}
+
+	return 0, ""
 }
\ No newline at end of file
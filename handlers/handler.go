@@ -1,11 +1,70 @@
 package handlers
 
-import "go.mongodb.org/mongo-driver/mongo"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/apikeys"
+	"backend/auth"
+	"backend/config"
+	"backend/events"
+	"backend/jobs"
+	"backend/logging"
+	"backend/metrics"
+	"backend/webhooks"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Handler struct {
+	// Cliente de MongoDB, usado por los chequeos de salud
+	Client *mongo.Client
+
 	Books *mongo.Collection
 	Users *mongo.Collection
 	Loans *mongo.Collection
+	// Reservas de libros de los usuarios, opcional
+	Holds *mongo.Collection
+	// Coleccion de solo insercion con la auditoria de cambios, opcional
+	Audit *mongo.Collection
+	// Coleccion outbox de eventos de dominio, opcional
+	Outbox *mongo.Collection
+	// Suscripciones y entregas de webhooks salientes, opcional
+	Webhooks *webhooks.Service
+	// API keys de clientes de maquina, opcional
+	APIKeys *apikeys.Service
+	// Firma de los tokens de usuario, opcional
+	Tokens *auth.TokenSigner
+	// Planificador de tareas periodicas, opcional
+	Jobs *jobs.Scheduler
+	// Flujo en vivo de eventos de disponibilidad, opcional
+	Stream *events.Stream
+	// Intervalo de los comentarios de keep-alive del flujo, cero usa 15 segundos
+	StreamHeartbeat time.Duration
+	// Indica si Mongo soporta transacciones (replica set o mongos)
+	Transactions bool
+
+	// Politica de prestamos, si esta vacia se usan los valores por defecto
+	LoanPolicy config.LoanPolicy
+	// Limites de tiempo por operacion de base de datos, cero significa sin limite propio
+	Timeouts config.DBTimeouts
+	// Metricas de dominio, opcional
+	Metrics *metrics.Metrics
+	// Logger estructurado, si es nil se usa slog.Default()
+	Logger *slog.Logger
+
+	// Indica que el servidor se esta apagando
+	shuttingDown atomic.Bool
+	doneOnce     sync.Once
+	doneCh       chan struct{}
 }
 
 func NewHandler(books, users, loans *mongo.Collection) *Handler {
@@ -13,5 +72,62 @@ func NewHandler(books, users, loans *mongo.Collection) *Handler {
 		Books: books, 
 		Users: users,
 		Loans: loans,
+		LoanPolicy: config.Default().Loans,
+		Timeouts: config.Default().Mongo.Timeouts,
+	}
+}
+
+// Tracer de las operaciones de los handlers
+var tracer = otel.Tracer("backend/handlers")
+
+// Crea el contexto de una operacion de base de datos a partir del contexto de la peticion,
+// de modo que la consulta se cancela si el cliente se desconecta o si vence el limite de tiempo.
+// Abre un span con el nombre de la operacion que termina al llamar la funcion retornada.
+func (h *Handler) dbContext(c echo.Context, op string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(c.Request().Context(), op, trace.WithSpanKind(trace.SpanKindClient))
+
+	var cancel context.CancelFunc
+	if timeout <= 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, func() {
+		if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		cancel()
+		span.End()
+	}
+}
+
+// Retorna el logger del handler
+func (h *Handler) log() *slog.Logger {
+	if h.Logger == nil {
+		return slog.Default()
+	}
+	return h.Logger
+}
+
+// Responde el error de una operacion de base de datos, con 504 cuando se agota el tiempo de espera.
+// El detalle del error solo se registra en el log, que incluye el request id que recibe el cliente.
+func (h *Handler) dbError(c echo.Context, err error) error {
+	if mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		h.log().WarnContext(c.Request().Context(), "database timeout", "route", c.Path(), "error", err)
+		return errorJSON(c, http.StatusGatewayTimeout, "Tiempo de espera agotado en la base de datos")
 	}
+
+	h.log().ErrorContext(c.Request().Context(), "database error", "route", c.Path(), "error", err)
+	return errorJSON(c, http.StatusInternalServerError, "Error interno de la base de datos")
+}
+
+// Responde un error con el formato estandar e incluye el request id para rastrear la peticion
+func errorJSON(c echo.Context, status int, message string) error {
+	return c.JSON(status, echo.Map{
+		"status"     : status,
+		"message"    : message,
+		"data"       : nil,
+		"request_id" : logging.RequestID(c),
+	})
 }
\ No newline at end of file
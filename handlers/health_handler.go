@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"backend/logging"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Estado de una dependencia del servicio
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Marca el servicio como en proceso de apagado, a partir de aqui /readyz responde 503
+// y se cierran los flujos de eventos abiertos
+func (h *Handler) SetShuttingDown() {
+	if !h.shuttingDown.Swap(true) {
+		close(h.done())
+	}
+}
+
+// Canal que se cierra cuando el servicio empieza a apagarse
+func (h *Handler) done() chan struct{} {
+	h.doneOnce.Do(func() {
+		h.doneCh = make(chan struct{})
+	})
+	return h.doneCh
+}
+
+// Indica si el servicio esta en proceso de apagado
+func (h *Handler) ShuttingDown() bool {
+	return h.shuttingDown.Load()
+}
+
+// Liveness: el proceso esta vivo y puede responder peticiones
+func (h *Handler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Servicio activo",
+		"data"    : nil,
+	})
+}
+
+// Readiness: el servicio puede atender trafico, valida MongoDB y las colecciones
+func (h *Handler) Readyz(c echo.Context) error {
+	// Falla rapido mientras el servidor se esta apagando
+	if h.ShuttingDown() {
+		return errorJSON(c, http.StatusServiceUnavailable, "Servicio en proceso de apagado")
+	}
+
+	ready := true
+	checks := map[string]dependencyStatus{}
+
+	// Valida la conexion con MongoDB
+	if h.Client == nil {
+		ready = false
+		checks["mongo"] = dependencyStatus{Status: "down", Error: "Sin cliente de mongo"}
+	} else {
+		ctx, cancel := h.dbContext(c, "mongo.Ping", h.Timeouts.Read)
+		defer cancel()
+
+		start := time.Now()
+		if err := h.Client.Ping(ctx, readpref.Primary()); err != nil {
+			// El detalle puede incluir hosts y topologia, solo se registra en el log
+			h.log().ErrorContext(ctx, "mongo ping failed", "error", err)
+			ready = false
+			checks["mongo"] = dependencyStatus{Status: "down"}
+		} else {
+			checks["mongo"] = dependencyStatus{Status: "up", LatencyMs: time.Since(start).Milliseconds()}
+		}
+	}
+
+	// Valida que las colecciones esten configuradas
+	collections := map[string]bool{
+		"books": h.Books != nil,
+		"users": h.Users != nil,
+		"loans": h.Loans != nil,
+	}
+	for name, ok := range collections {
+		if ok {
+			checks[name] = dependencyStatus{Status: "up"}
+		} else {
+			ready = false
+			checks[name] = dependencyStatus{Status: "down", Error: "Sin conexion a la colección"}
+		}
+	}
+
+	if !ready {
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{
+			"status"     : http.StatusServiceUnavailable,
+			"message"    : "Servicio no disponible",
+			"data"       : checks,
+			"request_id" : logging.RequestID(c),
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Servicio listo",
+		"data"    : checks,
+	})
+}
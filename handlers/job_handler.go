@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+// Limite de ejecuciones por consulta del historial
+const (
+	defaultJobRunsLimit = 20
+	maxJobRunsLimit     = 200
+)
+
+// Lista las tareas periodicas con su proxima ejecucion y el resultado de la ultima
+func (h *Handler) GetJobs(c echo.Context) error {
+	// Valida el planificador
+	if h.Jobs == nil {
+		return errorJSON(c, http.StatusNotFound, "Planificador de tareas deshabilitado")
+	}
+
+	list := h.Jobs.Jobs()
+	for i := range list {
+		ctx, cancel := h.dbContext(c, "job_runs.Find", h.Timeouts.Read)
+		last, err := h.Jobs.LastRun(ctx, list[i].Name)
+		cancel()
+		if err != nil {
+			return h.dbError(c, err)
+		}
+		list[i].LastRun = last
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Lista de tareas encontrada",
+		"data"    : echo.Map{"leader": h.Jobs.IsLeader(), "jobs": list},
+	})
+}
+
+// Recupera una tarea periodica con el resultado de su ultima ejecucion
+func (h *Handler) GetJob(c echo.Context) error {
+	// Valida el planificador
+	if h.Jobs == nil {
+		return errorJSON(c, http.StatusNotFound, "Planificador de tareas deshabilitado")
+	}
+
+	info, ok := h.Jobs.Job(c.Param("name"))
+	if !ok {
+		return errorJSON(c, http.StatusNotFound, "Tarea no encontrada")
+	}
+
+	ctx, cancel := h.dbContext(c, "job_runs.Find", h.Timeouts.Read)
+	defer cancel()
+	last, err := h.Jobs.LastRun(ctx, info.Name)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+	info.LastRun = last
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Tarea encontrada",
+		"data"    : info,
+	})
+}
+
+// Recupera el historial de ejecuciones de una tarea, las mas recientes primero
+func (h *Handler) GetJobRuns(c echo.Context) error {
+	// Valida el planificador
+	if h.Jobs == nil {
+		return errorJSON(c, http.StatusNotFound, "Planificador de tareas deshabilitado")
+	}
+
+	name := c.Param("name")
+	if _, ok := h.Jobs.Job(name); !ok {
+		return errorJSON(c, http.StatusNotFound, "Tarea no encontrada")
+	}
+
+	limit := defaultJobRunsLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxJobRunsLimit {
+			return errorJSON(c, http.StatusBadRequest, "Limite invalido")
+		}
+		limit = n
+	}
+
+	ctx, cancel := h.dbContext(c, "job_runs.Find", h.Timeouts.Read)
+	defer cancel()
+	runs, err := h.Jobs.History(ctx, name, limit)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Ejecuciones encontradas",
+		"data"    : runs,
+	})
+}
+
+// Lanza manualmente una tarea en segundo plano. Responde 202 con la ejecucion iniciada,
+// cuyo resultado se consulta en el historial.
+func (h *Handler) RunJob(c echo.Context) error {
+	// Valida el planificador
+	if h.Jobs == nil {
+		return errorJSON(c, http.StatusNotFound, "Planificador de tareas deshabilitado")
+	}
+
+	ctx, cancel := h.dbContext(c, "job_runs.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	run, err := h.Jobs.Trigger(ctx, c.Param("name"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		return errorJSON(c, http.StatusNotFound, "Tarea no encontrada")
+	} else if errors.Is(err, jobs.ErrRunning) {
+		return errorJSON(c, http.StatusConflict, "La tarea ya se esta ejecutando")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "job triggered", "job", run.Job, "run_id", run.ID.Hex())
+	if err := h.recordAudit(c.Request().Context(), c, AuditRun, "job", run.Job, nil, run); err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"status"  : http.StatusAccepted,
+		"message" : "Tarea iniciada",
+		"data"    : run,
+	})
+}
\ No newline at end of file
@@ -2,55 +2,41 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"backend/config"
+	"backend/events"
 	"backend/models"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Todos los lugares de prestamos activos del usuario estan ocupados
+var errSlotsTaken = errors.New("limite de prestamos activos alcanzado")
+
 // Recupera todos los inventarios junto con sus objetos anidados
 func (h *Handler) GetLoans(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Loans == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera todos los inventarios
-	cur, err := h.Loans.Find(context.Background(), bson.M{})
+	loans, err := h.findLoans(c, bson.M{})
 	// Valuda si recupera los inventarios
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status" : http.StatusInternalServerError,
-			"message": err.Error(),
-			"data"   : nil,
-		})
-	}
-
-	// Lista de usuarios
-	var loans []models.Loan
-
-	// Almacena en la lista de inventarios todos los inventarios recuperados y valida si la operacion es exitosa
-	if err := cur.All(context.Background(), &loans); err != nil{
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError,
-			"message" : err.Error(),
-			"data"    : nil,
-		})
+		return h.dbError(c, err)
 	}
 
 	if len(loans) == 0 {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "No se encontraron usuarios",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "No se encontraron usuarios")
 	}
 
 	// Retorna estado de respuesta ok y todos los inventarios recuperados
@@ -65,47 +51,105 @@ func (h *Handler) GetLoans(c echo.Context) error {
 func (h *Handler) CreateLoan(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Loans == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "Sin conexion a la colección",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	var loan models.Loan
 
-	if err := c.Bind(&loan); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Input invalido",
-			"data"    : nil,
-		})
+	// Mide el bind y la validacion por separado de las operaciones de base de datos
+	_, span := tracer.Start(c.Request().Context(), "loans.validate")
+	status, message := bindLoan(c, &loan)
+	span.End()
+	if status != 0 {
+		return errorJSON(c, status, message)
 	}
 
-	if strings.TrimSpace(loan.Name) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El nombre es obligatorio",
-			"data"    : nil,
-		})
+	// Valida que el libro exista
+	if h.Books != nil {
+		bookId, _ := primitive.ObjectIDFromHex(loan.BookId)
+		ctx, cancel := h.dbContext(c, "books.CountDocuments", h.Timeouts.Read)
+		n, err := h.Books.CountDocuments(ctx, bson.M{"_id": bookId})
+		cancel()
+		if err != nil {
+			return h.dbError(c, err)
+		}
+		if n == 0 {
+			return errorJSON(c, http.StatusNotFound, "Libro no encontrado")
+		}
 	}
 
-	if strings.TrimSpace(loan.Description) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "La descripción es obligatoria",
-			"data"    : nil,
-		})
+	// Valida el limite de prestamos activos del usuario
+	limited := h.LoanPolicy.MaxActiveLoans > 0 && strings.TrimSpace(loan.UserId) != ""
+	if limited {
+		ctx, cancel := h.dbContext(c, "loans.CountDocuments", h.Timeouts.Read)
+		active, err := h.Loans.CountDocuments(ctx, bson.M{"user_id": loan.UserId, "is_returned": false})
+		cancel()
+		if err != nil {
+			return h.dbError(c, err)
+		}
+
+		if active >= int64(h.LoanPolicy.MaxActiveLoans) {
+			return errorJSON(c, http.StatusConflict, "El usuario alcanzo el maximo de prestamos activos")
+		}
 	}
 
-	_, err := h.Loans.InsertOne(context.Background(), loan)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil,
-		})
+	// Calcula la fecha de devolucion segun la politica de prestamos
+	loanDays := h.LoanPolicy.LoanDays
+	if loanDays <= 0 {
+		loanDays = config.DefaultLoanDays
+	}
+	loan.IsReturned = false
+	loan.CreatedAt = time.Now().UTC()
+	loan.DueDate = loan.CreatedAt.AddDate(0, 0, loanDays)
+
+	// Inserta el documento y su evento en el outbox dentro de la misma transaccion
+	ctx, cancel := h.dbContext(c, "loans.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	insert := func(ctx context.Context) error {
+		res, err := h.Loans.InsertOne(ctx, loan)
+		if err != nil {
+			return err
+		}
+		loan.ID, _ = res.InsertedID.(primitive.ObjectID)
+		if err := h.emit(ctx, events.LoanCreated, loan.ID.Hex(), loan); err != nil {
+			return err
+		}
+
+		// El prestamo atiende la reserva vigente del usuario sobre el libro
+		if err := h.fulfillHold(ctx, loan.UserId, loan.BookId); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCreate, "loan", loan.ID.Hex(), nil, loan)
+	}
+
+	var err error
+	if !limited {
+		err = h.withTransaction(ctx, insert)
+	} else {
+		// El conteo anterior no impide que dos peticiones simultaneas superen el limite: cada
+		// prestamo activo ocupa uno de los lugares del usuario y el indice unico de active_slot
+		// rechaza el prestamo que intenta ocupar un lugar tomado
+		err = errSlotsTaken
+		for slot := 0; slot < h.LoanPolicy.MaxActiveLoans && err != nil; slot++ {
+			loan.ActiveSlot = fmt.Sprintf("%s:%d", loan.UserId, slot)
+			err = h.withTransaction(ctx, insert)
+			if mongo.IsDuplicateKeyError(err) {
+				err = errSlotsTaken
+			} else if err != nil {
+				break
+			}
+		}
 	}
+	if err == errSlotsTaken {
+		return errorJSON(c, http.StatusConflict, "El usuario alcanzo el maximo de prestamos activos")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "loan created",
+		"loan_id", loan.ID.Hex(), "user_id", loan.UserId, "book_id", loan.BookId, "due_date", loan.DueDate)
+
+	h.Metrics.LoanCreated()
 
 	return c.JSON(http.StatusCreated, echo.Map{
 		"status"  : http.StatusCreated, 
@@ -118,11 +162,7 @@ func (h *Handler) CreateLoan(c echo.Context) error {
 func (h *Handler) ReturnLoan(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Loans == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -132,42 +172,160 @@ func (h *Handler) ReturnLoan(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Prepara filtro y documento de actualización
     filter := bson.M{"_id": id}
+    // $min conserva la fecha de la primera devolucion si el prestamo ya estaba devuelto
+    now := time.Now().UTC()
     update := bson.M{
         "$set": bson.M{
         	"is_returned" : true,
         },
+        "$min": bson.M{
+        	"returned_at" : now,
+        },
+        // Libera el lugar del prestamo dentro del limite del usuario
+        "$unset": bson.M{
+        	"active_slot" : "",
+        },
     }
 
-	// Actualiza el documento
-    res, err := h.Loans.UpdateOne(context.Background(), filter, update)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "status"  : http.StatusInternalServerError,
-            "message" : err.Error(),
-            "data"    : nil,
-        })
-    }
+	// Actualiza el documento y recupera su version anterior para la auditoria
+	ctx, cancel := h.dbContext(c, "loans.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	var before models.Loan
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		err := h.Loans.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+		if err != nil || before.IsReturned {
+			return err
+		}
 
-    if res.MatchedCount == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "status"  : http.StatusNotFound,
-            "message" : "Prestamo no encontrado",
-            "data"    : nil,
-        })
-    }
+		// Solo emite el evento si el prestamo cambio de estado
+		after := before
+		after.IsReturned = true
+		after.ReturnedAt = &now
+		after.ActiveSlot = ""
+		if err := h.emit(ctx, events.LoanReturned, id.Hex(), after); err != nil {
+			return err
+		}
+
+		// El ejemplar devuelto queda para la reserva mas antigua del libro
+		if err := h.promoteHold(ctx, before.BookId, now); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditReturn, "loan", id.Hex(), before, after)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Prestamo no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	// Solo registra el prestamo si cambio de estado
+	if !before.IsReturned {
+		h.Metrics.LoanReturned()
+		h.log().InfoContext(c.Request().Context(), "loan returned", "loan_id", id.Hex())
+	}
 
 	return c.JSON(http.StatusCreated, echo.Map{
         "status"  : http.StatusCreated,
         "message" : "Prestamo devuelto exitosamente!",
         "data"    : nil,
     })
+}
+
+// Marca como lista la reserva en espera mas antigua del libro y emite su evento
+func (h *Handler) promoteHold(ctx context.Context, bookId string, now time.Time) error {
+	if h.Holds == nil || bookId == "" {
+		return nil
+	}
+
+	var hold models.Hold
+	err := h.Holds.FindOneAndUpdate(ctx,
+		bson.M{"book_id": bookId, "status": models.HoldWaiting},
+		bson.M{"$set": bson.M{"status": models.HoldReady, "ready_at": now}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&hold)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	h.log().InfoContext(ctx, "hold ready", "hold_id", hold.ID.Hex(), "user_id", hold.UserId, "book_id", bookId)
+	return h.emit(ctx, events.HoldReady, hold.ID.Hex(), hold)
+}
+
+// Marca como atendida la reserva vigente del usuario sobre el libro
+func (h *Handler) fulfillHold(ctx context.Context, userId, bookId string) error {
+	if h.Holds == nil || userId == "" || bookId == "" {
+		return nil
+	}
+
+	_, err := h.Holds.UpdateOne(ctx,
+		bson.M{"user_id": userId, "book_id": bookId, "status": bson.M{"$in": models.ActiveHoldStatuses}},
+		bson.M{"$set": bson.M{"status": models.HoldFulfilled}, "$unset": bson.M{"active_key": ""}},
+	)
+	return err
+}
+
+// Recupera los prestamos que cumplen el filtro con las opciones indicadas
+func (h *Handler) findLoans(c echo.Context, filter bson.M, opts ...*options.FindOptions) ([]models.Loan, error) {
+	ctx, cancel := h.dbContext(c, "loans.Find", h.Timeouts.Read)
+	defer cancel()
+	cur, err := h.Loans.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lista de prestamos
+	loans := []models.Loan{}
+
+	// Almacena en la lista todos los prestamos recuperados
+	if err := cur.All(ctx, &loans); err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// Cuerpo de la creacion de un prestamo. El estado, las fechas y las renovaciones los
+// asigna el servidor, por eso no se leen del cliente.
+type loanRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	UserId      string `json:"user_id"`
+	BookId      string `json:"book_id"`
+}
+
+// Lee el prestamo del cuerpo de la peticion y valida los campos obligatorios,
+// retorna el estado y mensaje de error o 0 si es valido
+func bindLoan(c echo.Context, loan *models.Loan) (int, string) {
+	var req loanRequest
+	if err := c.Bind(&req); err != nil {
+		return http.StatusBadRequest, "Input invalido"
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		return http.StatusBadRequest, "El nombre es obligatorio"
+	}
+
+	if strings.TrimSpace(req.Description) == "" {
+		return http.StatusBadRequest, "La descripción es obligatoria"
+	}
+
+	req.BookId = strings.TrimSpace(req.BookId)
+	if _, err := primitive.ObjectIDFromHex(req.BookId); err != nil {
+		return http.StatusBadRequest, "Id de libro invalido"
+	}
+
+	// Solo se copian los campos del cliente, el resto del prestamo lo asigna el servidor
+	*loan = models.Loan{
+		Name:        req.Name,
+		Description: req.Description,
+		UserId:      strings.TrimSpace(req.UserId),
+		BookId:      req.BookId,
+	}
+	return 0, ""
 }
\ No newline at end of file
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/events"
+	"backend/marc"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Formatos MARC aceptados por la importacion
+const (
+	FormatMARC21  = "marc21"
+	FormatMARCXML = "marcxml"
+)
+
+// Importa libros desde un archivo MARC21 binario o MARCXML. El formato se indica con
+// ?format o se detecta del contenido. Los libros con un isbn ya existente, en la base
+// o en el mismo archivo, se reportan como duplicados y no se modifican. Con ?dry_run=true
+// solo se reporta lo que ocurriria. ?availability define la disponibilidad inicial, 1 por defecto.
+func (h *Handler) ImportBooksMARC(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Books == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	availability := 1
+	if v := c.QueryParam("availability"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return errorJSON(c, http.StatusBadRequest, "La disponibilidad es obligatoria y valida")
+		}
+		availability = n
+	}
+
+	body := bufio.NewReader(c.Request().Body)
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" {
+		format = detectMARCFormat(c.Request().Header.Get(echo.HeaderContentType), body)
+	}
+
+	var reader marc.Reader
+	switch format {
+	case FormatMARC21:
+		reader = marc.NewReader(body)
+	case FormatMARCXML:
+		reader = marc.NewXMLReader(body)
+	default:
+		return errorJSON(c, http.StatusBadRequest, "Formato invalido, use marc21 o marcxml")
+	}
+
+	report := ImportReport{DryRun: dryRun, Rows: []ImportRow{}}
+	seen := map[string]bool{}
+	for n := 1; ; n++ {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.add(ImportRow{Row: n, Status: RowRejected, Reason: err.Error()})
+			var recErr *marc.RecordError
+			if errors.As(err, &recErr) {
+				continue
+			}
+			// El resto del archivo no se puede leer
+			break
+		}
+
+		book := marc.ToBook(rec, marc.DefaultRules)
+		book.Availability = availability
+		row := ImportRow{Row: n, Isbn: book.Isbn}
+
+		if _, reason := validateBook(book); reason != "" {
+			row.Status, row.Reason = RowRejected, reason
+			report.add(row)
+			continue
+		}
+
+		// Detecta duplicados en el archivo y en el catalogo
+		if seen[book.Isbn] {
+			row.Status, row.Reason = RowDuplicate, "Isbn repetido en el archivo"
+			report.add(row)
+			continue
+		}
+		seen[book.Isbn] = true
+
+		var existing models.Book
+		ctx, cancel := h.dbContext(c, "books.FindOne", h.Timeouts.Read)
+		err = h.Books.FindOne(ctx, bson.M{"isbn": book.Isbn}).Decode(&existing)
+		cancel()
+		if err == nil {
+			row.Status, row.Id, row.Reason = RowDuplicate, existing.ID.Hex(), "Ya existe un libro con el isbn"
+			report.add(row)
+			continue
+		} else if err != mongo.ErrNoDocuments {
+			return h.dbError(c, err)
+		}
+
+		if dryRun {
+			row.Status = RowCreated
+			report.add(row)
+			continue
+		}
+
+		// Inserta el documento y su evento en el outbox dentro de la misma transaccion
+		ctx, cancel = h.dbContext(c, "books.InsertOne", h.Timeouts.Write)
+		err = h.withTransaction(ctx, func(ctx context.Context) error {
+			res, err := h.Books.InsertOne(ctx, book)
+			if err != nil {
+				return err
+			}
+			book.ID, _ = res.InsertedID.(primitive.ObjectID)
+			if err := h.emit(ctx, events.BookCreated, book.ID.Hex(), book); err != nil {
+				return err
+			}
+			return h.recordAudit(ctx, c, AuditCreate, "book", book.ID.Hex(), nil, book)
+		})
+		cancel()
+		// Un libro creado por otra peticion despues de la consulta choca con el indice unico de isbn
+		if mongo.IsDuplicateKeyError(err) {
+			row.Status, row.Reason = RowDuplicate, "Ya existe un libro con el isbn"
+			report.add(row)
+			continue
+		} else if err != nil {
+			return h.dbError(c, err)
+		}
+
+		row.Status, row.Id = RowCreated, book.ID.Hex()
+		report.add(row)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "marc records imported", "format", format, "dry_run", dryRun,
+		"created", report.Created, "duplicates", report.Duplicates, "rejected", report.Rejected)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Importacion completada",
+		"data"    : report,
+	})
+}
+
+// Detecta el formato por el tipo de contenido o, si no es concluyente, por el primer caracter
+func detectMARCFormat(contentType string, body *bufio.Reader) string {
+	if strings.Contains(contentType, "xml") {
+		return FormatMARCXML
+	}
+	if strings.Contains(contentType, "marc") {
+		return FormatMARC21
+	}
+
+	for {
+		b, err := body.Peek(1)
+		if err != nil {
+			return FormatMARC21
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = body.ReadByte()
+		case '<', 0xEF:
+			return FormatMARCXML
+		default:
+			return FormatMARC21
+		}
+	}
+}
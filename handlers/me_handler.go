@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"backend/auth"
+	"backend/config"
+	"backend/events"
+	"backend/models"
+	"backend/notifications"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// El prestamo cambio entre la lectura y la renovacion
+var errLoanChanged = errors.New("el prestamo cambio durante la renovacion")
+
+// Multa de un prestamo atrasado
+type loanFine struct {
+	LoanId      string     `json:"loan_id"`
+	BookId      string     `json:"book_id"`
+	DueDate     time.Time  `json:"due_date"`
+	ReturnedAt  *time.Time `json:"returned_at,omitempty"`
+	DaysOverdue int        `json:"days_overdue"`
+	AmountCents int        `json:"amount_cents"`
+}
+
+// Recupera el perfil del usuario autenticado
+func (h *Handler) GetMe(c echo.Context) error {
+	// Reutiliza la consulta por id con el id de la identidad
+	c.SetParamNames("id")
+	c.SetParamValues(patronId(c))
+	return h.GetUserById(c)
+}
+
+// Recupera los prestamos abiertos del usuario autenticado, del mas proximo a vencer al ultimo
+func (h *Handler) GetMyLoans(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Loans == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}})
+	loans, err := h.findLoans(c, bson.M{"user_id": patronId(c), "is_returned": false}, opts)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Prestamos actuales encontrados",
+		"data"    : loans,
+	})
+}
+
+// Recupera los prestamos devueltos del usuario autenticado, del mas reciente al mas antiguo
+func (h *Handler) GetMyHistory(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Loans == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	loans, err := h.findLoans(c, bson.M{"user_id": patronId(c), "is_returned": true}, opts)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Historial de prestamos encontrado",
+		"data"    : loans,
+	})
+}
+
+// Calcula las multas del usuario autenticado: los prestamos abiertos acumulan multa hasta
+// hoy y los devueltos con atraso hasta la fecha de devolucion
+func (h *Handler) GetMyFines(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Loans == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{"user_id": patronId(c), "due_date": bson.M{"$lt": now}}
+	loans, err := h.findLoans(c, filter, options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}}))
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	fines := []loanFine{}
+	total := 0
+	for _, loan := range loans {
+		days, amount := fineFor(loan, h.LoanPolicy, now)
+		if amount == 0 {
+			continue
+		}
+		fines = append(fines, loanFine{
+			LoanId:      loan.ID.Hex(),
+			BookId:      loan.BookId,
+			DueDate:     loan.DueDate,
+			ReturnedAt:  loan.ReturnedAt,
+			DaysOverdue: days,
+			AmountCents: amount,
+		})
+		total += amount
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Multas calculadas",
+		"data"    : echo.Map{
+			"fine_per_day_cents" : h.LoanPolicy.FinePerDay,
+			"total_cents"        : total,
+			"loans"              : fines,
+		},
+	})
+}
+
+// Renueva un prestamo abierto del usuario autenticado: la fecha de devolucion se extiende
+// por la duracion de un prestamo. No se renuevan prestamos vencidos, los que alcanzaron el
+// maximo de renovaciones ni los libros que otro usuario tiene reservados.
+func (h *Handler) RenewMyLoan(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Loans == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+	userId := patronId(c)
+
+	// Los prestamos de otros usuarios responden igual que los inexistentes
+	ctx, cancel := h.dbContext(c, "loans.FindOne", h.Timeouts.Read)
+	defer cancel()
+	var before models.Loan
+	err = h.Loans.FindOne(ctx, bson.M{"_id": id, "user_id": userId}).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Prestamo no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case before.IsReturned:
+		return errorJSON(c, http.StatusConflict, "El prestamo ya fue devuelto")
+	case before.DueDate.IsZero() || !now.Before(before.DueDate):
+		return errorJSON(c, http.StatusConflict, "El prestamo esta vencido y no se puede renovar")
+	case before.Renewals >= h.LoanPolicy.MaxRenewals:
+		return errorJSON(c, http.StatusConflict, "El prestamo alcanzo el maximo de renovaciones")
+	}
+
+	// Un libro reservado por otro usuario debe volver a la biblioteca
+	if h.Holds != nil {
+		ctx, cancel := h.dbContext(c, "holds.CountDocuments", h.Timeouts.Read)
+		defer cancel()
+		held, err := h.Holds.CountDocuments(ctx, bson.M{
+			"book_id": before.BookId,
+			"user_id": bson.M{"$ne": userId},
+			"status":  bson.M{"$in": models.ActiveHoldStatuses},
+		})
+		if err != nil {
+			return h.dbError(c, err)
+		}
+		if held > 0 {
+			return errorJSON(c, http.StatusConflict, "El libro tiene reservas de otros usuarios")
+		}
+	}
+
+	loanDays := h.LoanPolicy.LoanDays
+	if loanDays <= 0 {
+		loanDays = config.DefaultLoanDays
+	}
+	after := before
+	after.DueDate = before.DueDate.AddDate(0, 0, loanDays)
+	after.Renewals = before.Renewals + 1
+
+	// La fecha de devolucion leida evita renovar dos veces con peticiones concurrentes
+	wctx, wcancel := h.dbContext(c, "loans.UpdateOne", h.Timeouts.Write)
+	defer wcancel()
+	err = h.withTransaction(wctx, func(ctx context.Context) error {
+		res, err := h.Loans.UpdateOne(ctx,
+			bson.M{"_id": id, "is_returned": false, "due_date": before.DueDate},
+			bson.M{"$set": bson.M{"due_date": after.DueDate, "renewals": after.Renewals}},
+		)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errLoanChanged
+		}
+		if err := h.emit(ctx, events.LoanRenewed, id.Hex(), after); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditRenew, "loan", id.Hex(), before, after)
+	})
+	if err == errLoanChanged {
+		return errorJSON(c, http.StatusConflict, "El prestamo cambio, intente de nuevo")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "loan renewed",
+		"loan_id", id.Hex(), "user_id", userId, "due_date", after.DueDate, "renewals", after.Renewals)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "Prestamo renovado exitosamente",
+		"data"    : after,
+	})
+}
+
+// Recupera las reservas vigentes del usuario autenticado en orden de creacion
+func (h *Handler) GetMyHolds(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Holds == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	ctx, cancel := h.dbContext(c, "holds.Find", h.Timeouts.Read)
+	defer cancel()
+	filter := bson.M{"user_id": patronId(c), "status": bson.M{"$in": models.ActiveHoldStatuses}}
+	cur, err := h.Holds.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	holds := []models.Hold{}
+	if err := cur.All(ctx, &holds); err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Reservas encontradas",
+		"data"    : holds,
+	})
+}
+
+// Reserva un libro para el usuario autenticado. Un usuario tiene como mucho una reserva
+// vigente por libro.
+func (h *Handler) PlaceMyHold(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Holds == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var req struct {
+		BookId string `json:"book_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
+	}
+	bookId, err := primitive.ObjectIDFromHex(strings.TrimSpace(req.BookId))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id de libro invalido")
+	}
+	userId := patronId(c)
+
+	ctx, cancel := h.dbContext(c, "holds.CountDocuments", h.Timeouts.Read)
+	defer cancel()
+	if h.Books != nil {
+		var book models.Book
+		err := h.Books.FindOne(ctx, bson.M{"_id": bookId}, options.FindOne().SetProjection(bson.M{"availability": 1})).Decode(&book)
+		if err == mongo.ErrNoDocuments {
+			return errorJSON(c, http.StatusNotFound, "Libro no encontrado")
+		} else if err != nil {
+			return h.dbError(c, err)
+		}
+		// Las reservas solo pasan a listas al devolverse un ejemplar, con ejemplares
+		// disponibles el usuario debe pedir el prestamo
+		if book.Availability > 0 {
+			return errorJSON(c, http.StatusConflict, "Hay ejemplares disponibles del libro")
+		}
+	}
+	active, err := h.Holds.CountDocuments(ctx, bson.M{
+		"user_id": userId,
+		"book_id": bookId.Hex(),
+		"status":  bson.M{"$in": models.ActiveHoldStatuses},
+	})
+	if err != nil {
+		return h.dbError(c, err)
+	}
+	if active > 0 {
+		return errorJSON(c, http.StatusConflict, "Ya tiene una reserva vigente de este libro")
+	}
+
+	hold := models.Hold{
+		UserId:    userId,
+		BookId:    bookId.Hex(),
+		Status:    models.HoldWaiting,
+		CreatedAt: time.Now().UTC(),
+		ActiveKey: models.HoldActiveKey(userId, bookId.Hex()),
+	}
+
+	// Inserta la reserva y su evento en el outbox dentro de la misma transaccion
+	wctx, wcancel := h.dbContext(c, "holds.InsertOne", h.Timeouts.Write)
+	defer wcancel()
+	err = h.withTransaction(wctx, func(ctx context.Context) error {
+		res, err := h.Holds.InsertOne(ctx, hold)
+		if err != nil {
+			return err
+		}
+		hold.ID, _ = res.InsertedID.(primitive.ObjectID)
+		if err := h.emit(ctx, events.HoldPlaced, hold.ID.Hex(), hold); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCreate, "hold", hold.ID.Hex(), nil, hold)
+	})
+	// El indice unico de active_key rechaza la reserva creada por una peticion simultanea
+	if mongo.IsDuplicateKeyError(err) {
+		return errorJSON(c, http.StatusConflict, "Ya tiene una reserva vigente de este libro")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "hold placed", "hold_id", hold.ID.Hex(), "user_id", userId, "book_id", hold.BookId)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "Reserva creada exitosamente",
+		"data"    : hold,
+	})
+}
+
+// Cancela una reserva vigente del usuario autenticado
+func (h *Handler) CancelMyHold(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Holds == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	// Las reservas de otros usuarios responden igual que las inexistentes
+	now := time.Now().UTC()
+	filter := bson.M{"_id": id, "user_id": patronId(c), "status": bson.M{"$in": models.ActiveHoldStatuses}}
+	update := bson.M{"$set": bson.M{"status": models.HoldCanceled, "canceled_at": now}, "$unset": bson.M{"active_key": ""}}
+
+	ctx, cancel := h.dbContext(c, "holds.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	var before models.Hold
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		err := h.Holds.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+		if err != nil {
+			return err
+		}
+		after := before
+		after.Status, after.CanceledAt, after.ActiveKey = models.HoldCanceled, &now, ""
+		if err := h.emit(ctx, events.HoldCanceled, id.Hex(), after); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCancel, "hold", id.Hex(), before, after)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Reserva no encontrada")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	after := before
+	after.Status, after.CanceledAt, after.ActiveKey = models.HoldCanceled, &now, ""
+
+	h.log().InfoContext(c.Request().Context(), "hold canceled", "hold_id", id.Hex(), "user_id", after.UserId)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Reserva cancelada exitosamente",
+		"data"    : after,
+	})
+}
+
+// Actualiza las preferencias de notificacion del usuario autenticado: la baja de los
+// correos y su idioma. Los campos ausentes no cambian.
+func (h *Handler) UpdateMyNotifications(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Users == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var req struct {
+		OptOut   *bool   `json:"opt_out"`
+		Language *string `json:"language"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	if req.OptOut != nil {
+		if *req.OptOut {
+			set["notifications_opt_out"] = true
+		} else {
+			unset["notifications_opt_out"] = ""
+		}
+	}
+	if req.Language != nil {
+		lang := strings.ToLower(strings.TrimSpace(*req.Language))
+		if lang == "" {
+			unset["language"] = ""
+		} else if !slices.Contains(notifications.Languages, lang) {
+			return errorJSON(c, http.StatusBadRequest, "Idioma no soportado: "+lang)
+		} else {
+			set["language"] = lang
+		}
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		return errorJSON(c, http.StatusBadRequest, "Sin cambios")
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	id, err := primitive.ObjectIDFromHex(patronId(c))
+	if err != nil {
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
+	}
+
+	ctx, cancel := h.dbContext(c, "users.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	var before, after models.User
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		if err := h.Users.FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(&before); err != nil {
+			return err
+		}
+		after = before
+		if req.OptOut != nil {
+			after.NotificationsOptOut = *req.OptOut
+		}
+		if lang, ok := set["language"].(string); ok {
+			after.Language = lang
+		} else if _, ok := unset["language"]; ok {
+			after.Language = ""
+		}
+		return h.recordAudit(ctx, c, AuditUpdate, "user", id.Hex(), before, after)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "notification preferences updated",
+		"user_id", id.Hex(), "opt_out", after.NotificationsOptOut, "language", after.Language)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "Preferencias actualizadas exitosamente",
+		"data"    : after,
+	})
+}
+
+// Id del usuario autenticado en las rutas /me, que exigen una identidad de usuario
+func patronId(c echo.Context) string {
+	if p := auth.PrincipalFrom(c); p != nil {
+		return p.ID
+	}
+	return ""
+}
+
+// Dias completos de atraso y multa de un prestamo segun la politica. Los prestamos abiertos
+// cuentan hasta now y los devueltos hasta su devolucion.
+func fineFor(loan models.Loan, policy config.LoanPolicy, now time.Time) (int, int) {
+	if loan.DueDate.IsZero() {
+		return 0, 0
+	}
+	end := now
+	if loan.IsReturned {
+		if loan.ReturnedAt == nil {
+			return 0, 0
+		}
+		end = *loan.ReturnedAt
+	}
+
+	days := int(end.Sub(loan.DueDate) / (24 * time.Hour))
+	if days <= 0 {
+		return 0, 0
+	}
+	amount := days * policy.FinePerDay
+	if policy.MaxFine > 0 && amount > policy.MaxFine {
+		amount = policy.MaxFine
+	}
+	return days, amount
+}
\ No newline at end of file
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+
+	"backend/events"
+	"backend/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Ejecuta fn dentro de una transaccion cuando el despliegue de Mongo la soporta, de modo
+// que la escritura y el evento del outbox se confirman juntos. Sin soporte ejecuta fn directamente.
+func (h *Handler) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if h.Client == nil || !h.Transactions {
+		return fn(ctx)
+	}
+
+	session, err := h.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// Guarda un evento de dominio en el outbox usando el contexto de la transaccion en curso
+func (h *Handler) emit(ctx context.Context, eventType, aggregateId string, payload interface{}) error {
+	if h.Outbox == nil {
+		return nil
+	}
+
+	evt := newEvent(ctx, eventType, aggregateId, payload)
+	return events.Append(ctx, h.Outbox, &evt)
+}
+
+// Crea un evento de dominio con el request id del contexto, para guardarlo con emitAll
+func newEvent(ctx context.Context, eventType, aggregateId string, payload interface{}) events.Event {
+	return events.New(eventType, aggregateId, snapshot(payload), logging.RequestIDFromContext(ctx))
+}
+
+// Guarda varios eventos de dominio en el outbox con una sola escritura, usando el contexto
+// de la transaccion en curso
+func (h *Handler) emitAll(ctx context.Context, evts []events.Event) error {
+	if h.Outbox == nil {
+		return nil
+	}
+	return events.AppendAll(ctx, h.Outbox, evts)
+}
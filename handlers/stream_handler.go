@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/events"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Intervalo por defecto de los comentarios de keep-alive
+const defaultStreamHeartbeat = 15 * time.Second
+
+// Transmite con Server-Sent Events los cambios de disponibilidad de libros y de estado de prestamos,
+// opcionalmente de un solo libro con ?book_id. El id de cada evento es su numero de secuencia:
+// el cliente reanuda el flujo con el encabezado Last-Event-ID (o el parametro last_event_id)
+// y recibe los eventos posteriores. Se acepta tambien el id de un evento del outbox.
+func (h *Handler) StreamEvents(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Stream == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var after int64
+	lastId := c.Request().Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = c.QueryParam("last_event_id")
+	}
+	if seq, err := strconv.ParseInt(lastId, 10, 64); err == nil && seq > 0 {
+		after = seq
+	} else if id, err := primitive.ObjectIDFromHex(lastId); err == nil {
+		// Clientes conectados antes de que el flujo usara la secuencia
+		ctx, cancel := h.dbContext(c, "outbox.FindOne", h.Timeouts.Read)
+		after, err = h.Stream.SeqOf(ctx, id)
+		cancel()
+		if err != nil {
+			return h.dbError(c, err)
+		}
+	} else if lastId != "" {
+		return errorJSON(c, http.StatusBadRequest, "Last-Event-ID invalido")
+	}
+
+	bookId := c.QueryParam("book_id")
+	if bookId != "" {
+		if _, err := primitive.ObjectIDFromHex(bookId); err != nil {
+			return errorJSON(c, http.StatusBadRequest, "Id invalido")
+		}
+	}
+
+	// El flujo no tiene limite de escritura del servidor, termina con el cliente o al apagar
+	res := c.Response()
+	_ = http.NewResponseController(res).SetWriteDeadline(time.Time{})
+
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(res, "retry: 3000\n\n"); err != nil {
+		return nil
+	}
+	res.Flush()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	// Los eventos y los keep-alive se escriben desde esta goroutine
+	out := make(chan events.Event)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.Stream.Tail(ctx, after, events.AvailabilityFilter(bookId), func(evt events.Event) error {
+			select {
+			case out <- evt:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	heartbeat := h.StreamHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultStreamHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt := <-out:
+			if err := writeEvent(res, evt); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case err := <-errCh:
+			if err != nil && !errors.Is(err, context.Canceled) {
+				h.log().ErrorContext(c.Request().Context(), "event stream failed", "error", err)
+			}
+			return nil
+		case <-h.done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Escribe un evento con el formato de Server-Sent Events
+func writeEvent(res *echo.Response, evt events.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", evt.Seq, evt.Type, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/events"
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Tareas periodicas de prestamos y reservas, ejecutadas por el planificador de tareas
+
+// Marca con overdue_at los prestamos vencidos que aun no se marcaron y emite LoanOverdue
+// por cada uno. Retorna la cantidad de prestamos marcados.
+func (h *Handler) MarkOverdueLoans(ctx context.Context) (any, error) {
+	if h.Loans == nil {
+		return nil, errors.New("sin conexion a la colección de prestamos")
+	}
+
+	now := time.Now().UTC()
+	filter := database.OverdueLoansFilter(now)
+	filter["overdue_at"] = bson.M{"$exists": false}
+	cur, err := h.Loans.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var loans []models.Loan
+	if err := cur.All(ctx, &loans); err != nil {
+		return nil, err
+	}
+
+	marked := 0
+	for _, loan := range loans {
+		updated := false
+		err := h.withTransaction(ctx, func(ctx context.Context) error {
+			// Otra ejecucion pudo marcarlo o el usuario devolverlo mientras tanto
+			res, err := h.Loans.UpdateOne(ctx,
+				bson.M{"_id": loan.ID, "is_returned": false, "overdue_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"overdue_at": now}},
+			)
+			if err != nil || res.ModifiedCount == 0 {
+				updated = false
+				return err
+			}
+			updated = true
+			loan.OverdueAt = &now
+			return h.emit(ctx, events.LoanOverdue, loan.ID.Hex(), loan)
+		})
+		if err != nil {
+			return map[string]int{"marked": marked}, err
+		}
+		if updated {
+			marked++
+			h.log().InfoContext(ctx, "loan overdue", "loan_id", loan.ID.Hex(), "user_id", loan.UserId, "due_date", loan.DueDate)
+		}
+	}
+	return map[string]int{"marked": marked}, nil
+}
+
+// Vence las reservas listas que no se retiraron dentro de los dias de la politica, emite
+// HoldExpired y pasa el libro a la siguiente reserva en espera. Retorna la cantidad de
+// reservas vencidas.
+func (h *Handler) ExpireHolds(ctx context.Context) (any, error) {
+	if h.Holds == nil {
+		return nil, errors.New("sin conexion a la colección de reservas")
+	}
+
+	pickupDays := h.LoanPolicy.HoldPickupDays
+	if pickupDays <= 0 {
+		pickupDays = config.DefaultHoldPickupDays
+	}
+	now := time.Now().UTC()
+	cur, err := h.Holds.Find(ctx, bson.M{"status": models.HoldReady, "ready_at": bson.M{"$lt": now.AddDate(0, 0, -pickupDays)}})
+	if err != nil {
+		return nil, err
+	}
+	var holds []models.Hold
+	if err := cur.All(ctx, &holds); err != nil {
+		return nil, err
+	}
+
+	expired := 0
+	for _, hold := range holds {
+		updated := false
+		err := h.withTransaction(ctx, func(ctx context.Context) error {
+			res, err := h.Holds.UpdateOne(ctx,
+				bson.M{"_id": hold.ID, "status": models.HoldReady},
+				bson.M{"$set": bson.M{"status": models.HoldExpired, "expired_at": now}, "$unset": bson.M{"active_key": ""}},
+			)
+			if err != nil || res.ModifiedCount == 0 {
+				updated = false
+				return err
+			}
+			updated = true
+			hold.Status = models.HoldExpired
+			hold.ExpiredAt = &now
+			hold.ActiveKey = ""
+			if err := h.emit(ctx, events.HoldExpired, hold.ID.Hex(), hold); err != nil {
+				return err
+			}
+			return h.promoteHold(ctx, hold.BookId, now)
+		})
+		if err != nil {
+			return map[string]int{"expired": expired}, err
+		}
+		if updated {
+			expired++
+			h.log().InfoContext(ctx, "hold expired", "hold_id", hold.ID.Hex(), "user_id", hold.UserId, "book_id", hold.BookId)
+		}
+	}
+	return map[string]int{"expired": expired}, nil
+}
\ No newline at end of file
@@ -5,53 +5,41 @@ import (
 	"net/http"
 	"strings"
 
+	"backend/events"
 	"backend/models"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Recupera todos los inventarios junto con sus objetos anidados
 func (h *Handler) GetUsers(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera todos los inventarios
-	cur, err := h.Users.Find(context.Background(), bson.M{})
+	ctx, cancel := h.dbContext(c, "users.Find", h.Timeouts.Read)
+	defer cancel()
+	cur, err := h.Users.Find(ctx, bson.M{})
 	// Valuda si recupera los inventarios
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status" : http.StatusInternalServerError,
-			"message": err.Error(),
-			"data"   : nil,
-		})
+		return h.dbError(c, err)
 	}
 
 	// Lista de usuarios
 	var users []models.User
 
 	// Almacena en la lista de inventarios todos los inventarios recuperados y valida si la operacion es exitosa
-	if err := cur.All(context.Background(), &users); err != nil{
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError,
-			"message" : err.Error(),
-			"data"    : nil,
-		})
+	if err := cur.All(ctx, &users); err != nil{
+		return h.dbError(c, err)
 	}
 
 	if len(users) == 0 {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "No se encontraron usuarios",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "No se encontraron usuarios")
 	}
 
 	// Retorna estado de respuesta ok y todos los inventarios recuperados
@@ -66,11 +54,7 @@ func (h *Handler) GetUsers(c echo.Context) error {
 func (h *Handler) GetUserById(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -80,31 +64,21 @@ func (h *Handler) GetUserById(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest,
-			"message" : "Id invalido",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Instancia de User
 	var user models.User
 
 	// Recupera el inventario mediante su id y lo decodifica en el espacio de memoria de la instancia de inventario
-	err = h.Users.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
+	ctx, cancel := h.dbContext(c, "users.FindOne", h.Timeouts.Read)
+	defer cancel()
+	err = h.Users.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	// Valuda si no existe el documento
 	if err == mongo.ErrNoDocuments {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "Usuario no encontrado",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
 	} else if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError,
-			"message" : err.Error(),
-			"data"    : nil,
-		})
+		return h.dbError(c, err)
 	}
 
 	// Retorna estado de respuesta ok y todos los inventarios recuperados
@@ -119,48 +93,43 @@ func (h *Handler) GetUserById(c echo.Context) error {
 func (h *Handler) CreateUser(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound,
-			"message" : "Sin conexion a la colección",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	var user models.User
 
 	if err := c.Bind(&user); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Input invalido",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
 	}
 
 	if strings.TrimSpace(user.Name) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El nombre es obligatorio",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "El nombre es obligatorio")
 	}
 
 	if strings.TrimSpace(user.Email) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El correo electronico es obligatorio",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "El correo electronico es obligatorio")
 	}
 
-	_, err := h.Users.InsertOne(context.Background(), user)
+	// Inserta el documento y su evento en el outbox dentro de la misma transaccion
+	ctx, cancel := h.dbContext(c, "users.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	err := h.withTransaction(ctx, func(ctx context.Context) error {
+		res, err := h.Users.InsertOne(ctx, user)
+		if err != nil {
+			return err
+		}
+		user.ID, _ = res.InsertedID.(primitive.ObjectID)
+		if err := h.emit(ctx, events.UserCreated, user.ID.Hex(), user); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditCreate, "user", user.ID.Hex(), nil, user)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data"	  : nil,
-		})
+		return h.dbError(c, err)
 	}
 
+	h.log().InfoContext(c.Request().Context(), "user created", "user_id", user.ID.Hex())
+
 	return c.JSON(http.StatusCreated, echo.Map{
 		"status"  : http.StatusCreated, 
 		"message" : "Usuario creado exitosamente",
@@ -172,11 +141,7 @@ func (h *Handler) CreateUser(c echo.Context) error {
 func (h *Handler) UpdateUser(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -186,46 +151,26 @@ func (h *Handler) UpdateUser(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Sin conexion a la colección",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	var user models.User
 
 	if err := c.Bind(&user); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Input invalido",
-			"data"	  : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
 	}
 
 	if strings.TrimSpace(user.Name) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El nombre es obligatorio",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "El nombre es obligatorio")
 	}
 
 	if strings.TrimSpace(user.Email) == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "El correo electronico es obligatorio",
-			"data"    : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "El correo electronico es obligatorio")
 	}
 
 	// Prepara filtro y documento de actualización
@@ -237,23 +182,28 @@ func (h *Handler) UpdateUser(c echo.Context) error {
         },
     }
 
-	// Actualiza el documento
-    res, err := h.Users.UpdateOne(context.Background(), filter, update)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, echo.Map{
-            "status"  : http.StatusInternalServerError,
-            "message" : err.Error(),
-            "data"    : nil,
-        })
-    }
+	// Actualiza el documento y recupera su version anterior para la auditoria
+	ctx, cancel := h.dbContext(c, "users.FindOneAndUpdate", h.Timeouts.Write)
+	defer cancel()
+	user.ID = id
+	var before models.User
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		err := h.Users.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+		if err != nil {
+			return err
+		}
+		if err := h.emit(ctx, events.UserUpdated, id.Hex(), user); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditUpdate, "user", id.Hex(), before, user)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
 
-    if res.MatchedCount == 0 {
-        return c.JSON(http.StatusNotFound, echo.Map{
-            "status"  : http.StatusNotFound,
-            "message" : "Usuario no encontrado",
-            "data"    : nil,
-        })
-    }
+	h.log().InfoContext(c.Request().Context(), "user updated", "user_id", id.Hex())
 
 	return c.JSON(http.StatusCreated, echo.Map{
         "status"  : http.StatusCreated,
@@ -266,11 +216,7 @@ func (h *Handler) UpdateUser(c echo.Context) error {
 func (h *Handler) DeleteUser(c echo.Context) error {
 	// Valida la conexion a la coleccion
 	if h.Users == nil {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status": http.StatusNotFound, 
-			"message": "Sin conexion a la colección",
-			"data": nil,
-		})
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
 	}
 
 	// Recupera el parametro de consulta el id
@@ -280,33 +226,31 @@ func (h *Handler) DeleteUser(c echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(idParam)
 	// Valida si la operacion fue exitosa
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"status"  : http.StatusBadRequest, 
-			"message" : "Id invalido",
-			"data" 	  : nil,
-		})
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
 	}
 
 	// Realiza operacion de eliminado mediante el id recuperado del parametro de consulta
-	res, err := h.Users.DeleteOne(context.Background(), bson.M{"_id": id})
-	// Valida si la operacion fue exitosa
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"status"  : http.StatusInternalServerError, 
-			"message" : err.Error(),
-			"data" 	  : nil,
-		})
-	}
-	
+	ctx, cancel := h.dbContext(c, "users.FindOneAndDelete", h.Timeouts.Write)
+	defer cancel()
+	var before models.User
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		if err := h.Users.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&before); err != nil {
+			return err
+		}
+		if err := h.emit(ctx, events.UserDeleted, id.Hex(), before); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditDelete, "user", id.Hex(), before, nil)
+	})
 	// Valida si se elimino algun documento
-	if res.DeletedCount == 0 {
-		return c.JSON(http.StatusNotFound, echo.Map{
-			"status"  : http.StatusNotFound, 
-			"message" : "Usuario no encontrado",
-			"data" 	  : nil,
-		})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Usuario no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
 	}
 
+	h.log().InfoContext(c.Request().Context(), "user deleted", "user_id", id.Hex())
+
 	return c.JSON(http.StatusOK, echo.Map{
         "status"  : http.StatusOK,
         "message" : "Usuario eliminado exitosamente",
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/events"
+	"backend/webhooks"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limite de entregas por consulta del registro
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// Crea una suscripcion de webhook. Si no se envia el secreto se genera uno,
+// que solo se retorna en esta respuesta.
+func (h *Handler) CreateWebhook(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Webhooks == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	var sub webhooks.Subscription
+
+	if err := c.Bind(&sub); err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Input invalido")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(sub.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errorJSON(c, http.StatusBadRequest, "La url debe ser http o https absoluta")
+	}
+
+	for _, e := range sub.Events {
+		if e != "*" && !slices.Contains(events.Types, e) {
+			return errorJSON(c, http.StatusBadRequest, "Tipo de evento desconocido: "+e)
+		}
+	}
+
+	if strings.TrimSpace(sub.Secret) == "" {
+		if sub.Secret, err = webhooks.NewSecret(); err != nil {
+			h.log().ErrorContext(c.Request().Context(), "webhook secret not generated", "error", err)
+			return errorJSON(c, http.StatusInternalServerError, "No se pudo generar el secreto")
+		}
+	}
+
+	sub.ID = primitive.NilObjectID
+	sub.URL = u.String()
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	sub.Active = true
+	sub.CreatedAt = time.Now().UTC()
+
+	ctx, cancel := h.dbContext(c, "webhooks.InsertOne", h.Timeouts.Write)
+	defer cancel()
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		res, err := h.Webhooks.Subscriptions.InsertOne(ctx, sub)
+		if err != nil {
+			return err
+		}
+		sub.ID, _ = res.InsertedID.(primitive.ObjectID)
+		return h.recordAudit(ctx, c, AuditCreate, "webhook", sub.ID.Hex(), nil, withoutSecret(sub))
+	})
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "webhook created", "webhook_id", sub.ID.Hex(), "url", sub.URL)
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status"  : http.StatusCreated,
+		"message" : "Webhook creado exitosamente",
+		"data"    : sub,
+	})
+}
+
+// Recupera las suscripciones de webhooks, sin sus secretos
+func (h *Handler) GetWebhooks(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Webhooks == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	ctx, cancel := h.dbContext(c, "webhooks.Find", h.Timeouts.Read)
+	defer cancel()
+	cur, err := h.Webhooks.Subscriptions.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	subs := []webhooks.Subscription{}
+	if err := cur.All(ctx, &subs); err != nil {
+		return h.dbError(c, err)
+	}
+	for i := range subs {
+		subs[i] = withoutSecret(subs[i])
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Lista de webhooks encontrada",
+		"data"    : subs,
+	})
+}
+
+// Elimina una suscripcion de webhook. Su registro de entregas se conserva.
+func (h *Handler) DeleteWebhook(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Webhooks == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	ctx, cancel := h.dbContext(c, "webhooks.FindOneAndDelete", h.Timeouts.Write)
+	defer cancel()
+	var before webhooks.Subscription
+	err = h.withTransaction(ctx, func(ctx context.Context) error {
+		if err := h.Webhooks.Subscriptions.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&before); err != nil {
+			return err
+		}
+		return h.recordAudit(ctx, c, AuditDelete, "webhook", id.Hex(), withoutSecret(before), nil)
+	})
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Webhook no encontrado")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "webhook deleted", "webhook_id", id.Hex())
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Webhook eliminado exitosamente",
+		"data"    : nil,
+	})
+}
+
+// Recupera el registro de entregas de una suscripcion, opcionalmente filtrado por estado
+func (h *Handler) GetWebhookDeliveries(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Webhooks == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+
+	filter := bson.M{"subscription_id": id}
+	if v := c.QueryParam("status"); v != "" {
+		filter["status"] = v
+	}
+
+	limit := defaultDeliveriesLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeliveriesLimit {
+			return errorJSON(c, http.StatusBadRequest, "Limite invalido")
+		}
+		limit = n
+	}
+
+	// Recupera las entregas mas recientes primero
+	ctx, cancel := h.dbContext(c, "webhook_deliveries.Find", h.Timeouts.Read)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cur, err := h.Webhooks.Deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return h.dbError(c, err)
+	}
+
+	deliveries := []webhooks.Delivery{}
+	if err := cur.All(ctx, &deliveries); err != nil {
+		return h.dbError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Entregas encontradas",
+		"data"    : deliveries,
+	})
+}
+
+// Reenvia manualmente una entrega de la suscripcion y retorna su resultado
+func (h *Handler) RedeliverWebhook(c echo.Context) error {
+	// Valida la conexion a la coleccion
+	if h.Webhooks == nil {
+		return errorJSON(c, http.StatusNotFound, "Sin conexion a la colección")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id invalido")
+	}
+	deliveryId, err := primitive.ObjectIDFromHex(c.Param("delivery_id"))
+	if err != nil {
+		return errorJSON(c, http.StatusBadRequest, "Id de entrega invalido")
+	}
+
+	// El envio tiene su propio limite de tiempo en el cliente HTTP del servicio
+	d, err := h.Webhooks.Redeliver(c.Request().Context(), id, deliveryId)
+	if err == mongo.ErrNoDocuments {
+		return errorJSON(c, http.StatusNotFound, "Entrega no encontrada")
+	} else if errors.Is(err, webhooks.ErrBusy) {
+		return errorJSON(c, http.StatusConflict, "La entrega se esta enviando, intente de nuevo")
+	} else if err != nil {
+		return h.dbError(c, err)
+	}
+
+	h.log().InfoContext(c.Request().Context(), "webhook redelivered",
+		"webhook_id", id.Hex(), "delivery_id", d.ID.Hex(), "delivery_status", d.Status)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status"  : http.StatusOK,
+		"message" : "Entrega reenviada",
+		"data"    : d,
+	})
+}
+
+// Oculta el secreto de una suscripcion en respuestas y auditoria
+func withoutSecret(sub webhooks.Subscription) webhooks.Subscription {
+	sub.Secret = ""
+	return sub
+}
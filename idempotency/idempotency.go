@@ -0,0 +1,322 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/auth"
+	"backend/logging"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Encabezados de la peticion y de la respuesta repetida
+const (
+	Header         = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+// Estados de una clave
+const (
+	StateProcessing = "processing"
+	StateCompleted  = "completed"
+)
+
+// Limites de las claves y de las respuestas guardadas
+const (
+	MaxKeyLength = 255
+	// Respuestas mas grandes no se guardan y la clave se libera
+	MaxBodySize = 1 << 20
+	// Tiempo que una clave queda reservada mientras se procesa la primera peticion. La reserva
+	// se renueva mientras la peticion sigue en curso; si el proceso muere la clave se libera al vencer
+	DefaultLockTTL = time.Minute
+	DefaultTTL     = 24 * time.Hour
+	// Cuerpos de peticion mas grandes se rechazan con 413
+	DefaultMaxRequestBody = 1 << 20
+)
+
+// Clave guardada con la primera respuesta. El indice TTL sobre expires_at la elimina al vencer.
+type Record struct {
+	ID          string    `bson:"_id"`
+	RequestHash string    `bson:"request_hash"`
+	State       string    `bson:"state"`
+	Status      int       `bson:"status,omitempty"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// Almacen de claves de idempotencia en MongoDB
+type Store struct {
+	Coll *mongo.Collection
+	// Vigencia de las claves completadas, DefaultTTL si es cero
+	TTL time.Duration
+	// Vigencia de la reserva mientras se procesa, DefaultLockTTL si es cero
+	LockTTL time.Duration
+	// Tamaño maximo del cuerpo de la peticion, DefaultMaxRequestBody si es cero
+	MaxRequestBody int64
+	Logger         *slog.Logger
+	// Reloj, time.Now si es nil
+	Now func() time.Time
+}
+
+// Middleware que guarda la primera respuesta de cada Idempotency-Key y la repite para las
+// peticiones con la misma clave y el mismo cuerpo. Si la clave se reutiliza con otro cuerpo
+// responde 422 y mientras la primera peticion esta en curso responde 409. Las respuestas
+// 5xx no se guardan para que el cliente pueda reintentar. scope separa las claves por recurso.
+func (s *Store) Middleware(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if s == nil || s.Coll == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			key := strings.TrimSpace(c.Request().Header.Get(Header))
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > MaxKeyLength {
+				return errorJSON(c, http.StatusBadRequest, "Idempotency-Key invalida")
+			}
+
+			// El cuerpo se lee completo para calcular su huella, por eso se limita su tamaño
+			body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, s.maxRequestBody()))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return errorJSON(c, http.StatusRequestEntityTooLarge, "El cuerpo de la peticion es demasiado grande")
+			}
+			if err != nil {
+				return errorJSON(c, http.StatusBadRequest, "Input invalido")
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			id := recordID(c, scope, key)
+			hash := requestHash(body)
+			ctx := c.Request().Context()
+
+			existing, err := s.reserve(ctx, id, hash)
+			if err != nil {
+				s.log().ErrorContext(ctx, "idempotency key not reserved", "scope", scope, "error", err)
+				return errorJSON(c, http.StatusInternalServerError, "No se pudo reservar la Idempotency-Key")
+			}
+			if existing != nil {
+				return s.replay(c, existing, hash)
+			}
+
+			// Captura la respuesta para guardarla
+			res := c.Response()
+			rec := &recorder{ResponseWriter: res.Writer}
+			res.Writer = rec
+			stop := s.keepReserved(ctx, id)
+			err = next(c)
+			stop()
+			res.Writer = rec.ResponseWriter
+
+			// Se guarda aunque el cliente se haya desconectado, para que su reintento la encuentre
+			ctx = context.WithoutCancel(ctx)
+			if err != nil || res.Status >= http.StatusInternalServerError || rec.overflow {
+				s.release(ctx, id)
+				return err
+			}
+			s.complete(ctx, id, res.Status, res.Header().Get(echo.HeaderContentType), rec.body.Bytes())
+			return nil
+		}
+	}
+}
+
+// Reserva la clave. Retorna nil si la reserva es nueva o el registro existente si ya se uso.
+func (s *Store) reserve(ctx context.Context, id, hash string) (*Record, error) {
+	// Un registro vencido que el indice TTL aun no elimino se descarta y se reintenta
+	for attempt := 0; attempt < 3; attempt++ {
+		now := s.now()
+		_, err := s.Coll.InsertOne(ctx, Record{
+			ID:          id,
+			RequestHash: hash,
+			State:       StateProcessing,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.lockTTL()),
+		})
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var existing Record
+		err = s.Coll.FindOne(ctx, bson.M{"_id": id}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !existing.ExpiresAt.After(now) {
+			if _, err := s.Coll.DeleteOne(ctx, bson.M{"_id": id, "expires_at": existing.ExpiresAt}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return &existing, nil
+	}
+	return nil, errors.New("no se pudo reservar la clave de idempotencia")
+}
+
+// Renueva la reserva mientras se procesa la primera peticion, para que un reintento no la
+// tome al vencer LockTTL y ejecute el cambio dos veces. Retorna la funcion que la detiene.
+func (s *Store) keepReserved(ctx context.Context, id string) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.lockTTL() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			_, err := s.Coll.UpdateOne(ctx, bson.M{"_id": id, "state": StateProcessing}, bson.M{"$set": bson.M{
+				"expires_at": s.now().Add(s.lockTTL()),
+			}})
+			if err != nil && ctx.Err() == nil {
+				s.log().WarnContext(ctx, "idempotency key not renewed", "error", err)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Responde a una peticion repetida segun el registro existente
+func (s *Store) replay(c echo.Context, rec *Record, hash string) error {
+	if rec.RequestHash != hash {
+		return errorJSON(c, http.StatusUnprocessableEntity, "La Idempotency-Key ya se uso con otro cuerpo")
+	}
+	if rec.State != StateCompleted {
+		c.Response().Header().Set("Retry-After", "1")
+		return errorJSON(c, http.StatusConflict, "Hay una peticion en curso con la misma Idempotency-Key")
+	}
+
+	c.Response().Header().Set(HeaderReplayed, "true")
+	return c.Blob(rec.Status, rec.ContentType, rec.Body)
+}
+
+// Guarda la respuesta de la primera peticion
+func (s *Store) complete(ctx context.Context, id string, status int, contentType string, body []byte) {
+	_, err := s.Coll.UpdateOne(ctx, bson.M{"_id": id, "state": StateProcessing}, bson.M{"$set": bson.M{
+		"state":        StateCompleted,
+		"status":       status,
+		"content_type": contentType,
+		"body":         body,
+		"expires_at":   s.now().Add(s.ttl()),
+	}})
+	if err != nil {
+		s.log().ErrorContext(ctx, "idempotent response not stored", "error", err)
+	}
+}
+
+// Libera la clave para que el cliente pueda reintentar
+func (s *Store) release(ctx context.Context, id string) {
+	if _, err := s.Coll.DeleteOne(ctx, bson.M{"_id": id, "state": StateProcessing}); err != nil {
+		s.log().ErrorContext(ctx, "idempotency key not released", "error", err)
+	}
+}
+
+func (s *Store) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
+
+func (s *Store) lockTTL() time.Duration {
+	if s.LockTTL > 0 {
+		return s.LockTTL
+	}
+	return DefaultLockTTL
+}
+
+func (s *Store) maxRequestBody() int64 {
+	if s.MaxRequestBody > 0 {
+		return s.MaxRequestBody
+	}
+	return DefaultMaxRequestBody
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Store) log() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// Las claves son por recurso y por identidad autenticada, dos clientes pueden usar la misma clave
+func recordID(c echo.Context, scope, key string) string {
+	owner := ""
+	if p := auth.PrincipalFrom(c); p != nil {
+		owner = p.Kind + ":" + p.ID
+	}
+	return scope + "|" + owner + "|" + key
+}
+
+// Huella del cuerpo. Los cuerpos JSON se normalizan para que el orden de los campos
+// y los espacios no cambien la huella.
+func requestHash(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Copia lo que se escribe en la respuesta hasta MaxBodySize
+type recorder struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > MaxBodySize {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func errorJSON(c echo.Context, status int, message string) error {
+	return c.JSON(status, echo.Map{
+		"status":     status,
+		"message":    message,
+		"data":       nil,
+		"request_id": logging.RequestID(c),
+	})
+}
@@ -0,0 +1,459 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/database"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Nombre del bloqueo del lider, la instancia que ejecuta las tareas programadas
+const LeaderLock = "jobs:leader"
+
+// Origen de una ejecucion
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Estados de una ejecucion
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+var (
+	ErrNotFound = errors.New("jobs: tarea no encontrada")
+	ErrRunning  = errors.New("jobs: la tarea ya se esta ejecutando")
+)
+
+// Tarea periodica. Run retorna un resumen que se guarda en el historial.
+type Job struct {
+	Name        string
+	Description string
+	// Expresion cron de cinco campos o descriptor como @hourly
+	Schedule string
+	// Limite de cada ejecucion, cero no limita
+	Timeout time.Duration
+	Run     func(ctx context.Context) (any, error)
+}
+
+// Ejecucion de una tarea en el historial
+type Run struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Job        string             `json:"job" bson:"job"`
+	Trigger    string             `json:"trigger" bson:"trigger"`
+	Owner      string             `json:"owner" bson:"owner"`
+	State      string             `json:"state" bson:"state"`
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	DurationMs int64              `json:"duration_ms" bson:"duration_ms"`
+	Result     bson.M             `json:"result,omitempty" bson:"result,omitempty"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Estado de una tarea registrada
+type Info struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Schedule    string    `json:"schedule"`
+	NextRun     time.Time `json:"next_run"`
+	Running     bool      `json:"running"`
+	LastRun     *Run      `json:"last_run"`
+}
+
+// Parsea una expresion cron de cinco campos o un descriptor como @daily
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: expresion cron invalida %q: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// Planificador de tareas en proceso. Todas las instancias registran las mismas tareas,
+// pero solo la que tiene el bloqueo del lider ejecuta las programadas. Cada tarea tiene
+// ademas su propio bloqueo, de modo que una ejecucion manual en otra instancia no se
+// superpone con la programada.
+type Scheduler struct {
+	Runs  *mongo.Collection
+	Locks *mongo.Collection
+	// Dueño de los bloqueos, host:pid si esta vacio
+	Owner string
+	// Vigencia de los bloqueos, se renuevan cada tercio
+	LockTTL time.Duration
+	Logger  *slog.Logger
+	// Reloj, time.Now si es nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	running map[string]bool
+	leader  bool
+	ctx     context.Context
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	job      Job
+	schedule cron.Schedule
+	next     time.Time
+}
+
+// Registra una tarea. Falla si la expresion es invalida o el nombre esta repetido.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Run == nil {
+		return errors.New("jobs: la tarea necesita nombre y funcion")
+	}
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]*entry{}
+		s.running = map[string]bool{}
+	}
+	if _, ok := s.entries[job.Name]; ok {
+		return fmt.Errorf("jobs: tarea repetida %q", job.Name)
+	}
+	s.entries[job.Name] = &entry{job: job, schedule: schedule, next: schedule.Next(s.now())}
+	return nil
+}
+
+// Retorna las tareas registradas ordenadas por nombre, sin su ultima ejecucion
+func (s *Scheduler) Jobs() []Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Info, 0, len(s.entries))
+	for name, e := range s.entries {
+		list = append(list, Info{
+			Name:        name,
+			Description: e.job.Description,
+			Schedule:    e.job.Schedule,
+			NextRun:     e.next,
+			Running:     s.running[name],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Retorna el estado de una tarea registrada
+func (s *Scheduler) Job(name string) (Info, bool) {
+	for _, info := range s.Jobs() {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+// Indica si esta instancia ejecuta las tareas programadas
+func (s *Scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// Ejecuta las tareas programadas hasta que se cancele el contexto. En cada vuelta toma o
+// renueva el bloqueo del lider; las instancias que no lo tienen solo avanzan sus horarios.
+// Al terminar libera el bloqueo y espera las ejecuciones en curso.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	leader := s.lock(LeaderLock)
+	defer func() {
+		s.wg.Wait()
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := leader.Release(releaseCtx); err != nil {
+			s.log().Warn("scheduler leader lock not released", "error", err)
+		}
+	}()
+
+	for {
+		ok, err := leader.Acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.log().Error("scheduler leader lock failed", "error", err)
+		}
+		s.setLeader(ok)
+
+		for _, job := range s.due(s.now()) {
+			if !ok {
+				continue
+			}
+			if _, err := s.start(ctx, job, TriggerSchedule); err != nil && !errors.Is(err, ErrRunning) {
+				s.log().Error("scheduled job not started", "job", job.Name, "error", err)
+			}
+		}
+
+		// Despierta con la proxima tarea o a tiempo para renovar el bloqueo
+		wait := s.lockTTL() / 3
+		if next, ok := s.nextRun(); ok && next.Sub(s.now()) < wait {
+			wait = max(next.Sub(s.now()), 0)
+		}
+		select {
+		case <-ctx.Done():
+			s.setLeader(false)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Inicia una ejecucion manual en segundo plano y retorna su entrada del historial
+func (s *Scheduler) Trigger(ctx context.Context, name string) (*Run, error) {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	base := s.ctx
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// La ejecucion sigue despues de la peticion, hasta que se detenga el planificador
+	if base == nil {
+		base = context.WithoutCancel(ctx)
+	}
+	return s.start(base, e.job, TriggerManual)
+}
+
+// Recupera las ultimas ejecuciones de una tarea, las mas recientes primero
+func (s *Scheduler) History(ctx context.Context, name string, limit int) ([]Run, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := s.Runs.Find(ctx, bson.M{"job": name}, opts)
+	if err != nil {
+		return nil, err
+	}
+	runs := []Run{}
+	if err := cur.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Recupera la ultima ejecucion de una tarea, nil si nunca se ejecuto
+func (s *Scheduler) LastRun(ctx context.Context, name string) (*Run, error) {
+	runs, err := s.History(ctx, name, 1)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+// Toma los bloqueos de la tarea, registra la ejecucion y la lanza en segundo plano
+func (s *Scheduler) start(ctx context.Context, job Job, trigger string) (*Run, error) {
+	s.mu.Lock()
+	if s.running[job.Name] {
+		s.mu.Unlock()
+		return nil, ErrRunning
+	}
+	s.running[job.Name] = true
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		delete(s.running, job.Name)
+		s.mu.Unlock()
+	}
+
+	lock := s.lock("job:" + job.Name)
+	if ok, err := lock.Acquire(ctx); err != nil || !ok {
+		release()
+		if err == nil {
+			err = ErrRunning
+		}
+		return nil, err
+	}
+
+	run := &Run{
+		Job:       job.Name,
+		Trigger:   trigger,
+		Owner:     s.owner(),
+		State:     StateRunning,
+		StartedAt: s.now(),
+	}
+	res, err := s.Runs.InsertOne(ctx, run)
+	if err != nil {
+		s.unlock(lock)
+		release()
+		return nil, err
+	}
+	run.ID = res.InsertedID.(primitive.ObjectID)
+
+	started := *run
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer release()
+		defer s.unlock(lock)
+		s.execute(ctx, job, lock, run)
+	}()
+	return &started, nil
+}
+
+// Ejecuta la tarea con el bloqueo renovado y guarda el resultado. Si el bloqueo se
+// pierde se cancela la ejecucion.
+func (s *Scheduler) execute(ctx context.Context, job Job, lock *database.Lock, run *Run) {
+	runCtx, cancel := context.WithCancel(ctx)
+	if job.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, job.Timeout)
+	}
+	defer cancel()
+	lost := lock.KeepAlive(runCtx)
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	s.log().Info("job started", "job", job.Name, "trigger", run.Trigger, "run_id", run.ID.Hex())
+	result, err := s.call(runCtx, job)
+
+	finished := s.now()
+	run.FinishedAt = &finished
+	run.DurationMs = finished.Sub(run.StartedAt).Milliseconds()
+	run.Result = snapshot(result)
+	run.State = StateSucceeded
+	if err != nil {
+		run.State = StateFailed
+		run.Error = err.Error()
+		s.log().Error("job failed", "job", job.Name, "run_id", run.ID.Hex(), "duration_ms", run.DurationMs, "error", err)
+	} else {
+		s.log().Info("job finished", "job", job.Name, "run_id", run.ID.Hex(), "duration_ms", run.DurationMs)
+	}
+
+	// El resultado se guarda aunque el planificador se este deteniendo
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancelSave()
+	if _, err := s.Runs.ReplaceOne(saveCtx, bson.M{"_id": run.ID}, run); err != nil {
+		s.log().Error("job run not recorded", "job", job.Name, "run_id", run.ID.Hex(), "error", err)
+	}
+}
+
+// Llama a la tarea convirtiendo un panic en error para no detener el proceso
+func (s *Scheduler) call(ctx context.Context, job Job) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: panic en %s: %v", job.Name, r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// Retorna las tareas vencidas a la fecha indicada y avanza sus horarios
+func (s *Scheduler) due(now time.Time) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []Job
+	for _, e := range s.entries {
+		if !e.next.After(now) {
+			jobs = append(jobs, e.job)
+			e.next = e.schedule.Next(now)
+		}
+	}
+	return jobs
+}
+
+// Retorna el horario mas proximo entre las tareas registradas
+func (s *Scheduler) nextRun() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, e := range s.entries {
+		if next.IsZero() || e.next.Before(next) {
+			next = e.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (s *Scheduler) setLeader(leader bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if leader != s.leader {
+		s.log().Info("scheduler leadership changed", "leader", leader, "owner", s.owner())
+	}
+	s.leader = leader
+}
+
+func (s *Scheduler) lock(name string) *database.Lock {
+	return &database.Lock{Coll: s.Locks, Name: name, Owner: s.owner(), TTL: s.lockTTL()}
+}
+
+func (s *Scheduler) unlock(lock *database.Lock) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := lock.Release(ctx); err != nil {
+		s.log().Warn("job lock not released", "lock", lock.Name, "error", err)
+	}
+}
+
+// Convierte el resultado de una tarea en un documento para el historial
+func snapshot(v any) bson.M {
+	if v == nil {
+		return nil
+	}
+	if doc, ok := v.(bson.M); ok {
+		return doc
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return bson.M{"value": fmt.Sprint(v)}
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+func (s *Scheduler) owner() string {
+	if s.Owner != "" {
+		return s.Owner
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (s *Scheduler) lockTTL() time.Duration {
+	if s.LockTTL <= 0 {
+		return time.Minute
+	}
+	return s.LockTTL
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Scheduler) log() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey struct{}
+
+// Retorna el request id guardado en el contexto, o vacio si no existe
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Guarda el request id en el contexto
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// Retorna el request id de la peticion en curso
+func RequestID(c echo.Context) string {
+	return RequestIDFromContext(c.Request().Context())
+}
+
+// Middleware que propaga el encabezado X-Request-ID o genera uno nuevo,
+// lo devuelve en la respuesta y lo guarda en el contexto de la peticion
+func RequestIDMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" || len(id) > 128 {
+				id = newRequestID()
+			}
+
+			c.SetRequest(req.WithContext(WithRequestID(req.Context(), id)))
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			return next(c)
+		}
+	}
+}
+
+// Middleware que registra cada peticion como un evento estructurado
+func AccessLog(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Deja que Echo escriba la respuesta de error para registrar el estado final
+				c.Error(err)
+			}
+
+			req := c.Request()
+			res := c.Response()
+			level := slog.LevelInfo
+			if res.Status >= 500 {
+				level = slog.LevelError
+			} else if res.Status >= 400 {
+				level = slog.LevelWarn
+			}
+
+			logger.LogAttrs(req.Context(), level, "http request",
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("route", c.Path()),
+				slog.Int("status", res.Status),
+				slog.Int64("bytes", res.Size),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("remote_ip", c.RealIP()),
+			)
+			return nil
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,9 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"backend/apikeys"
+	"backend/auth"
+	"backend/config"
+	"backend/database"
+	"backend/events"
 	"backend/handlers"
+	"backend/idempotency"
+	"backend/jobs"
+	"backend/logging"
+	"backend/metrics"
+	"backend/migrations"
+	"backend/notifications"
+	"backend/ratelimit"
+	"backend/routes"
+	"backend/telemetry"
+	"backend/webhooks"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,44 +35,245 @@ import (
 )
 
 func main() {
+	// Carga la configuracion desde el archivo opcional y las variables de entorno
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Logger estructurado en JSON
+	logger := logging.New(cfg.Log.Level, os.Stdout)
+	slog.SetDefault(logger)
+
+	// Contexto que se cancela al recibir SIGINT o SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Trazas de OpenTelemetry
+	shutdownTracing, err := telemetry.Setup(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		logger.Error("no se pudo configurar el tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Metricas de Prometheus
+	m := metrics.New()
+
 	// Instancia de Echo
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.HideBanner = true
+	e.HidePort = true
+	e.Server.ReadTimeout = cfg.Server.ReadTimeout
+	e.Server.WriteTimeout = cfg.Server.WriteTimeout
+	// La IP del cliente solo se toma de X-Forwarded-For detras de un proxy de confianza
+	e.IPExtractor = echo.ExtractIPDirect()
+	if cfg.Server.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
 	e.Use(middleware.Recover())
+	e.Use(telemetry.Middleware(cfg.Tracing.ServiceName))
+	e.Use(logging.RequestIDMiddleware())
+	e.Use(logging.AccessLog(logger))
+	e.Use(m.Middleware())
 
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	monitor := database.ChainMonitors(m.CommandMonitor(), telemetry.CommandMonitor())
+	client, err := database.Connect(ctx, cfg.Mongo, options.Client().SetMonitor(monitor))
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("no se pudo conectar a mongo", "error", err)
+		os.Exit(1)
 	}
+	logger.Info("conectado a mongo", "database", cfg.Mongo.Database)
 
-	if err := client.Ping(context.Background(), nil); err != nil {
-		log.Fatal(err)
+	// Define la base de datos y la coleccion
+	db := client.Database(cfg.Mongo.Database)
+
+	// Aplica las migraciones pendientes antes de atender trafico
+	if cfg.Migrations.OnStartup {
+		migrateCtx, cancel := context.WithTimeout(ctx, cfg.Migrations.Timeout)
+		runner := &migrations.Runner{DB: db, Logger: logger, LockTTL: cfg.Migrations.LockTTL}
+		applied, err := runner.Up(migrateCtx)
+		cancel()
+		if err != nil {
+			logger.Error("no se pudieron aplicar las migraciones", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("migraciones al dia", "applied", len(applied))
 	}
 
-	// Define la base de datos y la coleccion
-	db := client.Database("practica_parcial_final")
+	// Sincroniza los indices con el registro y reporta las diferencias
+	if cfg.Indexes.SyncOnStartup {
+		indexCtx, cancel := context.WithTimeout(ctx, cfg.Indexes.Timeout)
+		drift, err := database.SyncIndexes(indexCtx, db, database.IndexRegistry, database.SyncOptions{DropUnknown: cfg.Indexes.DropUnknown})
+		cancel()
+		for _, d := range drift {
+			logger.Warn("diferencia de indices", "collection", d.Collection, "index", d.Name, "action", d.Action, "reason", d.Reason, "applied", d.Applied)
+		}
+		// Un indice que no se pudo crear se reporta y el servidor sigue atendiendo, salvo en modo estricto
+		if err != nil && cfg.Indexes.Strict {
+			logger.Error("no se pudieron sincronizar los indices", "error", err)
+			os.Exit(1)
+		} else if err != nil {
+			logger.Error("indices sin sincronizar, el servidor continua", "error", err)
+		}
+	}
+
+	h := handlers.NewHandler(db.Collection(database.BooksCollection), db.Collection(database.UsersCollection), db.Collection(database.LoansCollection))
+	h.LoanPolicy = cfg.Loans
+	h.Timeouts = cfg.Mongo.Timeouts
+	h.Holds = db.Collection(database.HoldsCollection)
+	if cfg.Audit.Enabled {
+		h.Audit = db.Collection(database.AuditCollection)
+	}
+	h.Outbox = db.Collection(database.OutboxCollection)
+	h.Webhooks = &webhooks.Service{
+		Subscriptions: db.Collection(database.WebhooksCollection),
+		Deliveries:    db.Collection(database.WebhookDeliveriesCollection),
+		Sender:        webhooks.Sender{Client: &http.Client{Timeout: cfg.Webhooks.Timeout}},
+		Logger:        logger,
+		Lease:         cfg.Webhooks.Lease,
+		Workers:       cfg.Webhooks.Workers,
+		MaxAttempts:   cfg.Webhooks.MaxAttempts,
+		BaseBackoff:   cfg.Webhooks.BaseBackoff,
+		MaxBackoff:    cfg.Webhooks.MaxBackoff,
+	}
+
+	h.Stream = &events.Stream{
+		Outbox:       h.Outbox,
+		Logger:       logger,
+		PollInterval: cfg.Stream.PollInterval,
+		GapTimeout:   cfg.Stream.GapTimeout,
+	}
+	h.StreamHeartbeat = cfg.Stream.Heartbeat
+
+	// API keys y tokens de usuario
+	h.APIKeys = &apikeys.Service{Coll: db.Collection(database.APIKeysCollection), Logger: logger}
+	if cfg.Auth.TokenSecret != "" {
+		h.Tokens = &auth.TokenSigner{Secret: []byte(cfg.Auth.TokenSecret), TTL: cfg.Auth.TokenTTL}
+	}
+
+	// Las escrituras y sus eventos se confirman juntos solo si Mongo soporta transacciones
+	h.Transactions, err = database.SupportsTransactions(ctx, client)
+	if err != nil {
+		logger.Warn("no se pudo detectar el soporte de transacciones", "error", err)
+	}
+	if !h.Transactions {
+		logger.Warn("mongo sin soporte de transacciones, el outbox se escribe fuera de transaccion")
+	}
+	// Tareas periodicas; todas las instancias las registran pero solo el lider las ejecuta
+	if cfg.Jobs.Enabled {
+		h.Jobs, err = scheduler(cfg, h, db, logger)
+		if err != nil {
+			logger.Error("no se pudo configurar el planificador de tareas", "error", err)
+			os.Exit(1)
+		}
+	} else if cfg.Notifications.Enabled {
+		logger.Warn("las notificaciones por correo no se envian con el planificador de tareas deshabilitado")
+	}
+	h.Client = client
+	h.Metrics = m
+	h.Logger = logger
+	m.RegisterLibrary(h.Books, h.Loans, cfg.Mongo.Timeouts.Read)
+
+	// Rutas versionadas bajo /api/v1 y /api/v2, con alias obsoletos en la raiz
+	deprecation, sunset, _ := cfg.API.LegacyDates()
+	opts := routes.Options{
+		AdminToken:        cfg.Auth.AdminToken,
+		Metrics:           m.Handler(),
+		LegacyDeprecation: deprecation,
+		LegacySunset:      sunset,
+		Idempotency: &idempotency.Store{
+			Coll:           db.Collection(database.IdempotencyCollection),
+			TTL:            cfg.Idempotency.TTL,
+			LockTTL:        cfg.Idempotency.LockTTL,
+			MaxRequestBody: int64(cfg.Idempotency.MaxRequestBody),
+			Logger:         logger,
+		},
+		Auth: &auth.Authenticator{
+			Keys:       h.APIKeys,
+			Tokens:     h.Tokens,
+			AdminToken: cfg.Auth.AdminToken,
+			Required:   cfg.Auth.Required,
+			// Los cambios auditados siempre tienen una identidad
+			RequiredForWrites: cfg.Audit.Enabled,
+			Logger:            logger,
+		},
+	}
+	if cfg.RateLimit.Enabled {
+		opts.RateLimit = &ratelimit.Limiter{
+			Store:   ratelimit.NewMemory(),
+			Default: rateLimit(cfg.RateLimit.Default),
+			Groups:  map[string]ratelimit.Limit{},
+			Logger:  logger,
+		}
+		for group, limit := range cfg.RateLimit.Groups {
+			opts.RateLimit.Groups[group] = rateLimit(limit)
+		}
+	}
+	routes.Register(e, h, opts)
+
+	// Despachador de eventos del outbox
+	dispatcher := &events.Dispatcher{
+		Outbox:      h.Outbox,
+		Publisher:   events.Fanout{events.LogPublisher(logger), h.Webhooks},
+		Logger:      logger,
+		Interval:    cfg.Outbox.PollInterval,
+		BatchSize:   cfg.Outbox.BatchSize,
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+		BaseBackoff: cfg.Outbox.BaseBackoff,
+		MaxBackoff:  cfg.Outbox.MaxBackoff,
+	}
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dispatcher.Run(workers)
+	}()
 
-	h := handlers.NewHandler(db.Collection("books"), db.Collection("users"), db.Collection("loans"))
+	// Workers de las entregas de webhooks
+	go func() {
+		defer wg.Done()
+		h.Webhooks.Run(workers, cfg.Webhooks.RetryInterval)
+	}()
 
-	// Rutas para la gestion de inventarios
-	e.GET("/books", h.GetBooks)
-	e.GET("/books/:id", h.GetBookById)
-	e.POST("/books", h.CreateBook)
-	e.PUT("/books/:id", h.UpdateBook)
-	e.DELETE("/books/:id", h.DeleteBook)
+	// Planificador de tareas periodicas
+	if h.Jobs != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Jobs.Run(workers)
+		}()
+	}
+
+	// Inicia el servidor en segundo plano para poder atender las señales de apagado
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("servidor escuchando", "addr", cfg.Server.Addr)
+		if err := e.Start(cfg.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
 
-	// Rutas para la gestion de inventarios
-	e.GET("/users", h.GetUsers)
-	e.GET("/users/:id", h.GetUserById)
-	e.POST("/users", h.CreateUser)
-	e.DELETE("/users/:id", h.DeleteUser)
+	select {
+	case <-ctx.Done():
+		logger.Info("señal de apagado recibida")
+	case err := <-serverErr:
+		logger.Error("el servidor se detuvo inesperadamente", "error", err)
+	}
+	stop()
 
-	// Rutas para la gestion de inventarios
-	e.GET("/loans", h.GetLoans)
-	e.POST("/loans", h.CreateLoan)
-	e.PUT("/return-loan/:id", h.ReturnLoan)
+	shutdown(logger, e, h, client, cfg.Server.ShutdownTimeout, func() {
+		// Detiene los procesos en segundo plano antes de cerrar Mongo
+		stopWorkers()
+		wg.Wait()
+	})
 
-	e.Logger.Fatal(e.Start(":8080"))
+	// Vacia las trazas pendientes antes de salir
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("error al cerrar el tracing", "error", err)
+	}
 	// Analisis estatico
 	// github.com/securego/gosec/v2/cmd/gosec@latest
 	// gosec ./...
@@ -57,4 +281,95 @@ func main() {
 	// Analisis de vulnerabilidades conocidas
 	// go install golang.org/x/vuln/cmd/govulncheck@latest
 	// govulncheck ./...
+}
+
+// Apaga el servidor de forma ordenada: deja de aceptar conexiones, drena las peticiones
+// en curso dentro del timeout, detiene los procesos en segundo plano y luego cierra la
+// conexion con MongoDB
+func shutdown(logger *slog.Logger, e *echo.Echo, h *handlers.Handler, client *mongo.Client, timeout time.Duration, stopWorkers func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// El chequeo de readiness empieza a fallar para que el orquestador deje de enviar trafico
+	h.SetShuttingDown()
+
+	logger.Info("drenando peticiones en curso", "timeout", timeout)
+	if err := e.Shutdown(ctx); err != nil {
+		logger.Error("no se pudieron drenar todas las peticiones", "error", err)
+	} else {
+		logger.Info("servidor HTTP detenido")
+	}
+
+	logger.Info("deteniendo procesos en segundo plano")
+	stopWorkers()
+
+	logger.Info("desconectando mongo")
+	if err := database.Disconnect(ctx, client); err != nil {
+		logger.Error("error al desconectar mongo", "error", err)
+	} else {
+		logger.Info("mongo desconectado")
+	}
+
+	logger.Info("apagado completo")
+}
+
+// Crea el planificador con las tareas de prestamos y reservas y, si estan habilitadas, las
+// notificaciones por correo
+func scheduler(cfg config.Config, h *handlers.Handler, db *mongo.Database, logger *slog.Logger) (*jobs.Scheduler, error) {
+	s := &jobs.Scheduler{
+		Runs:    db.Collection(database.JobRunsCollection),
+		Locks:   db.Collection(database.LocksCollection),
+		LockTTL: cfg.Jobs.LockTTL,
+		Logger:  logger,
+	}
+	list := []jobs.Job{
+		{Name: "overdue", Description: "Marca los prestamos vencidos", Schedule: cfg.Jobs.Overdue, Timeout: cfg.Jobs.Timeout, Run: h.MarkOverdueLoans},
+		{Name: "hold_expiry", Description: "Vence las reservas listas que no se retiraron", Schedule: cfg.Jobs.HoldExpiry, Timeout: cfg.Jobs.Timeout, Run: h.ExpireHolds},
+	}
+
+	if cfg.Notifications.Enabled {
+		templates, err := notifications.LoadTemplates()
+		if err != nil {
+			return nil, err
+		}
+		notifier := &notifications.Notifier{
+			Users:     h.Users,
+			Books:     h.Books,
+			Loans:     h.Loans,
+			Holds:     h.Holds,
+			Sent:      db.Collection(database.NotificationsCollection),
+			Templates: templates,
+			Sender: &notifications.SMTPSender{
+				Addr:     cfg.Notifications.SMTP.Addr,
+				From:     cfg.Notifications.SMTP.From,
+				Username: cfg.Notifications.SMTP.Username,
+				Password: cfg.Notifications.SMTP.Password,
+				Timeout:  cfg.Notifications.SMTP.Timeout,
+			},
+			DueSoonDays: cfg.Notifications.DueSoonDays,
+			Language:    cfg.Notifications.Language,
+			Logger:      logger,
+		}
+		list = append(list, jobs.Job{
+			Name:        "notifications",
+			Description: "Envia recordatorios de vencimiento, avisos de atraso y reservas listas por correo",
+			Schedule:    cfg.Jobs.Notifications,
+			Timeout:     cfg.Jobs.Timeout,
+			Run: func(ctx context.Context) (any, error) {
+				return notifier.Scan(ctx)
+			},
+		})
+	}
+
+	for _, job := range list {
+		if err := s.Add(job); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Convierte un limite de la configuracion en un limite del limitador
+func rateLimit(l config.RateLimit) ratelimit.Limit {
+	return ratelimit.Limit{Requests: l.Requests, Period: l.Period, Burst: l.Burst}
 }
\ No newline at end of file
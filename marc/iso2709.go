@@ -0,0 +1,169 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Separadores del formato ISO 2709 usado por MARC21 binario
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+	leaderLength      = 24
+	directoryEntry    = 12
+)
+
+// Lector de MARC21 binario
+type binaryReader struct {
+	r *bufio.Reader
+}
+
+// Crea un lector de registros MARC21 binarios (ISO 2709)
+func NewReader(r io.Reader) Reader {
+	return &binaryReader{r: bufio.NewReader(r)}
+}
+
+func (b *binaryReader) Next() (Record, error) {
+	// Omite saltos de linea entre registros que agregan algunos exportadores
+	for {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return Record{}, err
+		}
+		if c != '\n' && c != '\r' {
+			_ = b.r.UnreadByte()
+			break
+		}
+	}
+
+	// Los primeros 5 bytes del leader son la longitud total del registro
+	head, err := b.r.Peek(5)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	length, err := strconv.Atoi(string(head))
+	if err != nil || length < leaderLength+1 {
+		return Record{}, fmt.Errorf("marc: longitud de registro invalida %q", head)
+	}
+
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(b.r, raw); err != nil {
+		return Record{}, fmt.Errorf("marc: registro incompleto: %w", err)
+	}
+
+	rec, err := parseRecord(raw)
+	if err != nil {
+		return Record{}, &RecordError{Err: err}
+	}
+	return rec, nil
+}
+
+// Decodifica un registro ISO 2709 completo
+func parseRecord(raw []byte) (Record, error) {
+	if raw[len(raw)-1] != recordTerminator {
+		return Record{}, errors.New("marc: falta el terminador de registro")
+	}
+	leader := string(raw[:leaderLength])
+	base, err := strconv.Atoi(leader[12:17])
+	if err != nil || base <= leaderLength || base > len(raw) {
+		return Record{}, fmt.Errorf("marc: direccion base invalida %q", leader[12:17])
+	}
+
+	// El directorio va del leader al terminador de campo previo a la direccion base
+	directory := raw[leaderLength : base-1]
+	if len(directory)%directoryEntry != 0 {
+		return Record{}, errors.New("marc: directorio invalido")
+	}
+
+	rec := Record{Leader: leader}
+	for i := 0; i < len(directory); i += directoryEntry {
+		entry := directory[i : i+directoryEntry]
+		tag := string(entry[:3])
+		length, err1 := number(entry[3:7])
+		start, err2 := number(entry[7:12])
+		if err1 != nil || err2 != nil || base+start+length > len(raw) {
+			return Record{}, fmt.Errorf("marc: entrada de directorio invalida para %s", tag)
+		}
+
+		data := bytes.TrimSuffix(raw[base+start:base+start+length], []byte{fieldTerminator})
+		rec.Fields = append(rec.Fields, parseField(tag, data))
+	}
+	return rec, nil
+}
+
+// Lee un numero del directorio, solo digitos: un signo desplazaria el campo fuera del registro
+func number(b []byte) (int, error) {
+	n, err := strconv.ParseUint(string(b), 10, 32)
+	return int(n), err
+}
+
+func parseField(tag string, data []byte) Field {
+	field := Field{Tag: tag}
+	if field.IsControl() {
+		field.Value = string(data)
+		return field
+	}
+
+	if len(data) >= 2 {
+		field.Ind1, field.Ind2 = string(data[0]), string(data[1])
+		data = data[2:]
+	}
+	for _, part := range bytes.Split(data, []byte{subfieldDelimiter}) {
+		if len(part) == 0 {
+			continue
+		}
+		field.Subfields = append(field.Subfields, Subfield{Code: string(part[0]), Value: string(part[1:])})
+	}
+	return field
+}
+
+// Codifica un registro en MARC21 binario, usado para exportar y en las pruebas
+func Marshal(rec Record) []byte {
+	var directory, data bytes.Buffer
+	for _, f := range rec.Fields {
+		start := data.Len()
+		if f.IsControl() {
+			data.WriteString(f.Value)
+		} else {
+			data.WriteString(pad(f.Ind1) + pad(f.Ind2))
+			for _, sf := range f.Subfields {
+				data.WriteByte(subfieldDelimiter)
+				data.WriteString(sf.Code + sf.Value)
+			}
+		}
+		data.WriteByte(fieldTerminator)
+		fmt.Fprintf(&directory, "%3s%04d%05d", f.Tag, data.Len()-start, start)
+	}
+	directory.WriteByte(fieldTerminator)
+
+	base := leaderLength + directory.Len()
+	length := base + data.Len() + 1
+
+	leader := []byte(rec.Leader)
+	if len(leader) != leaderLength {
+		leader = []byte("00000nam a2200000 a 4500")
+	}
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+
+	out := make([]byte, 0, length)
+	out = append(out, leader...)
+	out = append(out, directory.Bytes()...)
+	out = append(out, data.Bytes()...)
+	return append(out, recordTerminator)
+}
+
+func pad(ind string) string {
+	if ind == "" {
+		return " "
+	}
+	return ind[:1]
+}
@@ -0,0 +1,52 @@
+package marc
+
+import (
+	"strings"
+
+	"backend/models"
+)
+
+// Regla que copia un campo MARC a un libro. Se aplica al primer campo con la
+// etiqueta Tag que tenga valor en los subcampos indicados.
+type Rule struct {
+	Tag       string
+	Subfields string
+	Apply     func(book *models.Book, value string)
+}
+
+// Reglas por defecto: titulo (245), autor (100) e ISBN (020). Para mapear otros campos
+// se agregan reglas a una copia de esta lista.
+var DefaultRules = []Rule{
+	{Tag: "245", Subfields: "ab", Apply: func(b *models.Book, v string) { b.Title = cleanPunctuation(v) }},
+	{Tag: "100", Subfields: "a", Apply: func(b *models.Book, v string) { b.Author = cleanPunctuation(v) }},
+	{Tag: "020", Subfields: "a", Apply: func(b *models.Book, v string) { b.Isbn = isbnFromSubfield(v) }},
+}
+
+// Convierte un registro en un libro aplicando las reglas en orden
+func ToBook(rec Record, rules []Rule) models.Book {
+	var book models.Book
+	for _, rule := range rules {
+		for _, f := range rec.FieldsByTag(rule.Tag) {
+			if v := strings.TrimSpace(f.Join(rule.Subfields)); v != "" {
+				rule.Apply(&book, v)
+				break
+			}
+		}
+	}
+	return book
+}
+
+// Quita la puntuacion ISBD final de los campos, por ejemplo "Rayuela /" o "Cortazar, Julio,"
+func cleanPunctuation(v string) string {
+	v = strings.Join(strings.Fields(v), " ")
+	return strings.TrimSpace(strings.TrimRight(v, " /:;,="))
+}
+
+// El subcampo 020 $a puede traer calificadores, por ejemplo "9780307474728 (pbk.)"
+func isbnFromSubfield(v string) string {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return ""
+	}
+	return models.NormalizeIsbn(fields[0])
+}
@@ -0,0 +1,77 @@
+package marc
+
+import (
+	"strings"
+)
+
+// Registro bibliografico MARC
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// Campo de un registro. Los campos de control (00X) solo tienen Value,
+// los campos de datos tienen indicadores y subcampos.
+type Field struct {
+	Tag       string
+	Ind1      string
+	Ind2      string
+	Value     string
+	Subfields []Subfield
+}
+
+// Subcampo de un campo de datos
+type Subfield struct {
+	Code  string
+	Value string
+}
+
+// Indica si el campo es de control
+func (f Field) IsControl() bool {
+	return strings.HasPrefix(f.Tag, "00")
+}
+
+// Une los valores de los subcampos indicados en codes, todos si codes esta vacio
+func (f Field) Join(codes string) string {
+	if f.IsControl() {
+		return f.Value
+	}
+	var parts []string
+	for _, sf := range f.Subfields {
+		if codes == "" || strings.Contains(codes, sf.Code) {
+			parts = append(parts, strings.TrimSpace(sf.Value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Retorna los campos con la etiqueta indicada
+func (r Record) FieldsByTag(tag string) []Field {
+	var out []Field
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Error de un registro mal formado. El lector puede continuar con el siguiente registro,
+// cualquier otro error de Next deja el lector en un estado invalido.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Lector de registros, implementado para MARC21 binario y MARCXML.
+// Next retorna io.EOF cuando no quedan registros.
+type Reader interface {
+	Next() (Record, error)
+}
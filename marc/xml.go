@@ -0,0 +1,66 @@
+package marc
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// Lector de MARCXML, decodifica un registro a la vez sin cargar todo el archivo
+type xmlReader struct {
+	dec *xml.Decoder
+}
+
+// Estructura XML de un registro MARCXML. Se compara solo el nombre local para
+// aceptar el espacio de nombres con o sin prefijo.
+type xmlRecord struct {
+	Leader        string `xml:"leader"`
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []struct {
+		Tag       string `xml:"tag,attr"`
+		Ind1      string `xml:"ind1,attr"`
+		Ind2      string `xml:"ind2,attr"`
+		Subfields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+// Crea un lector de registros MARCXML, con o sin elemento collection
+func NewXMLReader(r io.Reader) Reader {
+	return &xmlReader{dec: xml.NewDecoder(r)}
+}
+
+func (x *xmlReader) Next() (Record, error) {
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return Record{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		var raw xmlRecord
+		if err := x.dec.DecodeElement(&raw, &start); err != nil {
+			return Record{}, err
+		}
+
+		rec := Record{Leader: raw.Leader}
+		for _, cf := range raw.ControlFields {
+			rec.Fields = append(rec.Fields, Field{Tag: cf.Tag, Value: cf.Value})
+		}
+		for _, df := range raw.DataFields {
+			field := Field{Tag: df.Tag, Ind1: df.Ind1, Ind2: df.Ind2}
+			for _, sf := range df.Subfields {
+				field.Subfields = append(field.Subfields, Subfield{Code: sf.Code, Value: sf.Value})
+			}
+			rec.Fields = append(rec.Fields, field)
+		}
+		return rec, nil
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"backend/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Recolector de metricas de dominio calculadas en cada lectura de /metrics
+type libraryCollector struct {
+	books   *mongo.Collection
+	loans   *mongo.Collection
+	timeout time.Duration
+
+	activeLoans    *prometheus.Desc
+	overdueLoans   *prometheus.Desc
+	unavailable    *prometheus.Desc
+	scrapeFailures *prometheus.Desc
+}
+
+// Registra los gauges de dominio: prestamos activos, prestamos vencidos y libros sin disponibilidad
+func (m *Metrics) RegisterLibrary(books, loans *mongo.Collection, timeout time.Duration) {
+	m.Registry.MustRegister(&libraryCollector{
+		books:   books,
+		loans:   loans,
+		timeout: timeout,
+		activeLoans: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "loans_active"),
+			"Prestamos sin devolver.", nil, nil),
+		overdueLoans: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "loans_overdue"),
+			"Prestamos sin devolver con la fecha de devolucion vencida.", nil, nil),
+		unavailable: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "books_unavailable"),
+			"Libros con disponibilidad cero.", nil, nil),
+		scrapeFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "domain_scrape_error"),
+			"1 si la ultima consulta de metricas de dominio fallo.", nil, nil),
+	})
+}
+
+func (l *libraryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.activeLoans
+	ch <- l.overdueLoans
+	ch <- l.unavailable
+	ch <- l.scrapeFailures
+}
+
+func (l *libraryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	failed := 0.0
+	count := func(coll *mongo.Collection, filter bson.M, desc *prometheus.Desc) {
+		if coll == nil {
+			return
+		}
+		n, err := coll.CountDocuments(ctx, filter)
+		if err != nil {
+			failed = 1
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(n))
+	}
+
+	count(l.loans, database.OpenLoansFilter(), l.activeLoans)
+	count(l.loans, database.OverdueLoansFilter(time.Now()), l.overdueLoans)
+	count(l.books, bson.M{"availability": bson.M{"$lte": 0}}, l.unavailable)
+
+	ch <- prometheus.MustNewConstMetric(l.scrapeFailures, prometheus.GaugeValue, failed)
+}
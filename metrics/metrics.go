@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prefijo de todas las metricas del servicio
+const namespace = "library"
+
+// Metricas del servicio registradas en un registro propio
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpDuration  *prometheus.HistogramVec
+	mongoDuration *prometheus.HistogramVec
+	loansCreated  prometheus.Counter
+	loansReturned prometheus.Counter
+}
+
+// Crea y registra las metricas HTTP, de MongoDB y de dominio
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latencia de las peticiones HTTP por ruta, metodo y estado.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		mongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "mongo",
+			Name:      "command_duration_seconds",
+			Help:      "Duracion de los comandos enviados a MongoDB.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"command", "outcome"}),
+		loansCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "loans_created_total",
+			Help:      "Prestamos creados.",
+		}),
+		loansReturned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "loans_returned_total",
+			Help:      "Prestamos devueltos.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpDuration,
+		m.mongoDuration,
+		m.loansCreated,
+		m.loansReturned,
+	)
+
+	return m
+}
+
+// Handler HTTP que expone las metricas en formato Prometheus
+func (m *Metrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry}))
+}
+
+// Middleware que mide la latencia de cada peticion por ruta y estado
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+			}
+
+			// Usa la plantilla de la ruta para no crear una serie por cada id
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			m.httpDuration.
+				WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Registra un prestamo creado
+func (m *Metrics) LoanCreated() {
+	if m == nil {
+		return
+	}
+	m.loansCreated.Inc()
+}
+
+// Registra un prestamo devuelto
+func (m *Metrics) LoanReturned() {
+	if m == nil {
+		return
+	}
+	m.loansReturned.Inc()
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Monitor de comandos del driver de MongoDB que registra la duracion de cada operacion
+func (m *Metrics) CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			m.observeCommand(evt.CommandName, "success", evt.Duration)
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			m.observeCommand(evt.CommandName, "failure", evt.Duration)
+		},
+	}
+}
+
+func (m *Metrics) observeCommand(command, outcome string, d time.Duration) {
+	m.mongoDuration.WithLabelValues(command, outcome).Observe(d.Seconds())
+}
@@ -0,0 +1,244 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Coleccion con las migraciones aplicadas
+const Collection = "migrations"
+
+// Nombre del bloqueo que impide que dos instancias migren a la vez
+const LockName = "migrations"
+
+// Migracion de esquema identificada por una version creciente. Down deshace Up y
+// debe poder ejecutarse aunque Up no haya modificado ningun documento.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Migracion aplicada, guardada en la coleccion de migraciones
+type Applied struct {
+	Version     int       `json:"version" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"applied_at" bson:"applied_at"`
+}
+
+// Estado de una migracion conocida
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migraciones del servicio en orden de version
+var All = []Migration{
+	backfillLoanTimestamps,
+	normalizeIsbns,
+}
+
+// Ejecuta las migraciones bajo un bloqueo distribuido
+type Runner struct {
+	DB         *mongo.Database
+	Migrations []Migration
+	Logger     *slog.Logger
+
+	// Dueño del bloqueo, por defecto el hostname y el pid
+	Owner string
+	// Vigencia del bloqueo, se renueva mientras se migra
+	LockTTL time.Duration
+	// Intervalo de espera cuando otra instancia tiene el bloqueo
+	LockPoll time.Duration
+}
+
+// Aplica en orden las migraciones pendientes y retorna las que aplico. Si otra instancia
+// esta migrando espera a que termine, hasta que venza el contexto.
+func (r *Runner) Up(ctx context.Context) ([]Applied, error) {
+	applied := []Applied{}
+	err := r.locked(ctx, func(ctx context.Context) error {
+		done, err := r.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range r.sorted() {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			r.logger().Info("applying migration", "version", m.Version, "description", m.Description)
+			if err := m.Up(ctx, r.DB); err != nil {
+				return fmt.Errorf("migrations: fallo la version %d (%s): %w", m.Version, m.Description, err)
+			}
+
+			record := Applied{Version: m.Version, Description: m.Description, AppliedAt: time.Now().UTC()}
+			if _, err := r.DB.Collection(Collection).InsertOne(ctx, record); err != nil {
+				return err
+			}
+			applied = append(applied, record)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Deshace las ultimas steps migraciones aplicadas, de la mas reciente a la mas antigua
+func (r *Runner) Down(ctx context.Context, steps int) ([]Applied, error) {
+	reverted := []Applied{}
+	err := r.locked(ctx, func(ctx context.Context) error {
+		done, err := r.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		list := r.sorted()
+		for i := len(list) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := list[i]
+			record, ok := done[m.Version]
+			if !ok {
+				continue
+			}
+			if m.Down == nil {
+				return fmt.Errorf("migrations: la version %d no se puede deshacer", m.Version)
+			}
+
+			r.logger().Info("reverting migration", "version", m.Version, "description", m.Description)
+			if err := m.Down(ctx, r.DB); err != nil {
+				return fmt.Errorf("migrations: fallo deshacer la version %d (%s): %w", m.Version, m.Description, err)
+			}
+			if _, err := r.DB.Collection(Collection).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+				return err
+			}
+			reverted = append(reverted, record)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Retorna todas las migraciones conocidas con su fecha de aplicacion
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	done, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := []Status{}
+	for _, m := range r.sorted() {
+		s := Status{Version: m.Version, Description: m.Description}
+		if record, ok := done[m.Version]; ok {
+			s.AppliedAt = &record.AppliedAt
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Ejecuta fn con el bloqueo de migraciones tomado. Si el bloqueo se pierde se cancela fn.
+func (r *Runner) locked(ctx context.Context, fn func(ctx context.Context) error) error {
+	lock := &database.Lock{
+		Coll:  r.DB.Collection(database.LocksCollection),
+		Name:  LockName,
+		Owner: r.owner(),
+		TTL:   r.lockTTL(),
+	}
+
+	poll := r.LockPoll
+	if poll <= 0 {
+		poll = time.Second
+	}
+	if err := lock.Wait(ctx, poll); err != nil {
+		return fmt.Errorf("migrations: no se pudo tomar el bloqueo: %w", err)
+	}
+	defer func() {
+		// Libera el bloqueo aunque el contexto de la migracion haya vencido
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := lock.Release(releaseCtx); err != nil {
+			r.logger().Warn("migration lock not released", "error", err)
+		}
+	}()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := lock.KeepAlive(runCtx)
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	err := fn(runCtx)
+	if ctx.Err() == nil && runCtx.Err() != nil && errors.Is(err, context.Canceled) {
+		return errors.New("migrations: se perdio el bloqueo durante la migracion")
+	}
+	return err
+}
+
+// Retorna las migraciones aplicadas por version
+func (r *Runner) applied(ctx context.Context) (map[int]Applied, error) {
+	cur, err := r.DB.Collection(Collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var records []Applied
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	done := make(map[int]Applied, len(records))
+	for _, record := range records {
+		done[record.Version] = record
+	}
+	return done, nil
+}
+
+func (r *Runner) sorted() []Migration {
+	list := r.Migrations
+	if list == nil {
+		list = All
+	}
+	list = append([]Migration{}, list...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func (r *Runner) owner() string {
+	if r.Owner != "" {
+		return r.Owner
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (r *Runner) lockTTL() time.Duration {
+	if r.LockTTL <= 0 {
+		return time.Minute
+	}
+	return r.LockTTL
+}
+
+func (r *Runner) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
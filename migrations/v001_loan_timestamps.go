@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"context"
+
+	"backend/config"
+	"backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Campo con los campos completados por la migracion, para poder deshacerla
+const backfilledField = "backfilled_fields"
+
+// Completa created_at y due_date en los prestamos creados antes de que existieran.
+// created_at se toma del ObjectID del prestamo y due_date suma la duracion por defecto.
+var backfillLoanTimestamps = Migration{
+	Version:     1,
+	Description: "backfill created_at y due_date en prestamos",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		loans := db.Collection(database.LoansCollection)
+		filter := bson.M{"$or": bson.A{
+			bson.M{"created_at": bson.M{"$exists": false}},
+			bson.M{"due_date": bson.M{"$exists": false}},
+		}}
+		cur, err := loans.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+
+		var writes []mongo.WriteModel
+		for cur.Next(ctx) {
+			var loan struct {
+				ID        primitive.ObjectID  `bson:"_id"`
+				CreatedAt *primitive.DateTime `bson:"created_at"`
+				DueDate   *primitive.DateTime `bson:"due_date"`
+			}
+			if err := cur.Decode(&loan); err != nil {
+				return err
+			}
+
+			created := loan.ID.Timestamp().UTC()
+			if loan.CreatedAt != nil {
+				created = loan.CreatedAt.Time().UTC()
+			}
+			set := bson.M{}
+			var fields bson.A
+			if loan.CreatedAt == nil {
+				set["created_at"] = created
+				fields = append(fields, "created_at")
+			}
+			if loan.DueDate == nil {
+				set["due_date"] = created.AddDate(0, 0, config.DefaultLoanDays)
+				fields = append(fields, "due_date")
+			}
+			set[backfilledField] = fields
+
+			writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": loan.ID}).SetUpdate(bson.M{"$set": set}))
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		return bulk(ctx, loans, writes)
+	},
+	Down: func(ctx context.Context, db *mongo.Database) error {
+		loans := db.Collection(database.LoansCollection)
+		// Solo quita los campos que la migracion completo
+		for _, field := range []string{"created_at", "due_date"} {
+			_, err := loans.UpdateMany(ctx, bson.M{backfilledField: field}, bson.M{"$unset": bson.M{field: ""}})
+			if err != nil {
+				return err
+			}
+		}
+		_, err := loans.UpdateMany(ctx, bson.M{backfilledField: bson.M{"$exists": true}}, bson.M{"$unset": bson.M{backfilledField: ""}})
+		return err
+	},
+}
+
+// Ejecuta las escrituras en lotes sin orden
+func bulk(ctx context.Context, coll *mongo.Collection, writes []mongo.WriteModel) error {
+	const size = 500
+	for start := 0; start < len(writes); start += size {
+		end := min(start+size, len(writes))
+		if _, err := coll.BulkWrite(ctx, writes[start:end], options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package migrations
+
+import (
+	"context"
+	"log/slog"
+
+	"backend/database"
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Campo con el ISBN original, para poder deshacer la migracion
+const originalIsbnField = "isbn_original"
+
+// Normaliza los ISBN de los libros quitando guiones y espacios. Los libros que quedarian con
+// el mismo ISBN se registran en el log y se dejan sin cambios para corregirlos a mano, asi una
+// base con duplicados anteriores al indice unico puede migrar y el servidor arranca.
+var normalizeIsbns = Migration{
+	Version:     2,
+	Description: "normalizar isbn de libros",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		books := db.Collection(database.BooksCollection)
+		cur, err := books.Find(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+
+		// Agrupa los libros por el ISBN normalizado, en el orden en que se leen
+		owners := map[string][]models.Book{}
+		var order []string
+		for cur.Next(ctx) {
+			var book models.Book
+			if err := cur.Decode(&book); err != nil {
+				return err
+			}
+
+			isbn := models.NormalizeIsbn(book.Isbn)
+			if _, ok := owners[isbn]; !ok {
+				order = append(order, isbn)
+			}
+			owners[isbn] = append(owners[isbn], book)
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+
+		var writes []mongo.WriteModel
+		for _, isbn := range order {
+			group := owners[isbn]
+			if len(group) > 1 {
+				ids := make([]string, len(group))
+				for i, book := range group {
+					ids[i] = book.ID.Hex()
+				}
+				slog.WarnContext(ctx, "books left with conflicting isbn, fix them manually", "isbn", isbn, "ids", ids)
+				continue
+			}
+
+			book := group[0]
+			if isbn == book.Isbn {
+				continue
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": book.ID}).
+				SetUpdate(bson.M{"$set": bson.M{"isbn": isbn, originalIsbnField: book.Isbn}}))
+		}
+		return bulk(ctx, books, writes)
+	},
+	Down: func(ctx context.Context, db *mongo.Database) error {
+		books := db.Collection(database.BooksCollection)
+		cur, err := books.Find(ctx, bson.M{originalIsbnField: bson.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+
+		var writes []mongo.WriteModel
+		for cur.Next(ctx) {
+			var book struct {
+				ID       primitive.ObjectID `bson:"_id"`
+				Original string             `bson:"isbn_original"`
+			}
+			if err := cur.Decode(&book); err != nil {
+				return err
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": book.ID}).
+				SetUpdate(bson.M{"$set": bson.M{"isbn": book.Original}, "$unset": bson.M{originalIsbnField: ""}}))
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		return bulk(ctx, books, writes)
+	},
+}
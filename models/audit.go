@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Entrada inmutable de la auditoria de cambios
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Actor     string             `json:"actor" bson:"actor"`
+	Action    string             `json:"action" bson:"action"`
+	Entity    string             `json:"entity" bson:"entity"`
+	EntityId  string             `json:"entity_id" bson:"entity_id"`
+	Before    bson.M             `json:"before" bson:"before"`
+	After     bson.M             `json:"after" bson:"after"`
+	RequestId string             `json:"request_id" bson:"request_id"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"strings"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -10,4 +12,11 @@ type Book struct {
 	Author       string             `json:"author" bson:"author"`
 	Isbn         string             `json:"isbn" bson:"isbn"`
 	Availability int                `json:"availability" bson:"availability"`
+	// Ejemplares totales, la disponibilidad se recalcula como ejemplares menos prestamos abiertos
+	Copies int `json:"copies,omitempty" bson:"copies,omitempty"`
+}
+
+// Normaliza un ISBN quitando guiones y espacios, con la X de control en mayuscula
+func NormalizeIsbn(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
 }
\ No newline at end of file
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Estados de una reserva
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldCanceled  = "canceled"
+	HoldFulfilled = "fulfilled"
+	HoldExpired   = "expired"
+)
+
+// Estados en los que la reserva sigue vigente
+var ActiveHoldStatuses = []string{HoldWaiting, HoldReady}
+
+// Reserva de un libro por un usuario, atendida en orden de creacion
+type Hold struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId     string             `json:"user_id" bson:"user_id"`
+	BookId     string             `json:"book_id" bson:"book_id"`
+	Status     string             `json:"status" bson:"status"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ReadyAt    *time.Time         `json:"ready_at,omitempty" bson:"ready_at,omitempty"`
+	CanceledAt *time.Time         `json:"canceled_at,omitempty" bson:"canceled_at,omitempty"`
+	ExpiredAt  *time.Time         `json:"expired_at,omitempty" bson:"expired_at,omitempty"`
+	// Clave de la reserva vigente del usuario sobre el libro, se quita al dejar de estar vigente
+	ActiveKey string `json:"-" bson:"active_key,omitempty"`
+}
+
+// Clave unica de la reserva vigente de un usuario sobre un libro
+func HoldActiveKey(userId, bookId string) string {
+	return userId + ":" + bookId
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -11,4 +13,11 @@ type Loan struct {
 	UserId      string 			   `json:"user_id" bson:"user_id"`
 	BookId      string 			   `json:"book_id" bson:"book_id"`
 	IsReturned  bool      		   `json:"is_returned" bson:"is_returned"`
+	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	DueDate     time.Time          `json:"due_date,omitempty" bson:"due_date,omitempty"`
+	ReturnedAt  *time.Time         `json:"returned_at,omitempty" bson:"returned_at,omitempty"`
+	Renewals    int                `json:"renewals,omitempty" bson:"renewals,omitempty"`
+	OverdueAt   *time.Time         `json:"overdue_at,omitempty" bson:"overdue_at,omitempty"`
+	// Lugar que ocupa el prestamo activo dentro del limite del usuario, se quita al devolverlo
+	ActiveSlot  string             `json:"-" bson:"active_slot,omitempty"`
 }
\ No newline at end of file
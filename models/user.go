@@ -8,4 +8,8 @@ type User struct {
 	ID    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name  string 			 `json:"name" bson:"name"`
 	Email string 			 `json:"email" bson:"email"`
+	// Idioma de las notificaciones (es o en), vacio usa el idioma por defecto
+	Language string `json:"language,omitempty" bson:"language,omitempty"`
+	// Indica que el usuario no quiere recibir notificaciones por correo
+	NotificationsOptOut bool `json:"notifications_opt_out,omitempty" bson:"notifications_opt_out,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,256 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tipos de notificacion
+const (
+	KindDueSoon   = "due_soon"
+	KindOverdue   = "overdue"
+	KindHoldReady = "hold_ready"
+)
+
+// Todos los tipos de notificacion
+var Kinds = []string{KindDueSoon, KindOverdue, KindHoldReady}
+
+// Estados de una entrada del registro de envios
+const (
+	StateSending = "sending"
+	StateSent    = "sent"
+)
+
+// Dias de anticipacion por defecto de los recordatorios de vencimiento
+const DefaultDueSoonDays = 3
+
+// Entrada del registro de envios. El id identifica la notificacion (tipo, referencia y,
+// en los prestamos, la fecha de vencimiento), de modo que cada una se envia una sola vez
+// y una renovacion genera un recordatorio nuevo.
+type Record struct {
+	ID        string    `json:"id" bson:"_id"`
+	Kind      string    `json:"kind" bson:"kind"`
+	UserId    string    `json:"user_id" bson:"user_id"`
+	Email     string    `json:"email" bson:"email"`
+	Language  string    `json:"language" bson:"language"`
+	Subject   string    `json:"subject" bson:"subject"`
+	State     string    `json:"state" bson:"state"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	SentAt    time.Time `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+// Resultado de una pasada del notificador
+type Report struct {
+	Sent int `json:"sent"`
+	// Notificaciones ya enviadas o de usuarios sin correo o que no las quieren
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// Busca prestamos por vencer, prestamos vencidos y reservas listas y avisa a sus usuarios
+// por correo en cada pasada que lanza el planificador de tareas. El registro de envios
+// evita repetir una notificacion entre pasadas y entre instancias; si el envio falla la
+// entrada se libera y se reintenta en la pasada siguiente.
+type Notifier struct {
+	Users *mongo.Collection
+	Books *mongo.Collection
+	Loans *mongo.Collection
+	Holds *mongo.Collection
+	// Registro de envios
+	Sent *mongo.Collection
+
+	Sender    Sender
+	Templates *Templates
+	// Dias de anticipacion de los recordatorios, DefaultDueSoonDays si es cero
+	DueSoonDays int
+	// Idioma de los usuarios sin idioma, el primero de Languages si esta vacio
+	Language string
+	Logger   *slog.Logger
+	// Reloj, time.Now si es nil
+	Now func() time.Time
+}
+
+// Ejecuta una pasada sobre los tres tipos de notificacion
+func (n *Notifier) Scan(ctx context.Context) (Report, error) {
+	s := &scan{Notifier: n, now: n.now(), users: map[string]*models.User{}, titles: map[string]string{}}
+
+	days := n.DueSoonDays
+	if days <= 0 {
+		days = DefaultDueSoonDays
+	}
+
+	errs := []error{
+		s.loans(ctx, KindDueSoon, bson.M{"is_returned": false, "due_date": bson.M{"$gte": s.now, "$lt": s.now.AddDate(0, 0, days)}}),
+		s.loans(ctx, KindOverdue, bson.M{"is_returned": false, "due_date": bson.M{"$lt": s.now}}),
+	}
+	if n.Holds != nil {
+		errs = append(errs, s.holds(ctx))
+	}
+	return s.report, errors.Join(errs...)
+}
+
+// Estado de una pasada, con los usuarios y titulos ya consultados
+type scan struct {
+	*Notifier
+	now    time.Time
+	report Report
+	users  map[string]*models.User
+	titles map[string]string
+}
+
+func (s *scan) loans(ctx context.Context, kind string, filter bson.M) error {
+	cur, err := s.Loans.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var loans []models.Loan
+	if err := cur.All(ctx, &loans); err != nil {
+		return err
+	}
+
+	for _, loan := range loans {
+		data := TemplateData{Title: s.title(ctx, loan.BookId, loan.Name)}
+		if kind == KindDueSoon {
+			// Dias redondeados hacia arriba: vencer en 20 horas se anuncia como mañana
+			data.Days = int((loan.DueDate.Sub(s.now) + 24*time.Hour - 1) / (24 * time.Hour))
+		}
+		ref := kind + "|" + loan.ID.Hex() + "|" + loan.DueDate.UTC().Format(time.DateOnly)
+		s.notify(ctx, ref, kind, loan.UserId, data, loan.DueDate)
+	}
+	return nil
+}
+
+func (s *scan) holds(ctx context.Context) error {
+	cur, err := s.Holds.Find(ctx, bson.M{"status": models.HoldReady})
+	if err != nil {
+		return err
+	}
+	var holds []models.Hold
+	if err := cur.All(ctx, &holds); err != nil {
+		return err
+	}
+
+	for _, hold := range holds {
+		data := TemplateData{Title: s.title(ctx, hold.BookId, "")}
+		s.notify(ctx, KindHoldReady+"|"+hold.ID.Hex(), KindHoldReady, hold.UserId, data, time.Time{})
+	}
+	return nil
+}
+
+// Envia una notificacion si no se envio antes. Los errores se registran y cuentan en el
+// reporte para no detener la pasada.
+func (s *scan) notify(ctx context.Context, id, kind, userId string, data TemplateData, due time.Time) {
+	user := s.user(ctx, userId)
+	if user == nil || user.NotificationsOptOut || strings.TrimSpace(user.Email) == "" {
+		s.report.Skipped++
+		return
+	}
+
+	lang := Language(user.Language, s.language())
+	data.Name = user.Name
+	if !due.IsZero() {
+		data.DueDate = due.Format(dateLayouts[lang])
+	}
+	subject, body, err := s.Templates.Render(lang, kind, data)
+	if err != nil {
+		s.log().ErrorContext(ctx, "notification not rendered", "kind", kind, "error", err)
+		s.report.Failed++
+		return
+	}
+
+	// Reserva la notificacion en el registro; si ya existe se envio o se esta enviando
+	record := Record{
+		ID:        id,
+		Kind:      kind,
+		UserId:    userId,
+		Email:     user.Email,
+		Language:  lang,
+		Subject:   subject,
+		State:     StateSending,
+		CreatedAt: s.now,
+	}
+	if _, err := s.Sent.InsertOne(ctx, record); mongo.IsDuplicateKeyError(err) {
+		s.report.Skipped++
+		return
+	} else if err != nil {
+		s.log().ErrorContext(ctx, "notification not reserved", "notification_id", id, "error", err)
+		s.report.Failed++
+		return
+	}
+
+	if err := s.Sender.Send(ctx, Message{To: user.Email, Subject: subject, Body: body}); err != nil {
+		s.log().WarnContext(ctx, "notification not sent", "notification_id", id, "user_id", userId, "error", err)
+		if _, err := s.Sent.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": id, "state": StateSending}); err != nil {
+			s.log().ErrorContext(ctx, "notification not released", "notification_id", id, "error", err)
+		}
+		s.report.Failed++
+		return
+	}
+
+	_, err = s.Sent.UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": id}, bson.M{"$set": bson.M{"state": StateSent, "sent_at": s.Notifier.now()}})
+	if err != nil {
+		s.log().ErrorContext(ctx, "notification sent but not recorded", "notification_id", id, "error", err)
+	}
+	s.report.Sent++
+}
+
+// Recupera el usuario de la pasada, nil si no existe
+func (s *scan) user(ctx context.Context, userId string) *models.User {
+	if u, ok := s.users[userId]; ok {
+		return u
+	}
+	var user *models.User
+	if id, err := primitive.ObjectIDFromHex(userId); err == nil {
+		var u models.User
+		if err := s.Users.FindOne(ctx, bson.M{"_id": id}).Decode(&u); err == nil {
+			user = &u
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			s.log().WarnContext(ctx, "notification user not loaded", "user_id", userId, "error", err)
+			return nil
+		}
+	}
+	s.users[userId] = user
+	return user
+}
+
+// Recupera el titulo del libro de la pasada, fallback si no existe
+func (s *scan) title(ctx context.Context, bookId, fallback string) string {
+	if t, ok := s.titles[bookId]; ok {
+		return t
+	}
+	title := fallback
+	if id, err := primitive.ObjectIDFromHex(bookId); err == nil && s.Books != nil {
+		var book models.Book
+		if err := s.Books.FindOne(ctx, bson.M{"_id": id}).Decode(&book); err == nil && book.Title != "" {
+			title = book.Title
+		}
+	}
+	s.titles[bookId] = title
+	return title
+}
+
+func (n *Notifier) language() string {
+	return Language(n.Language, Languages[0])
+}
+
+func (n *Notifier) now() time.Time {
+	if n.Now != nil {
+		return n.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (n *Notifier) log() *slog.Logger {
+	if n.Logger != nil {
+		return n.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,149 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Correo listo para enviar
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Envia correos
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Envia correos por SMTP. Usa STARTTLS cuando el servidor lo ofrece y autenticacion
+// PLAIN si hay usuario configurado.
+type SMTPSender struct {
+	// Direccion host:puerto del servidor
+	Addr     string
+	From     string
+	Username string
+	Password string
+	// Limite de la conexion completa, cero usa 30 segundos
+	Timeout time.Duration
+	// Configuracion TLS de STARTTLS, nil valida el certificado contra el host de Addr
+	TLSConfig *tls.Config
+	// Reloj de la cabecera Date, time.Now si es nil
+	Now func() time.Time
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("smtp: direccion invalida %q: %w", s.Addr, err)
+	}
+	data, err := s.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := s.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: host}
+		}
+		if err := c.StartTLS(cfg); err != nil {
+			return fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("smtp: auth: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(s.From)
+	to, _ := mail.ParseAddress(msg.To)
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("smtp: mail from: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("smtp: rcpt to: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("smtp: data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp: data: %w", err)
+	}
+	return c.Quit()
+}
+
+// Arma el mensaje MIME: texto plano UTF-8 en quoted-printable y asunto codificado
+func (s *SMTPSender) compose(msg Message) ([]byte, error) {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: remitente invalido %q: %w", s.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: destinatario invalido %q: %w", msg.To, err)
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	qp.Close()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Idiomas con plantillas, el primero es el idioma por defecto
+var Languages = []string{"es", "en"}
+
+// Formato de las fechas en el cuerpo de los correos por idioma
+var dateLayouts = map[string]string{
+	"es": "02/01/2006",
+	"en": "January 2, 2006",
+}
+
+// Datos disponibles en las plantillas
+type TemplateData struct {
+	Name    string
+	Title   string
+	DueDate string
+	// Dias hasta el vencimiento en los recordatorios
+	Days int
+}
+
+// Plantillas por idioma y tipo de notificacion, cada una define "subject" y "body"
+type Templates struct {
+	sets map[string]*template.Template
+}
+
+// Carga las plantillas embebidas. Falla si falta alguna combinacion de idioma y tipo.
+func LoadTemplates() (*Templates, error) {
+	t := &Templates{sets: map[string]*template.Template{}}
+	for _, lang := range Languages {
+		for _, kind := range Kinds {
+			name := lang + "/" + kind + ".tmpl"
+			set, err := template.New(name).Option("missingkey=error").ParseFS(templateFS, "templates/"+name)
+			if err != nil {
+				return nil, fmt.Errorf("notifications: plantilla %s: %w", name, err)
+			}
+			if set.Lookup("subject") == nil || set.Lookup("body") == nil {
+				return nil, fmt.Errorf("notifications: la plantilla %s debe definir subject y body", name)
+			}
+			t.sets[lang+"/"+kind] = set
+		}
+	}
+	return t, nil
+}
+
+// Genera el asunto y el cuerpo de una notificacion en el idioma indicado
+func (t *Templates) Render(lang, kind string, data TemplateData) (subject, body string, err error) {
+	set, ok := t.sets[lang+"/"+kind]
+	if !ok {
+		return "", "", fmt.Errorf("notifications: sin plantilla para %s/%s", lang, kind)
+	}
+
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", err
+	}
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := set.ExecuteTemplate(&buf, "body", data); err != nil {
+		return "", "", err
+	}
+	return subject, strings.TrimSpace(buf.String()) + "\n", nil
+}
+
+// Normaliza el idioma de un usuario a uno con plantillas: "en-US" usa "en" y los
+// idiomas desconocidos usan fallback
+func Language(lang, fallback string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	for _, l := range Languages {
+		if l == lang {
+			return l
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,165 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/swaggest/swgui"
+	"github.com/swaggest/swgui/v5emb"
+)
+
+// Version de la especificacion OpenAPI del documento
+const Version = "3.0.3"
+
+// Documento OpenAPI 3 de la API
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operaciones de una ruta indexadas por metodo HTTP en minusculas
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+}
+
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Headers     map[string]*Header   `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	ReadOnly    bool               `json:"readOnly,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	AllOf       []*Schema          `json:"allOf,omitempty"`
+	// Esquema de los valores de un objeto usado como mapa
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	Responses       map[string]*Response       `json:"responses,omitempty"`
+	Parameters      map[string]*Parameter      `json:"parameters,omitempty"`
+	Headers         map[string]*Header         `json:"headers,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Convierte una ruta de Echo (/books/:id) en una plantilla OpenAPI (/books/{id})
+func PathTemplate(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Retorna la operacion documentada para el metodo y la ruta de Echo, o nil
+func (d *Document) Operation(method, path string) *Operation {
+	item, ok := d.Paths[PathTemplate(path)]
+	if !ok {
+		return nil
+	}
+	return item[strings.ToLower(method)]
+}
+
+// Agrega una operacion al documento
+func (d *Document) add(method, path string, op *Operation) {
+	path = PathTemplate(path)
+	if d.Paths[path] == nil {
+		d.Paths[path] = PathItem{}
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+// Handler que sirve el documento en JSON. Se serializa una sola vez.
+func Handler(doc *Document) echo.HandlerFunc {
+	body, err := json.Marshal(doc)
+	return func(c echo.Context) error {
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	}
+}
+
+// Handler de Swagger UI servido desde los recursos embebidos en el binario.
+// basePath es la ruta bajo la que se registra la interfaz, con barra final.
+func UI(title, specURL, basePath string) echo.HandlerFunc {
+	return echo.WrapHandler(v5emb.NewHandlerWithConfig(swgui.Config{
+		Title:       title,
+		SwaggerJSON: specURL,
+		BasePath:    basePath,
+		ShowTopBar:  true,
+	}))
+}
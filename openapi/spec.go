@@ -0,0 +1,958 @@
+package openapi
+
+import (
+	"strconv"
+	"strings"
+
+	"backend/auth"
+)
+
+// Prefijos documentados de cada version de la API
+const (
+	v1Prefix = "/api/v1"
+	v2Prefix = "/api/v2"
+)
+
+// Operacion documentada sobre una ruta relativa al prefijo de su version
+type route struct {
+	method string
+	path   string
+	op     *Operation
+}
+
+// Construye el documento completo: rutas de infraestructura, v1, v2 y los alias
+// sin version de v1, que se marcan como obsoletos
+func Spec() *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       "Library API",
+			Description: "API de inventario, usuarios y prestamos de la biblioteca. Todas las respuestas JSON usan el sobre {status, message, data}.",
+			Version:     "1.0.0",
+		},
+		Tags: []Tag{
+			{Name: "infra", Description: "Salud, metricas y documentacion"},
+			{Name: "v1", Description: "Version 1 de la API"},
+			{Name: "v2", Description: "Version 2 de la API, con los prestamos como recurso REST"},
+			{Name: "legacy", Description: "Alias sin version de v1, obsoletos"},
+		},
+		Paths:      map[string]PathItem{},
+		Components: components(),
+	}
+
+	for _, r := range infraRoutes() {
+		r.op.Tags = []string{"infra"}
+		doc.add(r.method, r.path, r.op)
+	}
+	for _, r := range v1Routes() {
+		doc.add(r.method, v1Prefix+r.path, versioned(r.op, "v1"))
+		doc.add(r.method, r.path, deprecated(r.op))
+	}
+	for _, r := range v2Routes() {
+		doc.add(r.method, v2Prefix+r.path, versioned(r.op, "v2"))
+	}
+
+	return doc
+}
+
+// Copia la operacion para una version, con su tag, un operationId unico y la
+// respuesta del limite de tasa que aplica a todas las rutas versionadas
+func versioned(op *Operation, version string) *Operation {
+	o := *op
+	o.OperationID = version + strings.ToUpper(op.OperationID[:1]) + op.OperationID[1:]
+	o.Tags = []string{version}
+	o.Responses = merge(merge(map[string]*Response{}, op.Responses), errorResponses(429))
+	return &o
+}
+
+// Copia la operacion de v1 como alias obsoleto, con los encabezados de retiro
+// en las respuestas propias de la operacion
+func deprecated(op *Operation) *Operation {
+	o := versioned(op, "legacy")
+	o.Deprecated = true
+	o.Description = strings.TrimSpace(o.Description + " Alias obsoleto de la ruta equivalente bajo " + v1Prefix + ".")
+	original := o.Responses
+	o.Responses = make(map[string]*Response, len(original))
+	for status, res := range original {
+		if res.Ref == "" {
+			r := *res
+			r.Headers = map[string]*Header{
+				"Deprecation": {Ref: "#/components/headers/Deprecation"},
+				"Sunset":      {Ref: "#/components/headers/Sunset"},
+				"Link":        {Ref: "#/components/headers/Link"},
+			}
+			res = &r
+		}
+		o.Responses[status] = res
+	}
+	return o
+}
+
+func infraRoutes() []route {
+	return []route{
+		{"GET", "/healthz", &Operation{
+			OperationID: "healthz",
+			Summary:     "Liveness: el proceso esta vivo",
+			Responses:   responses(200, "Servicio activo", nullData()),
+		}},
+		{"GET", "/readyz", &Operation{
+			OperationID: "readyz",
+			Summary:     "Readiness: valida MongoDB y las colecciones",
+			Responses: merge(
+				responses(200, "Servicio listo", checks()),
+				map[string]*Response{"503": {
+					Description: "Alguna dependencia no esta disponible o el servicio se esta apagando",
+					Content:     jsonContent(errorWith(checks())),
+				}},
+			),
+		}},
+		{"GET", "/metrics", &Operation{
+			OperationID: "metrics",
+			Summary:     "Metricas en formato de exposicion de Prometheus",
+			Responses: map[string]*Response{"200": {
+				Description: "Metricas",
+				Content:     map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string"}}},
+			}},
+		}},
+		{"GET", "/openapi.json", &Operation{
+			OperationID: "openapi",
+			Summary:     "Este documento OpenAPI",
+			Responses: map[string]*Response{"200": {
+				Description: "Documento OpenAPI 3",
+				Content:     jsonContent(&Schema{Type: "object"}),
+			}},
+		}},
+		{"GET", "/docs", &Operation{
+			OperationID: "docs",
+			Summary:     "Swagger UI sobre este documento",
+			Responses: map[string]*Response{"200": {
+				Description: "Pagina HTML de Swagger UI",
+				Content:     map[string]MediaType{"text/html": {Schema: &Schema{Type: "string"}}},
+			}},
+		}},
+	}
+}
+
+func v1Routes() []route {
+	routes := append(scoped("books", bookRoutes()...), scoped("users", userRoutes()...)...)
+	routes = append(routes, scoped("loans", loanRoutes()...)...)
+	routes = append(routes, scoped("loans", route{"PUT", "/return-loan/:id", &Operation{
+		OperationID: "returnLoan",
+		Summary:     "Devuelve un prestamo",
+		Description: "Idempotente: devolver un prestamo ya devuelto no emite eventos ni auditoria.",
+		Parameters:  []*Parameter{idParam()},
+		Responses:   responses(201, "Prestamo devuelto", nullData(), 400, 404, 500, 504),
+	}})...)
+	routes = append(routes, scoped("events", streamRoutes()...)...)
+	routes = append(routes, meRoutes()...)
+	return append(routes, adminRoutes()...)
+}
+
+func v2Routes() []route {
+	routes := append(scoped("books", bookRoutes()...), scoped("users", userRoutes()...)...)
+	routes = append(routes, scoped("loans", loanRoutes()...)...)
+	routes = append(routes, scoped("loans", route{"POST", "/loans/:id/return", &Operation{
+		OperationID: "returnLoan",
+		Summary:     "Devuelve un prestamo",
+		Description: "Idempotente: devolver un prestamo ya devuelto no emite eventos ni auditoria.",
+		Parameters:  []*Parameter{idParam()},
+		Responses:   responses(201, "Prestamo devuelto", nullData(), 400, 404, 500, 504),
+	}})...)
+	routes = append(routes, scoped("events", streamRoutes()...)...)
+	routes = append(routes, meRoutes()...)
+	return append(routes, adminRoutes()...)
+}
+
+// Marca las rutas de un recurso con el alcance que exigen: <recurso>:read en las
+// consultas y <recurso>:write en los cambios. Sin AUTH_REQUIRED el alcance no restringe las
+// consultas y aceptan peticiones anonimas, por eso incluyen el requisito vacio; los cambios
+// siempre exigen credenciales mientras la auditoria este activa.
+func scoped(resource string, routes ...route) []route {
+	for _, r := range routes {
+		scope := resource + ":write"
+		if r.method == "GET" {
+			scope = resource + ":read"
+		}
+		r.op.Security = []map[string][]string{{"apiKey": {scope}}, {"userToken": {scope}}, {"adminToken": {}}}
+		if r.method == "GET" {
+			r.op.Security = append(r.op.Security, map[string][]string{})
+		}
+		r.op.Responses = merge(r.op.Responses, errorResponses(401, 403))
+	}
+	return routes
+}
+
+func bookRoutes() []route {
+	return []route{
+		{"GET", "/books", &Operation{
+			OperationID: "getBooks",
+			Summary:     "Lista los libros",
+			Responses:   responses(302, "Lista de libros", arrayOf(ref("Book")), 404, 500, 504),
+		}},
+		{"GET", "/books/:id", &Operation{
+			OperationID: "getBookById",
+			Summary:     "Recupera un libro",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(302, "Libro encontrado", ref("Book"), 400, 404, 500, 504),
+		}},
+		{"GET", "/books/export", &Operation{
+			OperationID: "exportBooksCsv",
+			Summary:     "Exporta los libros en CSV",
+			Description: "Columnas id,title,author,isbn,availability. La respuesta se transmite por partes.",
+			Responses: merge(
+				map[string]*Response{"200": {
+					Description: "Archivo CSV",
+					Content:     map[string]MediaType{"text/csv": {Schema: &Schema{Type: "string"}}},
+				}},
+				errorResponses(404, 500, 504),
+			),
+		}},
+		{"POST", "/books/import", &Operation{
+			OperationID: "importBooksCsv",
+			Summary:     "Importa libros desde CSV",
+			Description: "Crea o actualiza por isbn. Las filas invalidas se rechazan sin detener la importacion.",
+			RequestBody: &RequestBody{
+				Required: true,
+				Content: map[string]MediaType{
+					"text/csv": {Schema: &Schema{Type: "string"}},
+					"multipart/form-data": {Schema: &Schema{
+						Type:       "object",
+						Required:   []string{"file"},
+						Properties: map[string]*Schema{"file": {Type: "string", Format: "binary"}},
+					}},
+				},
+			},
+			Responses: responses(200, "Reporte de la importacion", ref("ImportReport"), 400, 404, 500, 504),
+		}},
+		{"POST", "/books/import/marc", &Operation{
+			OperationID: "importBooksMarc",
+			Summary:     "Importa libros desde MARC21 o MARCXML",
+			Description: "Los isbn ya existentes, en la base o en el mismo archivo, se reportan como duplicados.",
+			Parameters: []*Parameter{
+				query("format", "Formato del archivo, se detecta del contenido si se omite", &Schema{Type: "string", Enum: []string{"marc21", "marcxml"}}),
+				query("dry_run", "Solo reporta lo que ocurriria", &Schema{Type: "boolean"}),
+				query("availability", "Disponibilidad inicial de los libros creados, 1 por defecto", &Schema{Type: "integer"}),
+			},
+			RequestBody: &RequestBody{
+				Required: true,
+				Content: map[string]MediaType{
+					"application/marc":     {Schema: &Schema{Type: "string", Format: "binary"}},
+					"application/marc+xml": {Schema: &Schema{Type: "string"}},
+				},
+			},
+			Responses: responses(200, "Reporte de la importacion", ref("ImportReport"), 400, 404, 500, 504),
+		}},
+		{"POST", "/books/batch", &Operation{
+			OperationID: "batchBooks",
+			Summary:     "Crea, actualiza y elimina libros en lote",
+			RequestBody: jsonBody(ref("BatchRequest")),
+			Responses:   responses(200, "Resultado por operacion", ref("BatchReport"), 400, 404, 500, 504),
+		}},
+		{"POST", "/books", &Operation{
+			OperationID: "createBook",
+			Summary:     "Crea un libro",
+			Parameters:  []*Parameter{idempotencyKey()},
+			RequestBody: jsonBody(ref("Book")),
+			Responses:   responses(201, "Libro creado", ref("Book"), 400, 409, 413, 422, 500, 504),
+		}},
+		{"PUT", "/books/:id", &Operation{
+			OperationID: "updateBook",
+			Summary:     "Actualiza un libro",
+			Parameters:  []*Parameter{idParam()},
+			RequestBody: jsonBody(ref("Book")),
+			Responses:   responses(201, "Libro actualizado", ref("Book"), 400, 404, 409, 500, 504),
+		}},
+		{"DELETE", "/books/:id", &Operation{
+			OperationID: "deleteBook",
+			Summary:     "Elimina un libro",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(200, "Libro eliminado", nullData(), 400, 404, 500, 504),
+		}},
+	}
+}
+
+func userRoutes() []route {
+	return []route{
+		{"GET", "/users", &Operation{
+			OperationID: "getUsers",
+			Summary:     "Lista los usuarios",
+			Responses:   responses(302, "Lista de usuarios", arrayOf(ref("User")), 404, 500, 504),
+		}},
+		{"GET", "/users/:id", &Operation{
+			OperationID: "getUserById",
+			Summary:     "Recupera un usuario",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(302, "Usuario encontrado", ref("User"), 400, 404, 500, 504),
+		}},
+		{"POST", "/users", &Operation{
+			OperationID: "createUser",
+			Summary:     "Crea un usuario",
+			Parameters:  []*Parameter{idempotencyKey()},
+			RequestBody: jsonBody(ref("User")),
+			Responses:   responses(201, "Usuario creado", ref("User"), 400, 404, 409, 413, 422, 500, 504),
+		}},
+		{"POST", "/users/batch", &Operation{
+			OperationID: "batchUsers",
+			Summary:     "Crea, actualiza y elimina usuarios en lote",
+			RequestBody: jsonBody(ref("BatchRequest")),
+			Responses:   responses(200, "Resultado por operacion", ref("BatchReport"), 400, 404, 500, 504),
+		}},
+		{"DELETE", "/users/:id", &Operation{
+			OperationID: "deleteUser",
+			Summary:     "Elimina un usuario",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(200, "Usuario eliminado", nullData(), 400, 404, 500, 504),
+		}},
+	}
+}
+
+// Rutas de prestamos comunes a todas las versiones
+func loanRoutes() []route {
+	return []route{
+		{"GET", "/loans", &Operation{
+			OperationID: "getLoans",
+			Summary:     "Lista los prestamos",
+			Responses:   responses(302, "Lista de prestamos", arrayOf(ref("Loan")), 404, 500, 504),
+		}},
+		{"POST", "/loans", &Operation{
+			OperationID: "createLoan",
+			Summary:     "Crea un prestamo",
+			Description: "La fecha de devolucion se calcula con la politica de prestamos. Responde 404 si el libro no existe y 409 si el usuario alcanzo el maximo de prestamos activos.",
+			Parameters:  []*Parameter{idempotencyKey()},
+			RequestBody: jsonBody(ref("Loan")),
+			Responses:   responses(201, "Prestamo creado", ref("Loan"), 400, 404, 409, 413, 422, 500, 504),
+		}},
+	}
+}
+
+func streamRoutes() []route {
+	return []route{
+		{"GET", "/events/stream", &Operation{
+			OperationID: "streamEvents",
+			Summary:     "Flujo Server-Sent Events de disponibilidad y prestamos",
+			Description: "Cada evento lleva como id su numero de secuencia en el outbox; al reconectar con Last-Event-ID se reenvian los eventos posteriores.",
+			Parameters: []*Parameter{
+				{Name: "Last-Event-ID", In: "header", Description: "Secuencia del ultimo evento recibido", Schema: &Schema{Type: "string"}},
+				query("last_event_id", "Alternativa al encabezado Last-Event-ID", &Schema{Type: "string"}),
+				query("book_id", "Solo eventos de este libro", &Schema{Type: "string"}),
+			},
+			Responses: merge(
+				map[string]*Response{"200": {
+					Description: "Flujo de eventos",
+					Content:     map[string]MediaType{"text/event-stream": {Schema: &Schema{Type: "string"}}},
+				}},
+				errorResponses(400, 404),
+			),
+		}},
+	}
+}
+
+// Rutas del usuario autenticado, que exigen un token de usuario
+func meRoutes() []route {
+	routes := []route{
+		{"GET", "/me", &Operation{
+			OperationID: "getMe",
+			Summary:     "Recupera el perfil del usuario autenticado",
+			Responses:   responses(302, "Usuario encontrado", ref("User"), 400, 404, 500, 504),
+		}},
+		{"GET", "/me/loans", &Operation{
+			OperationID: "getMyLoans",
+			Summary:     "Lista los prestamos abiertos del usuario autenticado",
+			Responses:   responses(200, "Prestamos actuales", arrayOf(ref("Loan")), 404, 500, 504),
+		}},
+		{"POST", "/me/loans/:id/renew", &Operation{
+			OperationID: "renewMyLoan",
+			Summary:     "Renueva un prestamo propio",
+			Description: "Extiende la fecha de devolucion por la duracion de un prestamo. Responde 409 si el prestamo esta vencido o devuelto, alcanzo el maximo de renovaciones o el libro tiene reservas de otros usuarios.",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(201, "Prestamo renovado", ref("Loan"), 400, 404, 409, 500, 504),
+		}},
+		{"GET", "/me/history", &Operation{
+			OperationID: "getMyHistory",
+			Summary:     "Lista los prestamos devueltos del usuario autenticado",
+			Responses:   responses(200, "Historial de prestamos", arrayOf(ref("Loan")), 404, 500, 504),
+		}},
+		{"GET", "/me/fines", &Operation{
+			OperationID: "getMyFines",
+			Summary:     "Calcula las multas del usuario autenticado",
+			Description: "Los prestamos abiertos acumulan multa hasta hoy y los devueltos hasta su devolucion.",
+			Responses:   responses(200, "Multas", ref("Fines"), 404, 500, 504),
+		}},
+		{"GET", "/me/holds", &Operation{
+			OperationID: "getMyHolds",
+			Summary:     "Lista las reservas vigentes del usuario autenticado",
+			Responses:   responses(200, "Reservas", arrayOf(ref("Hold")), 404, 500, 504),
+		}},
+		{"POST", "/me/holds", &Operation{
+			OperationID: "placeMyHold",
+			Summary:     "Reserva un libro",
+			Description: "Responde 409 si el usuario ya tiene una reserva vigente del libro o si hay ejemplares disponibles.",
+			Parameters:  []*Parameter{idempotencyKey()},
+			RequestBody: jsonBody(ref("Hold")),
+			Responses:   responses(201, "Reserva creada", ref("Hold"), 400, 404, 409, 413, 422, 500, 504),
+		}},
+		{"DELETE", "/me/holds/:id", &Operation{
+			OperationID: "cancelMyHold",
+			Summary:     "Cancela una reserva propia",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(200, "Reserva cancelada", ref("Hold"), 400, 404, 500, 504),
+		}},
+		{"PUT", "/me/notifications", &Operation{
+			OperationID: "updateMyNotifications",
+			Summary:     "Actualiza las preferencias de notificacion del usuario autenticado",
+			Description: "Los recordatorios de vencimiento, avisos de atraso y reservas listas se envian por correo salvo que el usuario los desactive.",
+			RequestBody: jsonBody(ref("NotificationSettings")),
+			Responses:   responses(201, "Usuario actualizado", ref("User"), 400, 404, 500, 504),
+		}},
+	}
+
+	for _, r := range routes {
+		r.op.Security = []map[string][]string{{"userToken": {}}}
+		r.op.Responses = merge(r.op.Responses, errorResponses(401, 403))
+	}
+	return routes
+}
+
+// Rutas que exigen el token de administrador
+func adminRoutes() []route {
+	routes := []route{
+		{"GET", "/audit", &Operation{
+			OperationID: "getAudit",
+			Summary:     "Consulta la auditoria de cambios",
+			Parameters: []*Parameter{
+				query("entity", "Entidad: book, user, loan, hold, webhook o api_key", &Schema{Type: "string"}),
+				query("entity_id", "Id de la entidad", &Schema{Type: "string"}),
+				query("action", "Accion registrada", &Schema{Type: "string", Enum: []string{"create", "update", "delete", "return", "rotate", "revoke", "renew", "cancel", "run"}}),
+				query("actor", "Actor que realizo el cambio", &Schema{Type: "string"}),
+				query("request_id", "Request id de la peticion", &Schema{Type: "string"}),
+				query("from", "Desde, en RFC3339", &Schema{Type: "string", Format: "date-time"}),
+				query("to", "Hasta, en RFC3339", &Schema{Type: "string", Format: "date-time"}),
+				query("limit", "Maximo de entradas, 100 por defecto y hasta 1000", &Schema{Type: "integer"}),
+			},
+			Responses: responses(200, "Entradas de auditoria", arrayOf(ref("AuditEntry")), 400, 404, 500, 504),
+		}},
+		{"POST", "/webhooks", &Operation{
+			OperationID: "createWebhook",
+			Summary:     "Crea una suscripcion de webhook",
+			Description: "Si no se envia el secreto se genera uno, que solo se retorna en esta respuesta.",
+			RequestBody: jsonBody(ref("Webhook")),
+			Responses:   responses(201, "Webhook creado", ref("Webhook"), 400, 404, 500, 504),
+		}},
+		{"GET", "/webhooks", &Operation{
+			OperationID: "getWebhooks",
+			Summary:     "Lista las suscripciones de webhooks",
+			Responses:   responses(200, "Lista de webhooks", arrayOf(ref("Webhook")), 404, 500, 504),
+		}},
+		{"DELETE", "/webhooks/:id", &Operation{
+			OperationID: "deleteWebhook",
+			Summary:     "Elimina una suscripcion de webhook",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(200, "Webhook eliminado", nullData(), 400, 404, 500, 504),
+		}},
+		{"GET", "/webhooks/:id/deliveries", &Operation{
+			OperationID: "getWebhookDeliveries",
+			Summary:     "Registro de entregas de un webhook",
+			Parameters: []*Parameter{
+				idParam(),
+				query("status", "Estado de la entrega", &Schema{Type: "string", Enum: []string{"pending", "sending", "delivered", "failed"}}),
+				query("limit", "Maximo de entregas, 50 por defecto y hasta 500", &Schema{Type: "integer"}),
+			},
+			Responses: responses(200, "Entregas", arrayOf(ref("WebhookDelivery")), 400, 404, 500, 504),
+		}},
+		{"POST", "/webhooks/:id/deliveries/:delivery_id/redeliver", &Operation{
+			OperationID: "redeliverWebhook",
+			Summary:     "Reenvia una entrega de webhook",
+			Parameters: []*Parameter{
+				idParam(),
+				{Name: "delivery_id", In: "path", Required: true, Description: "Id de la entrega", Schema: &Schema{Type: "string"}},
+			},
+			Description: "Responde 409 si un worker esta enviando la entrega.",
+			Responses:   responses(200, "Entrega reenviada", ref("WebhookDelivery"), 400, 404, 409, 500, 504),
+		}},
+		{"POST", "/api-keys", &Operation{
+			OperationID: "createAPIKey",
+			Summary:     "Emite una API key",
+			Description: "La key en claro solo se retorna en esta respuesta; se guarda su hash.",
+			RequestBody: jsonBody(ref("APIKeyRequest")),
+			Responses:   responses(201, "API key creada", ref("APIKey"), 400, 404, 500, 504),
+		}},
+		{"GET", "/api-keys", &Operation{
+			OperationID: "getAPIKeys",
+			Summary:     "Lista las API keys, incluidas las revocadas",
+			Responses:   responses(200, "Lista de API keys", arrayOf(ref("APIKey")), 404, 500, 504),
+		}},
+		{"POST", "/api-keys/:id/rotate", &Operation{
+			OperationID: "rotateAPIKey",
+			Summary:     "Rota una API key",
+			Description: "Emite una key nueva con el mismo id y alcances; la anterior deja de valer de inmediato.",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(201, "API key rotada", ref("APIKey"), 400, 404, 500, 504),
+		}},
+		{"DELETE", "/api-keys/:id", &Operation{
+			OperationID: "revokeAPIKey",
+			Summary:     "Revoca una API key",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(200, "API key revocada", ref("APIKey"), 400, 404, 500, 504),
+		}},
+		{"POST", "/users/:id/tokens", &Operation{
+			OperationID: "createUserToken",
+			Summary:     "Emite un token de usuario",
+			Description: "Token firmado con la vigencia de AUTH_TOKEN_TTL. Responde 404 si los tokens estan deshabilitados.",
+			Parameters:  []*Parameter{idParam()},
+			Responses:   responses(201, "Token emitido", ref("UserToken"), 400, 404, 500, 504),
+		}},
+		{"GET", "/jobs", &Operation{
+			OperationID: "getJobs",
+			Summary:     "Lista las tareas periodicas",
+			Description: "Incluye la proxima ejecucion, la ultima ejecucion registrada y si esta instancia es la lider que ejecuta las tareas programadas. Responde 404 si el planificador esta deshabilitado.",
+			Responses:   responses(200, "Lista de tareas", ref("Jobs"), 404, 500, 504),
+		}},
+		{"GET", "/jobs/:name", &Operation{
+			OperationID: "getJob",
+			Summary:     "Recupera una tarea periodica con su ultima ejecucion",
+			Parameters:  []*Parameter{jobName()},
+			Responses:   responses(200, "Tarea encontrada", ref("Job"), 404, 500, 504),
+		}},
+		{"GET", "/jobs/:name/runs", &Operation{
+			OperationID: "getJobRuns",
+			Summary:     "Historial de ejecuciones de una tarea",
+			Parameters: []*Parameter{
+				jobName(),
+				query("limit", "Maximo de ejecuciones, 20 por defecto y hasta 200", &Schema{Type: "integer"}),
+			},
+			Responses: responses(200, "Ejecuciones", arrayOf(ref("JobRun")), 400, 404, 500, 504),
+		}},
+		{"POST", "/jobs/:name/run", &Operation{
+			OperationID: "runJob",
+			Summary:     "Lanza una tarea manualmente",
+			Description: "La tarea corre en segundo plano; su resultado se consulta en el historial. Responde 409 si ya se esta ejecutando en alguna instancia.",
+			Parameters:  []*Parameter{jobName()},
+			Responses:   responses(202, "Tarea iniciada", ref("JobRun"), 404, 409, 500, 504),
+		}},
+	}
+
+	for _, r := range routes {
+		r.op.Security = []map[string][]string{{"adminToken": {}}}
+		r.op.Responses = merge(r.op.Responses, errorResponses(401, 403))
+	}
+	return routes
+}
+
+// Nombres de las respuestas de error compartidas por estado HTTP
+var errorNames = map[int]string{
+	400: "BadRequest",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "NotFound",
+	409: "Conflict",
+	413: "PayloadTooLarge",
+	422: "UnprocessableEntity",
+	429: "TooManyRequests",
+	500: "InternalError",
+	504: "GatewayTimeout",
+}
+
+func components() Components {
+	errorResponse := func(description string) *Response {
+		return &Response{Description: description, Content: jsonContent(ref("Error"))}
+	}
+	str := func(description string) *Schema { return &Schema{Type: "string", Description: description} }
+	integer := func(description string) *Schema { return &Schema{Type: "integer", Description: description} }
+	id := &Schema{Type: "string", Description: "ObjectID en hexadecimal", ReadOnly: true}
+	timestamp := &Schema{Type: "string", Format: "date-time"}
+
+	return Components{
+		Schemas: map[string]*Schema{
+			"Envelope": {
+				Type:        "object",
+				Description: "Sobre comun de las respuestas JSON",
+				Required:    []string{"status", "message", "data"},
+				Properties: map[string]*Schema{
+					"status":  integer("Estado HTTP de la respuesta"),
+					"message": str("Mensaje legible"),
+					"data":    {Nullable: true, Description: "Contenido de la respuesta"},
+				},
+			},
+			"Error": {
+				Type:        "object",
+				Description: "Sobre de las respuestas de error, data siempre es null",
+				Required:    []string{"status", "message", "data"},
+				Properties: map[string]*Schema{
+					"status":     integer("Estado HTTP de la respuesta"),
+					"message":    str("Descripcion del error"),
+					"data":       {Nullable: true},
+					"request_id": str("Id de la peticion para rastrear el error en los logs"),
+				},
+			},
+			"Book": {
+				Type:     "object",
+				Required: []string{"title", "author", "isbn", "availability"},
+				Properties: map[string]*Schema{
+					"id":           id,
+					"title":        str("Titulo"),
+					"author":       str("Autor"),
+					"isbn":         str("ISBN, se normaliza sin guiones ni espacios"),
+					"availability": integer("Ejemplares disponibles, mayor que cero"),
+					"copies":       integer("Ejemplares totales"),
+				},
+			},
+			"User": {
+				Type:     "object",
+				Required: []string{"name", "email"},
+				Properties: map[string]*Schema{
+					"id":                    id,
+					"name":                  str("Nombre"),
+					"email":                 str("Correo electronico"),
+					"language":              {Type: "string", Enum: []string{"es", "en"}, Description: "Idioma de las notificaciones"},
+					"notifications_opt_out": {Type: "boolean", Description: "No recibe notificaciones por correo"},
+				},
+			},
+			"NotificationSettings": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"opt_out":  {Type: "boolean", Description: "Deja de recibir notificaciones por correo"},
+					"language": {Type: "string", Enum: []string{"es", "en", ""}, Description: "Idioma de las notificaciones, vacio usa el de la biblioteca"},
+				},
+			},
+			"Loan": {
+				Type:     "object",
+				Required: []string{"name", "description", "book_id"},
+				Properties: map[string]*Schema{
+					"id":          id,
+					"name":        str("Nombre"),
+					"description": str("Descripcion"),
+					"user_id":     str("Id del usuario"),
+					"book_id":     str("Id del libro"),
+					"is_returned": {Type: "boolean", ReadOnly: true},
+					"created_at":  {Type: "string", Format: "date-time", ReadOnly: true},
+					"due_date":    {Type: "string", Format: "date-time", ReadOnly: true},
+					"returned_at": {Type: "string", Format: "date-time", ReadOnly: true},
+					"renewals":    {Type: "integer", Description: "Renovaciones realizadas", ReadOnly: true},
+					"overdue_at":  {Type: "string", Format: "date-time", Description: "Fecha en que se marco vencido", ReadOnly: true},
+				},
+			},
+			"Hold": {
+				Type:     "object",
+				Required: []string{"book_id"},
+				Properties: map[string]*Schema{
+					"id":          id,
+					"user_id":     {Type: "string", Description: "Id del usuario", ReadOnly: true},
+					"book_id":     str("Id del libro"),
+					"status":      {Type: "string", Enum: []string{"waiting", "ready", "canceled", "fulfilled", "expired"}, ReadOnly: true},
+					"created_at":  {Type: "string", Format: "date-time", ReadOnly: true},
+					"ready_at":    {Type: "string", Format: "date-time", ReadOnly: true},
+					"canceled_at": {Type: "string", Format: "date-time", ReadOnly: true},
+					"expired_at":  {Type: "string", Format: "date-time", ReadOnly: true},
+				},
+			},
+			"Fines": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"fine_per_day_cents": integer("Multa por dia de atraso en centavos"),
+					"total_cents":        integer("Total de las multas en centavos"),
+					"loans": arrayOf(&Schema{
+						Type: "object",
+						Properties: map[string]*Schema{
+							"loan_id":      str("Id del prestamo"),
+							"book_id":      str("Id del libro"),
+							"due_date":     timestamp,
+							"returned_at":  timestamp,
+							"days_overdue": integer("Dias completos de atraso"),
+							"amount_cents": integer("Multa del prestamo en centavos, con el tope por prestamo"),
+						},
+					}),
+				},
+			},
+			"AuditEntry": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"id":         id,
+					"actor":      str("admin:<id>, apikey:<id>, user:<id> o anonymous"),
+					"action":     {Type: "string", Enum: []string{"create", "update", "delete", "return", "rotate", "revoke", "renew", "cancel", "run"}},
+					"entity":     str("Entidad modificada"),
+					"entity_id":  str("Id de la entidad"),
+					"before":     {Type: "object", Nullable: true},
+					"after":      {Type: "object", Nullable: true},
+					"request_id": str("Id de la peticion"),
+					"timestamp":  timestamp,
+				},
+			},
+			"Webhook": {
+				Type:     "object",
+				Required: []string{"url"},
+				Properties: map[string]*Schema{
+					"id":         id,
+					"url":        {Type: "string", Format: "uri"},
+					"events":     {Type: "array", Items: str("Tipo de evento o * para todos")},
+					"secret":     str("Secreto de la firma HMAC, solo se retorna al crear"),
+					"active":     {Type: "boolean"},
+					"created_at": timestamp,
+				},
+			},
+			"WebhookDelivery": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"id":              id,
+					"subscription_id": str("Id de la suscripcion"),
+					"event_id":        str("Id del evento"),
+					"event_type":      str("Tipo del evento"),
+					"body":            str("Cuerpo enviado"),
+					"status":          {Type: "string", Enum: []string{"pending", "sending", "delivered", "failed"}},
+					"attempts":        integer("Intentos realizados"),
+					"next_attempt_at": timestamp,
+					"response_status": integer("Estado HTTP de la ultima respuesta"),
+					"last_error":      str("Ultimo error"),
+					"created_at":      timestamp,
+					"delivered_at":    timestamp,
+					"locked_until":    {Type: "string", Format: "date-time", Description: "Vencimiento del reclamo del worker que la envia"},
+					"owner":           str("Instancia que la esta enviando"),
+				},
+			},
+			"ImportReport": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"created":    integer("Libros creados"),
+					"updated":    integer("Libros actualizados"),
+					"rejected":   integer("Filas rechazadas"),
+					"duplicates": integer("Isbn duplicados"),
+					"dry_run":    {Type: "boolean"},
+					"rows": arrayOf(&Schema{
+						Type: "object",
+						Properties: map[string]*Schema{
+							"row":    integer("Numero de fila o registro"),
+							"status": {Type: "string", Enum: []string{"created", "updated", "rejected", "duplicate"}},
+							"id":     str("Id del libro"),
+							"isbn":   str("Isbn normalizado"),
+							"reason": str("Motivo del rechazo"),
+						},
+					}),
+				},
+			},
+			"BatchRequest": {
+				Type:     "object",
+				Required: []string{"operations"},
+				Properties: map[string]*Schema{
+					"ordered": {Type: "boolean", Description: "Detiene el lote en el primer error, true por defecto"},
+					"operations": arrayOf(&Schema{
+						Type:     "object",
+						Required: []string{"op"},
+						Properties: map[string]*Schema{
+							"op":   {Type: "string", Enum: []string{"create", "update", "delete"}},
+							"id":   str("Id del documento en update y delete"),
+							"data": {Type: "object", Description: "Documento en create y update"},
+						},
+					}),
+				},
+			},
+			"BatchReport": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"ordered":   {Type: "boolean"},
+					"succeeded": integer("Operaciones aplicadas"),
+					"failed":    integer("Operaciones fallidas"),
+					"skipped":   integer("Operaciones omitidas tras un error en un lote ordenado"),
+					"results": arrayOf(&Schema{
+						Type: "object",
+						Properties: map[string]*Schema{
+							"index":   integer("Posicion en el lote"),
+							"op":      str("Operacion"),
+							"id":      str("Id del documento"),
+							"status":  integer("Estado HTTP de la operacion"),
+							"error":   str("Error de la operacion"),
+							"skipped": {Type: "boolean"},
+						},
+					}),
+				},
+			},
+			"APIKeyRequest": {
+				Type:     "object",
+				Required: []string{"name", "scopes"},
+				Properties: map[string]*Schema{
+					"name":   str("Nombre del cliente"),
+					"scopes": arrayOf(&Schema{Type: "string", Enum: auth.Scopes}),
+				},
+			},
+			"APIKey": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"id":           id,
+					"name":         str("Nombre del cliente"),
+					"prefix":       str("Primeros caracteres de la key"),
+					"scopes":       arrayOf(&Schema{Type: "string", Enum: auth.Scopes}),
+					"created_at":   timestamp,
+					"rotated_at":   {Type: "string", Format: "date-time", Nullable: true},
+					"last_used_at": {Type: "string", Format: "date-time", Nullable: true},
+					"revoked_at":   {Type: "string", Format: "date-time", Nullable: true},
+					"key":          str("Key en claro, solo al emitir o rotar"),
+				},
+			},
+			"UserToken": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"token":      str("Token para Authorization: Bearer"),
+					"expires_at": timestamp,
+				},
+			},
+			"Job": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"name":        str("Nombre de la tarea"),
+					"description": str("Descripcion"),
+					"schedule":    str("Expresion cron en UTC"),
+					"next_run":    timestamp,
+					"running":     {Type: "boolean", Description: "Se esta ejecutando en esta instancia"},
+					"last_run":    {AllOf: []*Schema{ref("JobRun")}, Nullable: true},
+				},
+			},
+			"Jobs": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"leader": {Type: "boolean", Description: "Esta instancia ejecuta las tareas programadas"},
+					"jobs":   arrayOf(ref("Job")),
+				},
+			},
+			"JobRun": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"id":          id,
+					"job":         str("Nombre de la tarea"),
+					"trigger":     {Type: "string", Enum: []string{"schedule", "manual"}},
+					"owner":       str("Instancia que la ejecuto"),
+					"state":       {Type: "string", Enum: []string{"running", "succeeded", "failed"}},
+					"started_at":  timestamp,
+					"finished_at": {Type: "string", Format: "date-time", Nullable: true},
+					"duration_ms": integer("Duracion"),
+					"result":      {Type: "object", Description: "Resumen de la ejecucion"},
+					"error":       str("Error de la ejecucion fallida"),
+				},
+			},
+			"DependencyStatus": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"status":     {Type: "string", Enum: []string{"up", "down"}},
+					"latency_ms": integer("Latencia del ping"),
+					"error":      str("Error de la dependencia"),
+				},
+			},
+		},
+		Responses: map[string]*Response{
+			"BadRequest":          errorResponse("Parametros o cuerpo invalidos"),
+			"Unauthorized":        errorResponse("Faltan credenciales, o la API key o el token son invalidos o vencieron"),
+			"Forbidden":           errorResponse("La credencial no tiene el alcance, token de administrador invalido o rutas de administracion deshabilitadas"),
+			"NotFound":            errorResponse("Recurso no encontrado o sin conexion a la coleccion"),
+			"Conflict":            errorResponse("Conflicto con el estado actual o peticion en curso con la misma Idempotency-Key"),
+			"PayloadTooLarge":     errorResponse("El cuerpo de una peticion con Idempotency-Key supera IDEMPOTENCY_MAX_REQUEST_BODY"),
+			"UnprocessableEntity": errorResponse("La Idempotency-Key ya se uso con otro cuerpo"),
+			"TooManyRequests": {
+				Description: "Limite de tasa del grupo de rutas superado",
+				Headers: map[string]*Header{
+					"Retry-After":         {Description: "Segundos hasta la proxima ficha", Schema: &Schema{Type: "integer"}},
+					"RateLimit-Limit":     {Description: "Capacidad del bucket", Schema: &Schema{Type: "integer"}},
+					"RateLimit-Remaining": {Description: "Fichas restantes", Schema: &Schema{Type: "integer"}},
+					"RateLimit-Reset":     {Description: "Segundos hasta recargar el bucket", Schema: &Schema{Type: "integer"}},
+				},
+				Content: jsonContent(ref("Error")),
+			},
+			"InternalError":  errorResponse("Error de la base de datos"),
+			"GatewayTimeout": errorResponse("Tiempo de espera agotado en la base de datos"),
+		},
+		Parameters: map[string]*Parameter{
+			"Id": {Name: "id", In: "path", Required: true, Description: "ObjectID en hexadecimal", Schema: &Schema{Type: "string"}},
+			"IdempotencyKey": {
+				Name:        "Idempotency-Key",
+				In:          "header",
+				Description: "Clave unica del cliente, los reintentos con la misma clave y el mismo cuerpo repiten la primera respuesta con Idempotent-Replayed: true",
+				Schema:      &Schema{Type: "string"},
+			},
+		},
+		Headers: map[string]*Header{
+			"Deprecation": {Description: "Fecha de obsolescencia de la ruta (RFC 9745)", Schema: &Schema{Type: "string"}},
+			"Sunset":      {Description: "Fecha de retiro de la ruta (RFC 8594)", Schema: &Schema{Type: "string"}},
+			"Link":        {Description: "Ruta equivalente con rel=\"successor-version\"", Schema: &Schema{Type: "string"}},
+		},
+		SecuritySchemes: map[string]*SecurityScheme{
+			"adminToken": {Type: "http", Scheme: "bearer", Description: "Token de administrador"},
+			"apiKey":     {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "API key con alcances; tambien se acepta como Authorization: Bearer lib_..."},
+			"userToken":  {Type: "http", Scheme: "bearer", Description: "Token de usuario emitido por un administrador"},
+		},
+	}
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func arrayOf(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+func nullData() *Schema {
+	return &Schema{Nullable: true}
+}
+
+func jsonContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+func jsonBody(s *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: jsonContent(s)}
+}
+
+func idParam() *Parameter {
+	return &Parameter{Ref: "#/components/parameters/Id"}
+}
+
+func jobName() *Parameter {
+	return &Parameter{Name: "name", In: "path", Required: true, Description: "Nombre de la tarea", Schema: &Schema{Type: "string"}}
+}
+
+func idempotencyKey() *Parameter {
+	return &Parameter{Ref: "#/components/parameters/IdempotencyKey"}
+}
+
+func query(name, description string, s *Schema) *Parameter {
+	return &Parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+// Sobre con el esquema de data indicado
+func envelope(data *Schema) *Schema {
+	return &Schema{AllOf: []*Schema{
+		ref("Envelope"),
+		{Type: "object", Properties: map[string]*Schema{"data": data}},
+	}}
+}
+
+// Sobre de error con data distinto de null, como en /readyz
+func errorWith(data *Schema) *Schema {
+	return &Schema{AllOf: []*Schema{
+		ref("Error"),
+		{Type: "object", Properties: map[string]*Schema{"data": data}},
+	}}
+}
+
+func checks() *Schema {
+	return &Schema{Type: "object", AdditionalProperties: ref("DependencyStatus")}
+}
+
+// Respuesta exitosa con el sobre y las respuestas de error indicadas
+func responses(status int, description string, data *Schema, errs ...int) map[string]*Response {
+	r := map[string]*Response{
+		strconv.Itoa(status): {Description: description, Content: jsonContent(envelope(data))},
+	}
+	return merge(r, errorResponses(errs...))
+}
+
+func errorResponses(errs ...int) map[string]*Response {
+	r := make(map[string]*Response, len(errs))
+	for _, status := range errs {
+		r[strconv.Itoa(status)] = &Response{Ref: "#/components/responses/" + errorNames[status]}
+	}
+	return r
+}
+
+func merge(dst, src map[string]*Response) map[string]*Response {
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
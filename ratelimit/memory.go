@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Intervalo entre limpiezas de los buckets inactivos
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// Momento en el que el bucket vuelve a estar lleno y se puede descartar
+	full time.Time
+}
+
+// Almacen de buckets en memoria de una sola instancia
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}}
+}
+
+// Recarga el bucket segun el tiempo transcurrido y consume una ficha si hay disponible
+func (m *Memory) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	capacity := float64(limit.Capacity())
+	rate := limit.rate()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		m.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.last = now
+	}
+
+	var res Result
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = duration((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = duration((capacity - b.tokens) / rate)
+	b.full = now.Add(res.Reset)
+
+	return res, nil
+}
+
+// Descarta los buckets que ya se recargaron por completo, equivalen a uno nuevo
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Cantidad de buckets en memoria
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
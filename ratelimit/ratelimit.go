@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+	"backend/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// Encabezados de limite de tasa (draft-ietf-httpapi-ratelimit-headers)
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+)
+
+// Limite de un token bucket: se recargan Requests fichas cada Period y caben
+// hasta Burst fichas acumuladas. Requests en cero deshabilita el limite.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Indica si el limite esta deshabilitado
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// Capacidad del bucket, Requests si no se define Burst
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// Fichas recargadas por segundo
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Resultado de consumir una ficha
+type Result struct {
+	Allowed bool
+	// Fichas que quedan en el bucket
+	Remaining int
+	// Tiempo hasta que el bucket vuelva a estar lleno
+	Reset time.Duration
+	// Tiempo hasta la proxima ficha cuando se rechaza la peticion
+	RetryAfter time.Duration
+}
+
+// Almacen de buckets. La implementacion en memoria sirve para una sola instancia;
+// con varias instancias se implementa sobre un almacen compartido (Redis, Mongo)
+// para que todas consuman del mismo bucket.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Identifica al cliente de la peticion
+type KeyFunc func(c echo.Context) string
+
+// Clave por identidad autenticada (api key o usuario) y si no por IP. Requiere
+// que la autenticacion se ejecute antes que el limitador.
+func ClientKey(c echo.Context) string {
+	if p := auth.PrincipalFrom(c); p != nil {
+		return p.Kind + ":" + p.ID
+	}
+	return "ip:" + c.RealIP()
+}
+
+// Limitador de tasa con limites por grupo de rutas
+type Limiter struct {
+	Store Store
+	// Limite de los grupos sin limite propio
+	Default Limit
+	// Limites por grupo de rutas
+	Groups map[string]Limit
+	// Identifica al cliente, ClientKey si es nil
+	Key    KeyFunc
+	Logger *slog.Logger
+	// Reloj, time.Now si es nil
+	Now func() time.Time
+}
+
+// Retorna el limite de un grupo de rutas
+func (l *Limiter) Limit(group string) Limit {
+	if limit, ok := l.Groups[group]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Middleware que limita las peticiones del grupo. Los buckets son por grupo y cliente,
+// asi las rutas de distintas versiones del mismo grupo comparten la cuota.
+// Si el almacen falla se deja pasar la peticion.
+func (l *Limiter) Middleware(group string) echo.MiddlewareFunc {
+	limit := l.Limit(group)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if limit.Unlimited() {
+			return next
+		}
+		return func(c echo.Context) error {
+			key := ClientKey
+			if l.Key != nil {
+				key = l.Key
+			}
+			now := time.Now
+			if l.Now != nil {
+				now = l.Now
+			}
+
+			ctx := c.Request().Context()
+			res, err := l.Store.Take(ctx, group+"|"+key(c), limit, now())
+			if err != nil {
+				l.log().WarnContext(ctx, "rate limit store failed", "group", group, "error", err)
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set(HeaderLimit, strconv.Itoa(limit.Capacity()))
+			header.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+			header.Set(HeaderReset, seconds(res.Reset))
+			header.Set(HeaderPolicy, strconv.Itoa(limit.Requests)+";w="+seconds(limit.Period)+";burst="+strconv.Itoa(limit.Capacity()))
+
+			if !res.Allowed {
+				header.Set(HeaderRetryAfter, seconds(res.RetryAfter))
+				l.log().InfoContext(ctx, "rate limit exceeded", "group", group, "route", c.Path())
+				return c.JSON(http.StatusTooManyRequests, echo.Map{
+					"status":     http.StatusTooManyRequests,
+					"message":    "Demasiadas peticiones, intente de nuevo mas tarde",
+					"data":       nil,
+					"request_id": logging.RequestID(c),
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+func (l *Limiter) log() *slog.Logger {
+	if l.Logger != nil {
+		return l.Logger
+	}
+	return slog.Default()
+}
+
+// Segundos enteros redondeados hacia arriba, como exigen los encabezados
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
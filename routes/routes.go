@@ -0,0 +1,248 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+	"backend/handlers"
+	"backend/idempotency"
+	"backend/openapi"
+	"backend/ratelimit"
+	"github.com/labstack/echo/v4"
+)
+
+// Prefijos de las versiones de la API
+const (
+	V1Prefix = "/api/v1"
+	V2Prefix = "/api/v2"
+)
+
+// Opciones del registro de rutas
+type Options struct {
+	// Token de las rutas de administracion
+	AdminToken string
+	// Handler de las metricas de Prometheus, nil no registra /metrics
+	Metrics echo.HandlerFunc
+	// Fechas de obsolescencia y retiro de las rutas sin version
+	LegacyDeprecation time.Time
+	LegacySunset      time.Time
+	// Limitador de tasa por grupo de rutas, nil no limita
+	RateLimit *ratelimit.Limiter
+	// Almacen de las Idempotency-Key de las rutas de creacion, nil las ignora
+	Idempotency *idempotency.Store
+	// Identificacion por API key o token de usuario y control de alcances, nil no los aplica
+	Auth *auth.Authenticator
+}
+
+// Grupos de rutas con su propio limite de tasa
+const (
+	GroupBooks  = "books"
+	GroupUsers  = "users"
+	GroupLoans  = "loans"
+	GroupEvents = "events"
+	GroupAdmin  = "admin"
+	GroupMe     = "me"
+)
+
+// Middlewares compartidos por las versiones
+type middlewares struct {
+	identify   echo.MiddlewareFunc
+	admin      echo.MiddlewareFunc
+	patron     echo.MiddlewareFunc
+	limit      func(group string) echo.MiddlewareFunc
+	access     func(resource string) echo.MiddlewareFunc
+	idempotent func(scope string) echo.MiddlewareFunc
+}
+
+// Router del grupo con el limite de tasa y el alcance correspondientes. Cada grupo
+// exige <grupo>:read en las consultas y <grupo>:write en los cambios.
+func (m middlewares) group(r router, group string) router {
+	return r.with(m.limit(group), m.access(group))
+}
+
+// Registra las rutas de infraestructura, las versiones de la API y los alias
+// sin version que se mantienen por compatibilidad
+func Register(e *echo.Echo, h *handlers.Handler, opts Options) {
+	// Rutas de salud y metricas, sin version
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
+	if opts.Metrics != nil {
+		e.GET("/metrics", opts.Metrics)
+	}
+
+	// Documento OpenAPI y Swagger UI con sus recursos embebidos
+	ui := openapi.UI("Library API", "/openapi.json", "/docs/")
+	e.GET("/openapi.json", openapi.Handler(openapi.Spec()))
+	e.GET("/docs", ui)
+	e.GET("/docs/*", ui)
+
+	mw := middlewares{
+		admin:  auth.AdminToken(opts.AdminToken),
+		patron: auth.RequireKind(auth.KindUser),
+		limit:  func(string) echo.MiddlewareFunc { return passthrough },
+		// Los middlewares de Authenticator y Store no hacen nada si son nil
+		identify:   opts.Auth.Identify(),
+		access:     opts.Auth.RequireAccess,
+		idempotent: opts.Idempotency.Middleware,
+	}
+	if opts.RateLimit != nil {
+		mw.limit = opts.RateLimit.Middleware
+	}
+
+	// La identidad se resuelve antes del limite de tasa, que cuenta por credencial
+	v1 := newRouter(e.Group(V1Prefix)).with(mw.identify)
+	registerV1(v1, h, mw)
+
+	v2 := newRouter(e.Group(V2Prefix)).with(mw.identify)
+	registerV2(v2, h, mw)
+
+	// Alias de las rutas de v1 en la raiz, responden igual pero anuncian su retiro
+	legacy := newRouter(e.Group("")).with(Deprecated(opts.LegacyDeprecation, opts.LegacySunset, V1Prefix), mw.identify)
+	registerV1(legacy, h, mw)
+}
+
+// Rutas de la version 1
+func registerV1(r router, h *handlers.Handler, mw middlewares) {
+	books(mw.group(r, GroupBooks), h, mw)
+	users(mw.group(r, GroupUsers), h, mw)
+
+	// Rutas para la gestion de prestamos
+	loans := mw.group(r, GroupLoans)
+	loans.GET("/loans", h.GetLoans)
+	loans.with(mw.idempotent(GroupLoans)).POST("/loans", h.CreateLoan)
+	loans.PUT("/return-loan/:id", h.ReturnLoan)
+
+	stream(mw.group(r, GroupEvents), h)
+	patron(r.with(mw.patron, mw.limit(GroupMe)), h, mw)
+	administration(r.with(mw.admin, mw.limit(GroupAdmin)), h)
+}
+
+// Rutas de la version 2, con los prestamos como recurso REST
+func registerV2(r router, h *handlers.Handler, mw middlewares) {
+	books(mw.group(r, GroupBooks), h, mw)
+	users(mw.group(r, GroupUsers), h, mw)
+
+	// Rutas para la gestion de prestamos
+	loans := mw.group(r, GroupLoans)
+	loans.GET("/loans", h.GetLoans)
+	loans.with(mw.idempotent(GroupLoans)).POST("/loans", h.CreateLoan)
+	loans.POST("/loans/:id/return", h.ReturnLoan)
+
+	stream(mw.group(r, GroupEvents), h)
+	patron(r.with(mw.patron, mw.limit(GroupMe)), h, mw)
+	administration(r.with(mw.admin, mw.limit(GroupAdmin)), h)
+}
+
+// Rutas para la gestion de inventarios
+func books(r router, h *handlers.Handler, mw middlewares) {
+	r.GET("/books", h.GetBooks)
+	r.GET("/books/:id", h.GetBookById)
+	r.GET("/books/export", h.ExportBooksCSV)
+	r.POST("/books/import", h.ImportBooksCSV)
+	r.POST("/books/import/marc", h.ImportBooksMARC)
+	r.POST("/books/batch", h.BatchBooks)
+	r.with(mw.idempotent(GroupBooks)).POST("/books", h.CreateBook)
+	r.PUT("/books/:id", h.UpdateBook)
+	r.DELETE("/books/:id", h.DeleteBook)
+}
+
+// Rutas para la gestion de usuarios
+func users(r router, h *handlers.Handler, mw middlewares) {
+	r.GET("/users", h.GetUsers)
+	r.GET("/users/:id", h.GetUserById)
+	r.with(mw.idempotent(GroupUsers)).POST("/users", h.CreateUser)
+	r.POST("/users/batch", h.BatchUsers)
+	r.DELETE("/users/:id", h.DeleteUser)
+}
+
+// Flujo en vivo de disponibilidad y prestamos
+func stream(r router, h *handlers.Handler) {
+	r.GET("/events/stream", h.StreamEvents)
+}
+
+// Rutas del usuario autenticado con un token de usuario, limitadas a sus propios registros
+func patron(r router, h *handlers.Handler, mw middlewares) {
+	r.GET("/me", h.GetMe)
+	r.PUT("/me/notifications", h.UpdateMyNotifications)
+	r.GET("/me/loans", h.GetMyLoans)
+	r.POST("/me/loans/:id/renew", h.RenewMyLoan)
+	r.GET("/me/history", h.GetMyHistory)
+	r.GET("/me/fines", h.GetMyFines)
+	r.GET("/me/holds", h.GetMyHolds)
+	r.with(mw.idempotent("holds")).POST("/me/holds", h.PlaceMyHold)
+	r.DELETE("/me/holds/:id", h.CancelMyHold)
+}
+
+// Rutas de administracion
+func administration(r router, h *handlers.Handler) {
+	r.GET("/audit", h.GetAudit)
+	r.POST("/webhooks", h.CreateWebhook)
+	r.GET("/webhooks", h.GetWebhooks)
+	r.DELETE("/webhooks/:id", h.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", h.GetWebhookDeliveries)
+	r.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", h.RedeliverWebhook)
+	r.POST("/api-keys", h.CreateAPIKey)
+	r.GET("/api-keys", h.GetAPIKeys)
+	r.POST("/api-keys/:id/rotate", h.RotateAPIKey)
+	r.DELETE("/api-keys/:id", h.RevokeAPIKey)
+	r.POST("/users/:id/tokens", h.CreateUserToken)
+	r.GET("/jobs", h.GetJobs)
+	r.GET("/jobs/:name", h.GetJob)
+	r.GET("/jobs/:name/runs", h.GetJobRuns)
+	r.POST("/jobs/:name/run", h.RunJob)
+}
+
+// Registra rutas sobre un grupo aplicando los middlewares a cada ruta. No se usa
+// Group.Use porque agrega rutas comodin al prefijo del grupo, y con el prefijo
+// vacio de los alias capturaria cualquier ruta desconocida.
+type router struct {
+	group      *echo.Group
+	middleware []echo.MiddlewareFunc
+}
+
+func newRouter(g *echo.Group) router {
+	return router{group: g}
+}
+
+// Retorna un router que ademas aplica los middlewares indicados
+func (r router) with(m ...echo.MiddlewareFunc) router {
+	mw := make([]echo.MiddlewareFunc, 0, len(r.middleware)+len(m))
+	mw = append(mw, r.middleware...)
+	return router{group: r.group, middleware: append(mw, m...)}
+}
+
+func (r router) GET(path string, h echo.HandlerFunc) {
+	r.group.GET(path, h, r.middleware...)
+}
+
+func (r router) POST(path string, h echo.HandlerFunc) {
+	r.group.POST(path, h, r.middleware...)
+}
+
+func (r router) PUT(path string, h echo.HandlerFunc) {
+	r.group.PUT(path, h, r.middleware...)
+}
+
+func (r router) DELETE(path string, h echo.HandlerFunc) {
+	r.group.DELETE(path, h, r.middleware...)
+}
+
+func passthrough(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
+
+// Middleware que marca una ruta como obsoleta con los encabezados Deprecation
+// (RFC 9745) y Sunset (RFC 8594), y enlaza la ruta equivalente bajo successor
+func Deprecated(deprecation, sunset time.Time, successor string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			header.Set("Deprecation", "@"+strconv.FormatInt(deprecation.Unix(), 10))
+			header.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			header.Add("Link", "<"+successor+c.Request().URL.Path+`>; rel="successor-version"`)
+			return next(c)
+		}
+	}
+}
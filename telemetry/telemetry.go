@@ -0,0 +1,86 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"backend/config"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exportadores de trazas soportados
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Configura el proveedor global de trazas segun la configuracion. El exportador stdout
+// escribe en w, util en desarrollo y en pruebas. Retorna la funcion que vacia y cierra
+// el exportador, que debe llamarse al apagar el servicio.
+func Setup(ctx context.Context, cfg config.TracingConfig, w io.Writer) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch strings.ToLower(cfg.Exporter) {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("telemetry: exportador desconocido: %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: no se pudo crear el exportador: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: recurso invalido: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Middleware de Echo que abre un span por cada peticion entrante
+func Middleware(serviceName string) echo.MiddlewareFunc {
+	return otelecho.Middleware(serviceName)
+}
+
+// Monitor de comandos de MongoDB que abre un span por cada comando enviado
+func CommandMonitor() *event.CommandMonitor {
+	return otelmongo.NewMonitor()
+}
@@ -0,0 +1,358 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/apikeys"
+	"backend/auth"
+	"backend/handlers"
+	"backend/routes"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	keys := coll.Database().Collection("api_keys")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	svc := &apikeys.Service{Coll: keys, Now: func() time.Time { return now }}
+
+	if err := apikeys.ValidateScopes([]string{"books:read", "books:delete"}); err == nil {
+		t.Error("Un alcance desconocido deberia rechazarse")
+	}
+
+	key, err := svc.Issue(ctx, "catalogo", []string{"loans:write", "books:read", "books:read"})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !strings.HasPrefix(key.Secret, auth.APIKeyPrefix) || !strings.HasPrefix(key.Secret, key.Prefix) {
+		t.Errorf("Key inesperada: %q con prefijo %q", key.Secret, key.Prefix)
+	}
+	if len(key.Scopes) != 2 || key.Scopes[0] != "books:read" || key.Scopes[1] != "loans:write" {
+		t.Errorf("Alcances inesperados: %v", key.Scopes)
+	}
+
+	// Solo se guarda el hash
+	var stored bson.M
+	if err := keys.FindOne(ctx, bson.M{"_id": key.ID}).Decode(&stored); err != nil {
+		t.Fatalf("FindOne failed: %v", err)
+	}
+	if stored["hash"] != apikeys.Hash(key.Secret) || strings.Contains(stored["prefix"].(string)+stored["hash"].(string), key.Secret) {
+		t.Errorf("La key en claro no deberia guardarse: %v", stored)
+	}
+
+	p, err := svc.AuthenticateKey(ctx, key.Secret)
+	if err != nil {
+		t.Fatalf("AuthenticateKey failed: %v", err)
+	}
+	if p.Kind != auth.KindAPIKey || p.ID != key.ID.Hex() || !p.Allows("loans:write") || p.Allows("users:read") {
+		t.Errorf("Identidad inesperada: %+v", p)
+	}
+	if _, err := svc.AuthenticateKey(ctx, key.Secret+"x"); err != auth.ErrInvalidKey {
+		t.Errorf("Esperado ErrInvalidKey, obtuvo %v", err)
+	}
+
+	// El ultimo uso se actualiza como mucho una vez por intervalo
+	lastUsed := func() time.Time {
+		list, err := svc.List(ctx)
+		if err != nil || len(list) != 1 || list[0].LastUsedAt == nil {
+			t.Fatalf("List inesperado: %v %v", list, err)
+		}
+		return list[0].LastUsedAt.UTC()
+	}
+	if got := lastUsed(); !got.Equal(now) {
+		t.Errorf("Esperado last_used_at %v, obtuvo %v", now, got)
+	}
+	first := now
+	now = now.Add(30 * time.Second)
+	svc.AuthenticateKey(ctx, key.Secret)
+	if got := lastUsed(); !got.Equal(first) {
+		t.Errorf("last_used_at no deberia cambiar dentro del intervalo, obtuvo %v", got)
+	}
+	now = now.Add(time.Minute)
+	svc.AuthenticateKey(ctx, key.Secret)
+	if got := lastUsed(); !got.Equal(now) {
+		t.Errorf("Esperado last_used_at %v, obtuvo %v", now, got)
+	}
+
+	// La rotacion invalida la key anterior y conserva id y alcances
+	rotated, err := svc.Rotate(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if rotated.ID != key.ID || rotated.Secret == key.Secret || rotated.RotatedAt == nil || len(rotated.Scopes) != 2 {
+		t.Errorf("Rotacion inesperada: %+v", rotated)
+	}
+	if _, err := svc.AuthenticateKey(ctx, key.Secret); err != auth.ErrInvalidKey {
+		t.Errorf("La key rotada deberia rechazarse, obtuvo %v", err)
+	}
+	if _, err := svc.AuthenticateKey(ctx, rotated.Secret); err != nil {
+		t.Errorf("La key nueva deberia aceptarse: %v", err)
+	}
+
+	// Una key revocada no autentica ni se puede rotar
+	if _, err := svc.Revoke(ctx, key.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := svc.AuthenticateKey(ctx, rotated.Secret); err != auth.ErrInvalidKey {
+		t.Errorf("La key revocada deberia rechazarse, obtuvo %v", err)
+	}
+	if _, err := svc.Rotate(ctx, key.ID); err != apikeys.ErrNotFound {
+		t.Errorf("Esperado ErrNotFound, obtuvo %v", err)
+	}
+}
+
+func TestUserTokens(t *testing.T) {
+	now := time.Now()
+	signer := &auth.TokenSigner{Secret: []byte(strings.Repeat("s", 32)), TTL: time.Hour, Now: func() time.Time { return now }}
+
+	token, expires, err := signer.Issue("u1")
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !expires.After(now) {
+		t.Errorf("Vencimiento inesperado: %v", expires)
+	}
+	if id, err := signer.Verify(token); err != nil || id != "u1" {
+		t.Errorf("Esperado u1, obtuvo %q %v", id, err)
+	}
+
+	// Un token firmado con otro secreto o alterado se rechaza
+	other := &auth.TokenSigner{Secret: []byte(strings.Repeat("o", 32)), TTL: time.Hour}
+	if _, err := other.Verify(token); err != auth.ErrInvalidToken {
+		t.Errorf("Esperado ErrInvalidToken, obtuvo %v", err)
+	}
+	if _, err := signer.Verify("x" + token); err != auth.ErrInvalidToken {
+		t.Errorf("Esperado ErrInvalidToken, obtuvo %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := signer.Verify(token); err != auth.ErrExpiredToken {
+		t.Errorf("Esperado ErrExpiredToken, obtuvo %v", err)
+	}
+}
+
+func TestAuthScopes(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db := coll.Database()
+	h := &handlers.Handler{
+		Books:   coll,
+		Users:   db.Collection("users"),
+		Loans:   db.Collection("loans"),
+		APIKeys: &apikeys.Service{Coll: db.Collection("api_keys")},
+		Tokens:  &auth.TokenSigner{Secret: []byte(strings.Repeat("s", 32)), TTL: time.Hour},
+	}
+	e := echo.New()
+	routes.Register(e, h, routes.Options{
+		AdminToken: "admin-secret",
+		Auth: &auth.Authenticator{
+			Keys:       h.APIKeys,
+			Tokens:     h.Tokens,
+			AdminToken: "admin-secret",
+			Required:   true,
+		},
+	})
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	data := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+		var res struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Respuesta invalida: %s", rec.Body.String())
+		}
+		return res.Data
+	}
+	admin := []string{echo.HeaderAuthorization, "Bearer admin-secret"}
+
+	if _, err := coll.InsertOne(context.Background(), bson.M{"title": "Rayuela", "author": "Cortazar"}); err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+	res, err := h.Users.InsertOne(context.Background(), bson.M{"name": "Ana", "email": "ana@example.com"})
+	if err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+	userId := res.InsertedID.(primitive.ObjectID).Hex()
+
+	// Emision por la ruta de administracion
+	rec := do(http.MethodPost, "/api/v1/api-keys", `{"name":"catalogo","scopes":["books:read"]}`, admin...)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	created := data(rec)
+	key := created["key"].(string)
+	if rec := do(http.MethodPost, "/api/v1/api-keys", `{"name":"x","scopes":["books:delete"]}`, admin...); rec.Code != http.StatusBadRequest {
+		t.Errorf("Esperado 400 con un alcance desconocido, obtuvo %d", rec.Code)
+	}
+
+	// El listado no expone la key en claro
+	if rec := do(http.MethodGet, "/api/v1/api-keys", "", admin...); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), key) {
+		t.Errorf("Listado inesperado %d: %s", rec.Code, rec.Body.String())
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		header []string
+		want   int
+	}{
+		{"sin credenciales", http.MethodGet, "/api/v1/books", nil, http.StatusUnauthorized},
+		{"key invalida", http.MethodGet, "/api/v1/books", []string{auth.HeaderAPIKey, "lib_nope"}, http.StatusUnauthorized},
+		{"key con alcance", http.MethodGet, "/api/v1/books", []string{auth.HeaderAPIKey, key}, http.StatusFound},
+		{"key como bearer", http.MethodGet, "/books", []string{echo.HeaderAuthorization, "Bearer " + key}, http.StatusFound},
+		{"key sin alcance de escritura", http.MethodPost, "/api/v2/books", []string{auth.HeaderAPIKey, key}, http.StatusForbidden},
+		{"key sin alcance de usuarios", http.MethodGet, "/api/v2/users", []string{auth.HeaderAPIKey, key}, http.StatusForbidden},
+		{"key en administracion", http.MethodGet, "/api/v1/audit", []string{auth.HeaderAPIKey, key}, http.StatusUnauthorized},
+		{"token invalido", http.MethodGet, "/api/v1/books", []string{echo.HeaderAuthorization, "Bearer nope"}, http.StatusUnauthorized},
+		{"administrador", http.MethodGet, "/api/v1/users", admin, http.StatusFound},
+	}
+	for _, tc := range cases {
+		if rec := do(tc.method, tc.path, "", tc.header...); rec.Code != tc.want {
+			t.Errorf("%s: esperado %d, obtuvo %d: %s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Token de usuario para un usuario existente
+	rec = do(http.MethodPost, "/api/v1/users/"+userId+"/tokens", "", admin...)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	bearer := []string{echo.HeaderAuthorization, "Bearer " + data(rec)["token"].(string)}
+	if rec := do(http.MethodGet, "/api/v1/books", "", bearer...); rec.Code != http.StatusFound {
+		t.Errorf("Esperado 302 con token de usuario, obtuvo %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/loans", `{}`, bearer...); rec.Code != http.StatusForbidden {
+		t.Errorf("Esperado 403 con token de usuario, obtuvo %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/users/000000000000000000000000/tokens", "", admin...); rec.Code != http.StatusNotFound {
+		t.Errorf("Esperado 404 para un usuario inexistente, obtuvo %d", rec.Code)
+	}
+
+	// Una key revocada deja de autenticar
+	id := created["id"].(string)
+	if rec := do(http.MethodDelete, "/api/v1/api-keys/"+id, "", admin...); rec.Code != http.StatusOK {
+		t.Fatalf("Esperado 200, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/api/v1/books", "", auth.HeaderAPIKey, key); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Esperado 401 con la key revocada, obtuvo %d", rec.Code)
+	}
+}
+
+// Sin credenciales obligatorias un token sin el alcance tiene el mismo acceso que una peticion
+// anonima; con credenciales obligatorias ambos se rechazan
+func TestAuthScopesAnonymousAndTokenMatch(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db := coll.Database()
+	if _, err := db.Collection("loans").InsertOne(context.Background(), bson.M{"name": "P", "description": "D"}); err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+	signer := &auth.TokenSigner{Secret: []byte(strings.Repeat("s", 32)), TTL: time.Hour}
+	token, _, err := signer.Issue(primitive.NewObjectID().Hex())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		required        bool
+		anonymous, user int
+	}{
+		{false, http.StatusFound, http.StatusFound},
+		{true, http.StatusUnauthorized, http.StatusForbidden},
+	} {
+		h := &handlers.Handler{Books: coll, Users: db.Collection("users"), Loans: db.Collection("loans"), Tokens: signer}
+		e := echo.New()
+		routes.Register(e, h, routes.Options{Auth: &auth.Authenticator{Tokens: signer, Required: tc.required}})
+
+		// Los tokens de usuario no tienen loans:read
+		for _, call := range []struct {
+			name   string
+			header string
+			want   int
+		}{
+			{"anonima", "", tc.anonymous},
+			{"token de usuario", "Bearer " + token, tc.user},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/loans", nil)
+			if call.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, call.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != call.want {
+				t.Errorf("required=%v, %s: esperado %d, obtuvo %d: %s", tc.required, call.name, call.want, rec.Code, rec.Body.String())
+			}
+		}
+	}
+}
+
+// Con la auditoria activa los cambios exigen credenciales aunque AUTH_REQUIRED sea falso, y la
+// entrada de auditoria registra la identidad; las consultas siguen abiertas
+func TestAuthRequiredForWritesKeepsAuditActor(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db := coll.Database()
+	audit := db.Collection("audit_log")
+	h := &handlers.Handler{Books: coll, Users: db.Collection("users"), Loans: db.Collection("loans"), Audit: audit}
+	e := echo.New()
+	routes.Register(e, h, routes.Options{
+		AdminToken: "admin-secret",
+		Auth:       &auth.Authenticator{AdminToken: "admin-secret", RequiredForWrites: true},
+	})
+
+	do := func(method, path, body, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	book := `{"title":"Rayuela","author":"Cortazar","isbn":"AUD1","availability":1}`
+	if code := do(http.MethodPost, "/api/v1/books", book, ""); code != http.StatusUnauthorized {
+		t.Errorf("Esperado 401 en un cambio anonimo, obtuvo %d", code)
+	}
+	if code := do(http.MethodPost, "/api/v1/books", book, "admin-secret"); code != http.StatusCreated {
+		t.Fatalf("Esperado 201 con credenciales, obtuvo %d", code)
+	}
+	if code := do(http.MethodGet, "/api/v1/books", "", ""); code == http.StatusUnauthorized || code == http.StatusForbidden {
+		t.Errorf("Las consultas anonimas deberian seguir abiertas, obtuvo %d", code)
+	}
+
+	var entry struct {
+		Actor string `bson:"actor"`
+	}
+	if err := audit.FindOne(context.Background(), bson.M{"entity": "book"}).Decode(&entry); err != nil {
+		t.Fatalf("Audit entry not found: %v", err)
+	}
+	if entry.Actor != "admin:admin" {
+		t.Errorf("Esperado actor admin:admin, obtuvo %q", entry.Actor)
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/auth"
+	"backend/handlers"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuditRequiresAdminToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"deshabilitado", "", "Bearer secreto", http.StatusForbidden},
+		{"sin token", "secreto", "", http.StatusUnauthorized},
+		{"token invalido", "secreto", "Bearer otro", http.StatusForbidden},
+		// Con token válido llega al handler, que no tiene colección configurada
+		{"token valido", "secreto", "Bearer secreto", http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			h := &handlers.Handler{}
+			e.GET("/audit", h.GetAudit, auth.AdminToken(tc.token))
+
+			req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+			if tc.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("Esperado %d, obtuvo %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+	if got := auth.Actor(c); got != "anonymous" {
+		t.Errorf("Esperado anonymous, obtuvo %s", got)
+	}
+
+	// Un encabezado declarado por el cliente no suplanta la identidad
+	req.Header.Set("X-Actor", "bibliotecaria-1")
+	if got := auth.Actor(c); got != "anonymous" {
+		t.Errorf("Esperado anonymous, obtuvo %s", got)
+	}
+
+	auth.SetPrincipal(c, &auth.Principal{ID: "admin", Kind: auth.KindAdmin})
+	if got := auth.Actor(c); got != "admin:admin" {
+		t.Errorf("Esperado admin:admin, obtuvo %s", got)
+	}
+}
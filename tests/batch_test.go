@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"backend/handlers"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func runBatch(t *testing.T, handler echo.HandlerFunc, body interface{}) handlers.BatchReport {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/books/batch", bytes.NewReader(raw))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data handlers.BatchReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	return resp.Data
+}
+
+func statuses(report handlers.BatchReport) []int {
+	var out []int
+	for _, r := range report.Results {
+		if r.Skipped {
+			out = append(out, 0)
+			continue
+		}
+		out = append(out, r.Status)
+	}
+	return out
+}
+
+func TestBatchBooksOrderedAndUnordered(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"isbn": 1}, Options: options.Index().SetUnique(true)}); err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	res, err := coll.InsertOne(ctx, bson.M{"title": "Existente", "author": "A", "isbn": "A1", "availability": 1, "shelf": "B2"})
+	if err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	existing := res.InsertedID.(primitive.ObjectID).Hex()
+
+	h := &handlers.Handler{Books: coll, Outbox: coll.Database().Collection("outbox"), Audit: coll.Database().Collection("audit_log")}
+	book := func(title, isbn string) models.Book {
+		return models.Book{Title: title, Author: "Autor", Isbn: isbn, Availability: 1}
+	}
+
+	// Sin orden se aplican todos los elementos validos
+	report := runBatch(t, h.BatchBooks, map[string]interface{}{
+		"ordered": false,
+		"operations": []map[string]interface{}{
+			{"op": "create", "data": book("Nuevo", "X1")},
+			{"op": "create", "data": book("Duplicado", "A1")},
+			{"op": "update", "id": existing, "data": book("Actualizado", "A1")},
+			{"op": "delete", "id": primitive.NewObjectID().Hex()},
+			{"op": "create", "data": book("", "X2")},
+		},
+	})
+	want := []int{http.StatusCreated, http.StatusConflict, http.StatusOK, http.StatusNotFound, http.StatusBadRequest}
+	if got := statuses(report); !slices.Equal(got, want) {
+		t.Errorf("Unordered: expected %v, got %v", want, got)
+	}
+	if report.Succeeded != 2 || report.Failed != 3 || report.Results[0].Id == "" {
+		t.Errorf("Unexpected unordered report: %+v", report)
+	}
+
+	// En orden el lote se detiene en el primer fallo
+	report = runBatch(t, h.BatchBooks, map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create", "data": book("Otro", "Y1")},
+			{"op": "create", "data": book("Duplicado", "X1")},
+			{"op": "create", "data": book("Omitido", "Z1")},
+		},
+	})
+	want = []int{http.StatusCreated, http.StatusConflict, 0}
+	if got := statuses(report); !slices.Equal(got, want) {
+		t.Errorf("Ordered: expected %v, got %v", want, got)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{"isbn": "Z1"}); n != 0 {
+		t.Error("Skipped item was written")
+	}
+
+	var updated models.Book
+	if err := coll.FindOne(ctx, bson.M{"isbn": "A1"}).Decode(&updated); err != nil || updated.Title != "Actualizado" {
+		t.Errorf("Book not updated: %+v (%v)", updated, err)
+	}
+	// Un evento y una entrada de auditoria por elemento aplicado
+	if n, _ := h.Outbox.CountDocuments(ctx, bson.M{}); n != 3 {
+		t.Errorf("Expected 3 outbox events, got %d", n)
+	}
+	if n, _ := h.Audit.CountDocuments(ctx, bson.M{"entity": "book"}); n != 3 {
+		t.Errorf("Expected 3 audit entries, got %d", n)
+	}
+	// Los eventos de un lote reciben numeros consecutivos de la secuencia
+	var seqs []int64
+	cur, err := h.Outbox.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"seq": 1}))
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	for cur.Next(ctx) {
+		seqs = append(seqs, cur.Current.Lookup("seq").AsInt64())
+	}
+	if !slices.Equal(seqs, []int64{1, 2, 3}) {
+		t.Errorf("Expected seqs [1 2 3], got %v", seqs)
+	}
+	// La actualizacion audita el documento resultante, no solo los campos cambiados
+	var entry models.AuditEntry
+	if err := h.Audit.FindOne(ctx, bson.M{"action": "update", "entity_id": existing}).Decode(&entry); err != nil {
+		t.Fatalf("Update audit entry not found: %v", err)
+	}
+	if entry.After["shelf"] != "B2" || entry.After["title"] != "Actualizado" {
+		t.Errorf("Expected the resulting document as after, got %v", entry.After)
+	}
+}
+
+func TestBatchUsersValidationStopsOrderedBatch(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	users := coll.Database().Collection("users")
+	h := &handlers.Handler{Users: users}
+
+	report := runBatch(t, h.BatchUsers, map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create", "data": models.User{Name: "Ana", Email: "ana@example.com"}},
+			{"op": "update", "id": "no-es-un-id", "data": models.User{Name: "X", Email: "x@example.com"}},
+			{"op": "create", "data": models.User{Name: "Luis", Email: "luis@example.com"}},
+		},
+	})
+	want := []int{http.StatusCreated, http.StatusBadRequest, 0}
+	if got := statuses(report); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if n, _ := users.CountDocuments(context.Background(), bson.M{}); n != 1 {
+		t.Errorf("Expected 1 user, got %d", n)
+	}
+}
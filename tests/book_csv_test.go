@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/handlers"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestImportBooksCSVRejectsMalformedQuotes(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	h := &handlers.Handler{Books: coll}
+	e := echo.New()
+
+	// Una comilla suelta y una comilla sin cerrar se rechazan sin detener la importacion
+	body := strings.Join([]string{
+		"title,author,isbn,availability",
+		`"a"x,Autor,111,1`,
+		"Bueno,Autor,222,1",
+		`"x,Autor,333,1`,
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/books/import", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, "text/csv")
+	rec := httptest.NewRecorder()
+	if err := h.ImportBooksCSV(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Data handlers.ImportReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	report := resp.Data
+	if report.Created != 1 || report.Rejected != 2 || len(report.Rows) != 3 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if got := report.Rows[0]; got.Row != 2 || got.Status != handlers.RowRejected || got.Reason == "" {
+		t.Errorf("Unexpected row for the bare quote: %+v", got)
+	}
+	if got := report.Rows[2]; got.Row != 4 || got.Status != handlers.RowRejected {
+		t.Errorf("Unexpected row for the unterminated quote: %+v", got)
+	}
+}
+
+func TestImportBooksCSVUpsertsByIsbnAndReportsRows(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := coll.InsertOne(ctx, models.Book{Title: "Viejo", Author: "Autor", Isbn: "111", Availability: 1}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	h := &handlers.Handler{Books: coll}
+	e := echo.New()
+
+	// Las columnas pueden venir en cualquier orden
+	body := strings.Join([]string{
+		"isbn,title,author,availability",
+		"111,Nuevo titulo,Autor,4",
+		"222,Libro nuevo,Otra,2",
+		"333,,Sin titulo,1",
+		"444,Titulo,Autor,muchos",
+		"555,Titulo,Autor,0",
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/books/import", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, "text/csv")
+	rec := httptest.NewRecorder()
+	if err := h.ImportBooksCSV(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Data handlers.ImportReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	report := resp.Data
+	if report.Created != 1 || report.Updated != 1 || report.Rejected != 3 {
+		t.Fatalf("Unexpected totals: %+v", report)
+	}
+	want := []struct {
+		row    int
+		status string
+		reason string
+	}{
+		{2, handlers.RowUpdated, ""},
+		{3, handlers.RowCreated, ""},
+		{4, handlers.RowRejected, "El titulo es obligatorio"},
+		{5, handlers.RowRejected, "La disponibilidad debe ser un numero entero"},
+		{6, handlers.RowRejected, "La disponibilidad es obligatoria y valida"},
+	}
+	for i, w := range want {
+		got := report.Rows[i]
+		if got.Row != w.row || got.Status != w.status || got.Reason != w.reason {
+			t.Errorf("Row %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	var updated models.Book
+	if err := coll.FindOne(ctx, bson.M{"isbn": "111"}).Decode(&updated); err != nil {
+		t.Fatalf("Book not found: %v", err)
+	}
+	if updated.Title != "Nuevo titulo" || updated.Availability != 4 {
+		t.Errorf("Book not updated: %+v", updated)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 2 {
+		t.Errorf("Expected 2 books, got %d", n)
+	}
+
+	// La exportacion contiene los mismos libros
+	req = httptest.NewRequest(http.MethodGet, "/books/export", nil)
+	rec = httptest.NewRecorder()
+	if err := h.ExportBooksCSV(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != "id,title,author,isbn,availability" {
+		t.Errorf("Unexpected export: %v", records)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backend/config"
+)
+
+// clearConfigEnv aísla la prueba de las variables de entorno del sistema.
+func clearConfigEnv(t *testing.T) {
+	for _, key := range []string{
+		"CONFIG_FILE", "MONGO_URI", "MONGO_DATABASE", "MONGO_CONNECT_TIMEOUT",
+		"MONGO_READ_TIMEOUT", "MONGO_WRITE_TIMEOUT",
+		"HTTP_ADDR", "HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_SHUTDOWN_TIMEOUT",
+		"LOG_LEVEL",
+		"LOAN_DAYS", "LOAN_MAX_ACTIVE", "TRACING_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_INSECURE", "OTEL_SERVICE_NAME", "TRACING_SAMPLE_RATIO",
+		"ADMIN_TOKEN", "OUTBOX_POLL_INTERVAL", "OUTBOX_BATCH_SIZE", "OUTBOX_MAX_ATTEMPTS",
+		"OUTBOX_BASE_BACKOFF", "OUTBOX_MAX_BACKOFF", "WEBHOOKS_TIMEOUT", "WEBHOOKS_RETRY_INTERVAL", "WEBHOOKS_WORKERS", "WEBHOOKS_LEASE",
+		"WEBHOOKS_MAX_ATTEMPTS", "WEBHOOKS_BASE_BACKOFF", "WEBHOOKS_MAX_BACKOFF",
+		"STREAM_POLL_INTERVAL", "STREAM_HEARTBEAT", "STREAM_GAP_TIMEOUT", "MIGRATE_ON_STARTUP", "MIGRATIONS_LOCK_TTL",
+		"MIGRATIONS_TIMEOUT", "INDEXES_SYNC_ON_STARTUP", "INDEXES_DROP_UNKNOWN", "INDEXES_STRICT", "INDEXES_TIMEOUT",
+		"API_LEGACY_DEPRECATION", "API_LEGACY_SUNSET", "HTTP_TRUST_PROXY", "RATE_LIMIT_ENABLED",
+		"RATE_LIMIT_REQUESTS", "RATE_LIMIT_PERIOD", "RATE_LIMIT_BURST",
+		"IDEMPOTENCY_TTL", "IDEMPOTENCY_LOCK_TTL", "IDEMPOTENCY_MAX_REQUEST_BODY", "AUTH_TOKEN_SECRET", "AUTH_TOKEN_TTL", "AUTH_REQUIRED", "AUDIT_ENABLED",
+		"LOAN_MAX_RENEWALS", "LOAN_FINE_PER_DAY", "LOAN_MAX_FINE", "LOAN_HOLD_PICKUP_DAYS",
+		"NOTIFY_ENABLED", "NOTIFY_DUE_SOON_DAYS", "NOTIFY_LANGUAGE",
+		"SMTP_ADDR", "SMTP_FROM", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_TIMEOUT",
+		"JOBS_ENABLED", "JOBS_LOCK_TTL", "JOBS_TIMEOUT",
+		"JOBS_OVERDUE_SCHEDULE", "JOBS_HOLD_EXPIRY_SCHEDULE", "JOBS_NOTIFICATIONS_SCHEDULE",
+	} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	clearConfigEnv(t)
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Mongo.URI != config.DefaultMongoURI {
+		t.Errorf("Esperado %s, obtuvo %s", config.DefaultMongoURI, cfg.Mongo.URI)
+	}
+	if cfg.Server.Addr != config.DefaultAddr {
+		t.Errorf("Esperado %s, obtuvo %s", config.DefaultAddr, cfg.Server.Addr)
+	}
+	if cfg.Loans.LoanDays != config.DefaultLoanDays {
+		t.Errorf("Esperado %d, obtuvo %d", config.DefaultLoanDays, cfg.Loans.LoanDays)
+	}
+}
+
+func TestConfigFileAndEnvOverride(t *testing.T) {
+	clearConfigEnv(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte("mongo:\n  database: from_file\n  connect_timeout: 3s\nserver:\n  addr: \":9090\"\nloans:\n  loan_days: 7\n")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("HTTP_ADDR", ":7070")
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Mongo.Database != "from_file" {
+		t.Errorf("Esperado from_file, obtuvo %s", cfg.Mongo.Database)
+	}
+	if cfg.Mongo.ConnectTimeout != 3*time.Second {
+		t.Errorf("Esperado 3s, obtuvo %s", cfg.Mongo.ConnectTimeout)
+	}
+	if cfg.Loans.LoanDays != 7 {
+		t.Errorf("Esperado 7, obtuvo %d", cfg.Loans.LoanDays)
+	}
+	// Las variables de entorno tienen prioridad sobre el archivo
+	if cfg.Server.Addr != ":7070" {
+		t.Errorf("Esperado :7070, obtuvo %s", cfg.Server.Addr)
+	}
+}
+
+func TestConfigTomlFile(t *testing.T) {
+	clearConfigEnv(t)
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := []byte("[mongo]\nuri = \"mongodb://db:27017\"\n\n[log]\nlevel = \"debug\"\n")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Mongo.URI != "mongodb://db:27017" {
+		t.Errorf("Esperado mongodb://db:27017, obtuvo %s", cfg.Mongo.URI)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("Esperado debug, obtuvo %s", cfg.Log.Level)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	clearConfigEnv(t)
+
+	t.Setenv("MONGO_URI", "localhost:27017")
+	if _, err := config.Load(""); err == nil {
+		t.Error("Esperado error por uri invalida")
+	}
+
+	t.Setenv("MONGO_URI", "")
+	t.Setenv("LOAN_DAYS", "abc")
+	if _, err := config.Load(""); err == nil {
+		t.Error("Esperado error por dias de prestamo invalidos")
+	}
+
+	t.Setenv("LOAN_DAYS", "")
+	t.Setenv("LOG_LEVEL", "verbose")
+	if _, err := config.Load(""); err == nil {
+		t.Error("Esperado error por nivel de log invalido")
+	}
+}
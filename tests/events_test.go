@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/events"
+)
+
+func TestOutboxBackoffIsExponentialAndCapped(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{50, time.Minute},
+	}
+
+	for _, tc := range cases {
+		if got := events.Backoff(tc.attempt, time.Second, time.Minute); got != tc.want {
+			t.Errorf("Intento %d: esperado %s, obtuvo %s", tc.attempt, tc.want, got)
+		}
+	}
+}
+
+func TestEventFanoutPublishesToEveryDestination(t *testing.T) {
+	var got []string
+	ok := events.PublisherFunc(func(_ context.Context, evt events.Event) error {
+		got = append(got, evt.Type)
+		return nil
+	})
+	failing := events.PublisherFunc(func(context.Context, events.Event) error {
+		return errors.New("destino caido")
+	})
+
+	evt := events.New(events.LoanCreated, "loan-1", nil, "req-1")
+	err := events.Fanout{ok, failing, ok}.Publish(context.Background(), evt)
+
+	if err == nil {
+		t.Error("Esperaba el error del destino caido")
+	}
+	if len(got) != 2 {
+		t.Errorf("Esperado 2 publicaciones, obtuvo %d", len(got))
+	}
+	if evt.Status != events.StatusPending || evt.RequestId != "req-1" {
+		t.Errorf("Evento nuevo inesperado: %+v", evt)
+	}
+}
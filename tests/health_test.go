@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/handlers"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestHealthzAlwaysOk(t *testing.T) {
+	e := echo.New()
+	h := &handlers.Handler{}
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := h.Healthz(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("Esperado 200, obtuvo %d", rec.Code)
+	}
+}
+
+func TestReadyzReportsMissingDependencies(t *testing.T) {
+	e := echo.New()
+	h := &handlers.Handler{}
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := h.Readyz(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Esperado 503, obtuvo %d", rec.Code)
+	}
+
+	var body struct {
+		Data map[string]struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, dep := range []string{"mongo", "books", "users", "loans"} {
+		if body.Data[dep].Status != "down" {
+			t.Errorf("Esperado %s down, obtuvo %q", dep, body.Data[dep].Status)
+		}
+	}
+}
+
+func TestReadyzFailsFastWhileShuttingDown(t *testing.T) {
+	e := echo.New()
+	h := &handlers.Handler{}
+	h.SetShuttingDown()
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := h.Readyz(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Esperado 503, obtuvo %d", rec.Code)
+	}
+}
+
+func TestReadyzHidesMongoError(t *testing.T) {
+	cfg := loadTestConfig(t)
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		t.Fatalf("Error connecting to MongoDB: %v", err)
+	}
+	client.Disconnect(context.Background())
+
+	e := echo.New()
+	h := &handlers.Handler{Client: client}
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := h.Readyz(c); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data map[string]map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	mongoStatus := body.Data["mongo"]
+	if mongoStatus["status"] != "down" {
+		t.Errorf("Esperado mongo down, obtuvo %v", mongoStatus)
+	}
+	if _, ok := mongoStatus["error"]; ok {
+		t.Errorf("La respuesta no deberia incluir el error de mongo: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/handlers"
+	"backend/idempotency"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	users := coll.Database().Collection("users")
+	now := time.Now().UTC()
+	store := &idempotency.Store{
+		Coll: coll.Database().Collection("idempotency_keys"),
+		TTL:  time.Hour,
+		Now:  func() time.Time { return now },
+	}
+	h := &handlers.Handler{Users: users}
+	e := echo.New()
+	e.POST("/users", h.CreateUser, store.Middleware("users"))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(idempotency.Header, key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	count := func() int64 {
+		n, err := users.CountDocuments(context.Background(), bson.M{})
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		return n
+	}
+
+	first := post("k1", `{"name":"Ana","email":"ana@example.com"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d: %s", first.Code, first.Body.String())
+	}
+
+	// El mismo cuerpo con otro orden de campos repite la primera respuesta
+	replay := post("k1", `{ "email": "ana@example.com", "name": "Ana" }`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("Esperado la misma respuesta, obtuvo %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get(idempotency.HeaderReplayed) != "true" {
+		t.Error("La respuesta repetida deberia llevar Idempotent-Replayed")
+	}
+	if n := count(); n != 1 {
+		t.Errorf("Esperado 1 usuario, obtuvo %d", n)
+	}
+
+	// Otro cuerpo con la misma clave
+	if rec := post("k1", `{"name":"Beto","email":"beto@example.com"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Esperado 422, obtuvo %d", rec.Code)
+	}
+
+	// Sin clave no hay deduplicacion
+	post("", `{"name":"Ana","email":"ana@example.com"}`)
+	if n := count(); n != 2 {
+		t.Errorf("Esperado 2 usuarios, obtuvo %d", n)
+	}
+
+	// Vencida la clave se procesa de nuevo aunque el indice TTL aun no la haya eliminado
+	now = now.Add(2 * time.Hour)
+	if rec := post("k1", `{"name":"Ana","email":"ana@example.com"}`); rec.Header().Get(idempotency.HeaderReplayed) != "" {
+		t.Error("Una clave vencida no deberia repetirse")
+	}
+	if n := count(); n != 3 {
+		t.Errorf("Esperado 3 usuarios, obtuvo %d", n)
+	}
+}
+
+func TestIdempotencyKeyInProgressAndFailures(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	keys := coll.Database().Collection("idempotency_keys")
+	store := &idempotency.Store{Coll: keys}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	calls := 0
+	e := echo.New()
+	e.POST("/slow", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.JSON(http.StatusCreated, echo.Map{"status": http.StatusCreated})
+	}, store.Middleware("slow"))
+	e.POST("/fail", func(c echo.Context) error {
+		calls++
+		return c.JSON(http.StatusInternalServerError, echo.Map{"status": http.StatusInternalServerError})
+	}, store.Middleware("fail"))
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		req.Header.Set(idempotency.Header, "k")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("/slow") }()
+	<-started
+
+	// Mientras la primera peticion esta en curso
+	if rec := post("/slow"); rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Esperado 409 con Retry-After, obtuvo %d", rec.Code)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d", rec.Code)
+	}
+	if rec := post("/slow"); rec.Code != http.StatusCreated || rec.Header().Get(idempotency.HeaderReplayed) != "true" {
+		t.Errorf("Esperado la respuesta repetida, obtuvo %d", rec.Code)
+	}
+
+	// Los errores 5xx no se guardan y el reintento llega al handler
+	post("/fail")
+	post("/fail")
+	if calls != 2 {
+		t.Errorf("Esperado 2 llamadas al handler, obtuvo %d", calls)
+	}
+	if n, _ := keys.CountDocuments(context.Background(), bson.M{"_id": "fail||k"}); n != 0 {
+		t.Error("La clave de una respuesta 5xx deberia liberarse")
+	}
+}
+
+func TestIdempotencyKeyRejectsLargeBody(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	users := coll.Database().Collection("users")
+	keys := coll.Database().Collection("idempotency_keys")
+	store := &idempotency.Store{Coll: keys, MaxRequestBody: 64}
+	h := &handlers.Handler{Users: users}
+	e := echo.New()
+	e.POST("/users", h.CreateUser, store.Middleware("users"))
+
+	body := `{"name":"` + strings.Repeat("a", 100) + `","email":"ana@example.com"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(idempotency.Header, "k1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Esperado 413, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "demasiado grande") {
+		t.Errorf("Mensaje inesperado: %s", rec.Body.String())
+	}
+	// No se reserva la clave ni se crea el usuario
+	for name, c := range map[string]*mongo.Collection{"claves": keys, "usuarios": users} {
+		if n, err := c.CountDocuments(context.Background(), bson.M{}); err != nil || n != 0 {
+			t.Errorf("Esperado 0 %s, obtuvo %d (%v)", name, n, err)
+		}
+	}
+}
+
+// Una peticion que tarda mas que LockTTL conserva la reserva y un reintento no la ejecuta de nuevo
+func TestIdempotencyKeyRenewsReservation(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := &idempotency.Store{Coll: coll.Database().Collection("idempotency_keys"), LockTTL: 150 * time.Millisecond}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls int32
+	e := echo.New()
+	e.POST("/slow", func(c echo.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+		return c.JSON(http.StatusCreated, echo.Map{"status": http.StatusCreated})
+	}, store.Middleware("slow"))
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader(`{}`))
+		req.Header.Set(idempotency.Header, "k")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post() }()
+	<-started
+
+	// Vencido LockTTL varias veces la reserva sigue vigente
+	time.Sleep(500 * time.Millisecond)
+	if rec := post(); rec.Code != http.StatusConflict {
+		t.Errorf("Esperado 409, obtuvo %d", rec.Code)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d", rec.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Esperado 1 llamada al handler, obtuvo %d", n)
+	}
+}
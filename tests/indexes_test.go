@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func driftActions(drift []database.IndexDrift) map[string]string {
+	actions := map[string]string{}
+	for _, d := range drift {
+		actions[d.Collection+"."+d.Name] = d.Action
+	}
+	return actions
+}
+
+func TestSyncIndexesReportsAndFixesDrift(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	loans := db.Collection("loans")
+
+	// Un indice con la definicion anterior y otro fuera del registro
+	_, _ = loans.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("user_open")})
+	_, _ = loans.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetName("legacy_name")})
+
+	registry := []database.IndexSpec{
+		{Collection: "books", Name: "isbn_unique", Keys: bson.D{{Key: "isbn", Value: 1}}, Unique: true},
+		{Collection: "loans", Name: "user_open", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "is_returned", Value: 1}}},
+	}
+
+	drift, err := database.SyncIndexes(ctx, db, registry, database.SyncOptions{DryRun: true, DropUnknown: true})
+	if err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	want := map[string]string{
+		"books.isbn_unique": database.DriftCreate,
+		"loans.user_open":   database.DriftRecreate,
+		"loans.legacy_name": database.DriftDrop,
+	}
+	if got := driftActions(drift); len(got) != len(want) || got["books.isbn_unique"] != want["books.isbn_unique"] ||
+		got["loans.user_open"] != want["loans.user_open"] || got["loans.legacy_name"] != want["loans.legacy_name"] {
+		t.Fatalf("Unexpected drift: %+v", drift)
+	}
+	for _, d := range drift {
+		if d.Applied {
+			t.Errorf("Dry run applied %+v", d)
+		}
+	}
+
+	// Sin eliminar desconocidos solo se reportan
+	drift, err = database.SyncIndexes(ctx, db, registry, database.SyncOptions{})
+	if err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	if got := driftActions(drift); got["loans.legacy_name"] != database.DriftUnknown {
+		t.Errorf("Expected unknown index to be reported, got %+v", drift)
+	}
+
+	// Una vez sincronizado solo queda el indice desconocido
+	drift, _ = database.SyncIndexes(ctx, db, registry, database.SyncOptions{})
+	if len(drift) != 1 || drift[0].Name != "legacy_name" {
+		t.Errorf("Expected only the unknown index, got %+v", drift)
+	}
+	if _, err := coll.InsertMany(ctx, []interface{}{bson.M{"isbn": "1"}, bson.M{"isbn": "1"}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("Expected unique isbn index, got %v", err)
+	}
+}
+
+func TestSyncIndexesKeepsIndexWhenRecreateFails(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	loans := db.Collection("loans")
+
+	// Los duplicados impiden construir la nueva definicion unica
+	if _, err := loans.InsertMany(ctx, []interface{}{bson.M{"code": "A", "kind": 1}, bson.M{"code": "A", "kind": 1}}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	if _, err := loans.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetName("code")}); err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+
+	for _, keys := range []bson.D{
+		{{Key: "code", Value: 1}},
+		{{Key: "code", Value: 1}, {Key: "kind", Value: 1}},
+	} {
+		registry := []database.IndexSpec{{Collection: "loans", Name: "code", Keys: keys, Unique: true}}
+		drift, err := database.SyncIndexes(ctx, db, registry, database.SyncOptions{})
+		if err == nil || len(drift) != 1 || drift[0].Applied || drift[0].Error == "" {
+			t.Fatalf("Expected failed recreate, got %+v (%v)", drift, err)
+		}
+
+		// El indice anterior sigue existiendo con su definicion original
+		cur, err := loans.Indexes().List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var indexes []bson.M
+		if err := cur.All(ctx, &indexes); err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		found := false
+		for _, index := range indexes {
+			if index["name"] == "code" {
+				found = true
+				if unique, _ := index["unique"].(bool); unique {
+					t.Errorf("Expected previous definition, got %v", index)
+				}
+			} else if index["name"] != "_id_" {
+				t.Errorf("Unexpected index left behind: %v", index)
+			}
+		}
+		if !found {
+			t.Errorf("Index dropped after failed recreate with keys %v", keys)
+		}
+	}
+}
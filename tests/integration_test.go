@@ -4,22 +4,42 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"backend/auth"
+	"backend/config"
+	"backend/database"
+	"backend/events"
 	"backend/handlers"
+	"backend/logging"
 	"backend/models"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// loadTestConfig carga la configuración de pruebas desde el entorno (MONGO_URI, CONFIG_FILE).
+func loadTestConfig(t *testing.T) config.Config {
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	return cfg
+}
+
 // setupTestDB conecta a MongoDB local y prepara la colección de pruebas.
 func setupTestDB(t *testing.T) (*mongo.Collection, func()) {
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	cfg := loadTestConfig(t)
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.Mongo.URI))
 	if err != nil {
 		t.Fatalf("Error connecting to MongoDB: %v", err)
 	}
@@ -78,3 +98,330 @@ func TestCreateBook(t *testing.T) {
 		t.Errorf("Expected 1 document, found %d", count)
 	}
 }
+
+// TestCreateBookDuplicateIsbnReturnsConflict verifica que el indice unico de isbn responde 409
+// sin exponer el error de la base de datos
+func TestCreateBookDuplicateIsbnReturnsConflict(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"isbn": 1}, Options: options.Index().SetUnique(true)}); err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if _, err := coll.InsertOne(ctx, models.Book{Title: "Original", Author: "A", Isbn: "DUP1", Availability: 1}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	h := &handlers.Handler{Books: coll}
+	body, _ := json.Marshal(models.Book{Title: "Copia", Author: "B", Isbn: "DUP1", Availability: 1})
+	req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	if err := h.CreateBook(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d but got %d", http.StatusConflict, rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "E11000") {
+		t.Errorf("Response exposes the database error: %s", rec.Body.String())
+	}
+}
+
+// TestDatabaseErrorHidesDetails verifica que un error de la base de datos responde un mensaje
+// generico con el request id
+func TestDatabaseErrorHidesDetails(t *testing.T) {
+	cfg := loadTestConfig(t)
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		t.Fatalf("Error connecting to MongoDB: %v", err)
+	}
+	coll := client.Database("testdb").Collection("books")
+	client.Disconnect(context.Background())
+
+	h := &handlers.Handler{Books: coll}
+	e := echo.New()
+	e.Use(logging.RequestIDMiddleware())
+	e.GET("/books", h.GetBooks)
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-db-error")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d but got %d", http.StatusInternalServerError, rec.Code)
+	}
+	var resp struct {
+		Message   string `json:"message"`
+		RequestId string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if resp.Message != "Error interno de la base de datos" || resp.RequestId != "req-db-error" {
+		t.Errorf("Unexpected response: %s", rec.Body.String())
+	}
+}
+
+// TestCreateLoanIgnoresServerFields verifica que CreateLoan exige un libro existente y no
+// acepta del cliente los campos que asigna el servidor
+func TestCreateLoanIgnoresServerFields(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	res, err := coll.InsertOne(ctx, models.Book{Title: "Prestable", Author: "A", Isbn: "LOAN1", Availability: 1})
+	if err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	bookId := res.InsertedID.(primitive.ObjectID).Hex()
+
+	loans := coll.Database().Collection("loans")
+	h := &handlers.Handler{Books: coll, Loans: loans}
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/loans", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := h.CreateLoan(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("Handler returned error: %v", err)
+		}
+		return rec
+	}
+
+	if rec := post(`{"name":"P","description":"D","user_id":"u1","book_id":"` + primitive.NewObjectID().Hex() + `"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown book: expected status %d but got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := post(`{"name":"P","description":"D","user_id":"u1","book_id":"no-es-un-id"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid book id: expected status %d but got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	forged := primitive.NewObjectID().Hex()
+	rec := post(`{"id":"` + forged + `","name":"P","description":"D","user_id":"u1","book_id":"` + bookId + `",` +
+		`"is_returned":true,"returned_at":"2020-01-01T00:00:00Z","renewals":5,"overdue_at":"2020-01-01T00:00:00Z"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var loan models.Loan
+	if err := loans.FindOne(ctx, bson.M{"book_id": bookId}).Decode(&loan); err != nil {
+		t.Fatalf("Loan not found: %v", err)
+	}
+	if loan.ID.Hex() == forged || loan.IsReturned || loan.ReturnedAt != nil || loan.Renewals != 0 || loan.OverdueAt != nil {
+		t.Errorf("Server fields taken from the request: %+v", loan)
+	}
+}
+
+// TestCreateLoanEnforcesLimitConcurrently verifica que las peticiones simultaneas no superan el
+// limite de prestamos activos y que devolver un prestamo libera su lugar
+func TestCreateLoanEnforcesLimitConcurrently(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	var registry []database.IndexSpec
+	for _, spec := range database.IndexRegistry {
+		if spec.Collection == database.LoansCollection {
+			registry = append(registry, spec)
+		}
+	}
+	if _, err := database.SyncIndexes(ctx, coll.Database(), registry, database.SyncOptions{}); err != nil {
+		t.Fatalf("SyncIndexes failed: %v", err)
+	}
+	res, err := coll.InsertOne(ctx, models.Book{Title: "Prestable", Author: "A", Isbn: "LIMIT1", Availability: 10})
+	if err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	bookId := res.InsertedID.(primitive.ObjectID).Hex()
+
+	loans := coll.Database().Collection("loans")
+	h := &handlers.Handler{Books: coll, Loans: loans, LoanPolicy: config.LoanPolicy{MaxActiveLoans: 2}}
+	post := func() int {
+		body := `{"name":"P","description":"D","user_id":"u1","book_id":"` + bookId + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/loans", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := h.CreateLoan(echo.New().NewContext(req, rec)); err != nil {
+			t.Errorf("Handler returned error: %v", err)
+		}
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- post()
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else if code != http.StatusConflict {
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+	if n, _ := loans.CountDocuments(ctx, bson.M{"user_id": "u1", "is_returned": false}); created != 2 || n != 2 {
+		t.Fatalf("Expected 2 active loans, got %d created and %d stored", created, n)
+	}
+
+	// Al devolver un prestamo el usuario puede pedir otro
+	var loan models.Loan
+	if err := loans.FindOne(ctx, bson.M{"user_id": "u1"}).Decode(&loan); err != nil {
+		t.Fatalf("Loan not found: %v", err)
+	}
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodPut, "/loans/"+loan.ID.Hex(), nil), httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues(loan.ID.Hex())
+	if err := h.ReturnLoan(c); err != nil {
+		t.Fatalf("ReturnLoan returned error: %v", err)
+	}
+	if code := post(); code != http.StatusCreated {
+		t.Errorf("Expected status %d after the return but got %d", http.StatusCreated, code)
+	}
+	if code := post(); code != http.StatusConflict {
+		t.Errorf("Expected status %d over the limit but got %d", http.StatusConflict, code)
+	}
+}
+
+// TestDeleteBookRecordsAudit verifica que DeleteBook registra la auditoria con el snapshot anterior
+func TestDeleteBookRecordsAudit(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	audit := coll.Database().Collection("audit_log")
+	h := &handlers.Handler{Books: coll, Audit: audit}
+	e := echo.New()
+
+	res, err := coll.InsertOne(context.Background(), models.Book{Title: "Audit", Author: "Test", Isbn: "AUD-1", Availability: 1})
+	if err != nil {
+		t.Fatalf("Error inserting book: %v", err)
+	}
+	id := res.InsertedID.(primitive.ObjectID).Hex()
+
+	req := httptest.NewRequest(http.MethodDelete, "/books/"+id, nil)
+	req.Header.Set("X-Actor", "tester")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	auth.SetPrincipal(c, &auth.Principal{ID: "admin", Kind: auth.KindAdmin})
+
+	if err := h.DeleteBook(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but got %d", http.StatusOK, rec.Code)
+	}
+
+	var entry models.AuditEntry
+	if err := audit.FindOne(context.Background(), bson.M{"entity_id": id}).Decode(&entry); err != nil {
+		t.Fatalf("Audit entry not found: %v", err)
+	}
+	if entry.Action != "delete" || entry.Actor != "admin:admin" {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+	if entry.Before["isbn"] != "AUD-1" || entry.After != nil {
+		t.Errorf("Unexpected snapshots: before=%v after=%v", entry.Before, entry.After)
+	}
+}
+
+// TestOutboxDispatcherRetriesUntilPublished verifica la entrega al menos una vez del outbox
+func TestOutboxDispatcherRetriesUntilPublished(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	outbox := coll.Database().Collection("outbox")
+	h := &handlers.Handler{Books: coll, Outbox: outbox}
+	e := echo.New()
+
+	body, _ := json.Marshal(models.Book{Title: "Outbox", Author: "Test", Isbn: "OUT-1", Availability: 1})
+	req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := h.CreateBook(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+
+	// El primer intento falla, el segundo publica
+	calls := 0
+	d := &events.Dispatcher{
+		Outbox: outbox,
+		Publisher: events.PublisherFunc(func(context.Context, events.Event) error {
+			calls++
+			if calls == 1 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		}),
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+
+	if _, err := d.DispatchOnce(context.Background()); err != nil {
+		t.Fatalf("Dispatch error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := d.DispatchOnce(context.Background()); err != nil {
+		t.Fatalf("Dispatch error: %v", err)
+	}
+
+	var evt events.Event
+	if err := outbox.FindOne(context.Background(), bson.M{"type": events.BookCreated}).Decode(&evt); err != nil {
+		t.Fatalf("Event not found: %v", err)
+	}
+	if evt.Status != events.StatusPublished || evt.Attempts != 2 {
+		t.Errorf("Expected published after 2 attempts, got status=%s attempts=%d", evt.Status, evt.Attempts)
+	}
+}
+
+// Un despachador cuyo lease vencio no sobrescribe el resultado del que reclamo el evento despues
+func TestOutboxDispatcherKeepsResultOfNewClaim(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	outbox := coll.Database().Collection("outbox")
+	evt := events.New(events.BookCreated, "b1", bson.M{}, "")
+	if _, err := outbox.InsertOne(context.Background(), evt); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	second := &events.Dispatcher{
+		Outbox:    outbox,
+		Owner:     "second",
+		Publisher: events.PublisherFunc(func(context.Context, events.Event) error { return nil }),
+	}
+	first := &events.Dispatcher{
+		Outbox: outbox,
+		Owner:  "first",
+		Lease:  time.Millisecond,
+		// Mientras el primero publica vence su lease y el segundo reclama y publica el evento
+		Publisher: events.PublisherFunc(func(ctx context.Context, _ events.Event) error {
+			time.Sleep(5 * time.Millisecond)
+			if n, err := second.DispatchOnce(ctx); err != nil || n != 1 {
+				t.Errorf("Second dispatcher processed %d events: %v", n, err)
+			}
+			return errors.New("slow receiver")
+		}),
+	}
+	if _, err := first.DispatchOnce(context.Background()); err != nil {
+		t.Fatalf("Dispatch error: %v", err)
+	}
+
+	var stored events.Event
+	if err := outbox.FindOne(context.Background(), bson.M{"_id": evt.ID}).Decode(&stored); err != nil {
+		t.Fatalf("Event not found: %v", err)
+	}
+	if stored.Status != events.StatusPublished || stored.Attempts != 1 || stored.LastError != "" {
+		t.Errorf("Expected the second result, got status=%s attempts=%d error=%q", stored.Status, stored.Attempts, stored.LastError)
+	}
+}
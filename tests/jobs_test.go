@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/events"
+	"backend/handlers"
+	"backend/jobs"
+	"backend/models"
+	"backend/routes"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Espera hasta que la ultima ejecucion de la tarea termine
+func waitForRun(t *testing.T, s *jobs.Scheduler, name string) *jobs.Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		run, err := s.LastRun(context.Background(), name)
+		if err != nil {
+			t.Fatalf("LastRun failed: %v", err)
+		}
+		if run != nil && run.State != jobs.StateRunning {
+			return run
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("La tarea %s no termino", name)
+	return nil
+}
+
+func TestSchedulerRejectsInvalidJobs(t *testing.T) {
+	s := &jobs.Scheduler{}
+	run := func(context.Context) (any, error) { return nil, nil }
+
+	if err := s.Add(jobs.Job{Name: "a", Schedule: "cada hora", Run: run}); err == nil {
+		t.Error("Esperado error con una expresion cron invalida")
+	}
+	if err := s.Add(jobs.Job{Name: "a", Schedule: "*/5 * * * *", Run: run}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := s.Add(jobs.Job{Name: "a", Schedule: "@hourly", Run: run}); err == nil {
+		t.Error("Esperado error con un nombre repetido")
+	}
+
+	info, ok := s.Job("a")
+	if !ok || info.NextRun.Minute()%5 != 0 || !info.NextRun.After(time.Now()) {
+		t.Errorf("Proxima ejecucion inesperada: %+v", info)
+	}
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db := coll.Database()
+	var runsA, runsB atomic.Int32
+	newScheduler := func(owner string, counter *atomic.Int32) *jobs.Scheduler {
+		s := &jobs.Scheduler{Runs: db.Collection("job_runs"), Locks: db.Collection("locks"), Owner: owner, LockTTL: 3 * time.Second}
+		err := s.Add(jobs.Job{Name: "tick", Schedule: "@every 200ms", Run: func(context.Context) (any, error) {
+			counter.Add(1)
+			return bson.M{"owner": owner}, nil
+		}})
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		return s
+	}
+	a, b := newScheduler("a", &runsA), newScheduler("b", &runsB)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneA, doneB := make(chan struct{}), make(chan struct{})
+	go func() { defer close(doneA); a.Run(ctx) }()
+	for deadline := time.Now().Add(2 * time.Second); !a.IsLeader() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	go func() { defer close(doneB); b.Run(ctx) }()
+
+	time.Sleep(1500 * time.Millisecond)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Errorf("Esperado solo a como lider: a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+	cancel()
+	<-doneA
+	<-doneB
+
+	if runsA.Load() == 0 || runsB.Load() != 0 {
+		t.Errorf("Esperadas ejecuciones solo en el lider: a=%d b=%d", runsA.Load(), runsB.Load())
+	}
+	history, err := a.History(context.Background(), "tick", 100)
+	if err != nil || len(history) != int(runsA.Load()) {
+		t.Fatalf("Historial inesperado (%v): %d ejecuciones para %d llamadas", err, len(history), runsA.Load())
+	}
+	for _, run := range history {
+		if run.Owner != "a" || run.Trigger != jobs.TriggerSchedule || run.State != jobs.StateSucceeded || run.Result["owner"] != "a" {
+			t.Errorf("Ejecucion inesperada: %+v", run)
+		}
+	}
+}
+
+func TestSchedulerManualTrigger(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	release := make(chan struct{})
+	newScheduler := func(owner string) *jobs.Scheduler {
+		s := &jobs.Scheduler{Runs: db.Collection("job_runs"), Locks: db.Collection("locks"), Owner: owner}
+		jobList := []jobs.Job{
+			{Name: "slow", Schedule: "@yearly", Run: func(context.Context) (any, error) {
+				<-release
+				return map[string]int{"processed": 3}, nil
+			}},
+			{Name: "broken", Schedule: "@yearly", Run: func(context.Context) (any, error) {
+				return nil, errors.New("sin conexion")
+			}},
+			{Name: "panics", Schedule: "@yearly", Run: func(context.Context) (any, error) {
+				panic("inesperado")
+			}},
+		}
+		for _, job := range jobList {
+			if err := s.Add(job); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		return s
+	}
+	a, b := newScheduler("a"), newScheduler("b")
+
+	if _, err := a.Trigger(ctx, "desconocida"); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("Esperado ErrNotFound, obtuvo %v", err)
+	}
+
+	run, err := a.Trigger(ctx, "slow")
+	if err != nil || run.State != jobs.StateRunning || run.Trigger != jobs.TriggerManual {
+		t.Fatalf("Trigger inesperado %+v: %v", run, err)
+	}
+	// La misma tarea no se superpone en esta ni en otra instancia
+	if _, err := a.Trigger(ctx, "slow"); !errors.Is(err, jobs.ErrRunning) {
+		t.Errorf("Esperado ErrRunning en la misma instancia, obtuvo %v", err)
+	}
+	if _, err := b.Trigger(ctx, "slow"); !errors.Is(err, jobs.ErrRunning) {
+		t.Errorf("Esperado ErrRunning en otra instancia, obtuvo %v", err)
+	}
+	close(release)
+
+	last := waitForRun(t, a, "slow")
+	if last.ID != run.ID || last.State != jobs.StateSucceeded || last.FinishedAt == nil || last.Result["processed"] != int32(3) {
+		t.Errorf("Ejecucion inesperada: %+v", last)
+	}
+	if _, err := b.Trigger(ctx, "slow"); err != nil {
+		t.Errorf("Esperado poder lanzar la tarea terminada en otra instancia: %v", err)
+	}
+	waitForRun(t, b, "slow")
+
+	for _, name := range []string{"broken", "panics"} {
+		if _, err := a.Trigger(ctx, name); err != nil {
+			t.Fatalf("Trigger %s failed: %v", name, err)
+		}
+		if last := waitForRun(t, a, name); last.State != jobs.StateFailed || last.Error == "" {
+			t.Errorf("Esperada ejecucion fallida de %s: %+v", name, last)
+		}
+	}
+}
+
+func TestJobEndpoints(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	h := &handlers.Handler{
+		Books:  coll,
+		Users:  db.Collection("users"),
+		Loans:  db.Collection("loans"),
+		Holds:  db.Collection("holds"),
+		Outbox: db.Collection("outbox"),
+		Jobs:   &jobs.Scheduler{Runs: db.Collection("job_runs"), Locks: db.Collection("locks")},
+	}
+	h.LoanPolicy.HoldPickupDays = 3
+	for _, job := range []jobs.Job{
+		{Name: "overdue", Description: "Marca los prestamos vencidos", Schedule: "*/15 * * * *", Run: h.MarkOverdueLoans},
+		{Name: "hold_expiry", Description: "Vence las reservas no retiradas", Schedule: "0 * * * *", Run: h.ExpireHolds},
+	} {
+		if err := h.Jobs.Add(job); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	e := echo.New()
+	routes.Register(e, h, routes.Options{AdminToken: "admin-secret"})
+
+	now := time.Now().UTC()
+	day := 24 * time.Hour
+	book := primitive.NewObjectID().Hex()
+	res, err := h.Loans.InsertMany(ctx, []any{
+		models.Loan{Name: "vencido", Description: "d", UserId: "u1", BookId: book, CreatedAt: now.Add(-20 * day), DueDate: now.Add(-day)},
+		models.Loan{Name: "al dia", Description: "d", UserId: "u1", BookId: book, CreatedAt: now, DueDate: now.Add(day)},
+		models.Loan{Name: "devuelto", Description: "d", UserId: "u1", BookId: book, IsReturned: true, CreatedAt: now.Add(-20 * day), DueDate: now.Add(-day)},
+	})
+	if err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+	overdueId := res.InsertedIDs[0].(primitive.ObjectID)
+	readyAt, staleAt := now.Add(-day), now.Add(-5*day)
+	stale, fresh, waiting := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := h.Holds.InsertMany(ctx, []any{
+		models.Hold{ID: stale, UserId: "u1", BookId: book, Status: models.HoldReady, CreatedAt: now.Add(-10 * day), ReadyAt: &staleAt},
+		models.Hold{ID: fresh, UserId: "u2", BookId: "otro", Status: models.HoldReady, CreatedAt: now.Add(-2 * day), ReadyAt: &readyAt},
+		models.Hold{ID: waiting, UserId: "u3", BookId: book, Status: models.HoldWaiting, CreatedAt: now.Add(-3 * day)},
+	}); err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+
+	do := func(method, path string) (int, json.RawMessage) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Data
+	}
+
+	var list struct {
+		Jobs []jobs.Info `json:"jobs"`
+	}
+	code, data := do(http.MethodGet, "/api/v1/jobs")
+	if json.Unmarshal(data, &list); code != http.StatusOK || len(list.Jobs) != 2 || list.Jobs[0].Name != "hold_expiry" || list.Jobs[0].LastRun != nil {
+		t.Fatalf("Lista de tareas inesperada %d: %s", code, data)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/jobs/desconocida/run"); code != http.StatusNotFound {
+		t.Errorf("Esperado 404 con una tarea desconocida, obtuvo %d", code)
+	}
+
+	// Prestamos vencidos: se marcan una sola vez
+	var run jobs.Run
+	code, data = do(http.MethodPost, "/api/v1/jobs/overdue/run")
+	if json.Unmarshal(data, &run); code != http.StatusAccepted || run.Job != "overdue" || run.State != jobs.StateRunning {
+		t.Fatalf("Ejecucion inesperada %d: %s", code, data)
+	}
+	if last := waitForRun(t, h.Jobs, "overdue"); last.State != jobs.StateSucceeded || last.Result["marked"] != int32(1) {
+		t.Errorf("Resultado inesperado: %+v", last)
+	}
+	var loan models.Loan
+	if err := h.Loans.FindOne(ctx, bson.M{"_id": overdueId}).Decode(&loan); err != nil || loan.OverdueAt == nil {
+		t.Errorf("Esperado el prestamo marcado vencido: %+v (%v)", loan, err)
+	}
+	if n, _ := h.Loans.CountDocuments(ctx, bson.M{"overdue_at": bson.M{"$exists": true}}); n != 1 {
+		t.Errorf("Esperado un solo prestamo marcado, obtuvo %d", n)
+	}
+	do(http.MethodPost, "/api/v1/jobs/overdue/run")
+	if last := waitForRun(t, h.Jobs, "overdue"); last.Result["marked"] != int32(0) {
+		t.Errorf("Esperado ningun prestamo marcado otra vez: %+v", last)
+	}
+
+	// Reservas: vence la no retirada y pasa el libro a la siguiente en espera
+	do(http.MethodPost, "/api/v1/jobs/hold_expiry/run")
+	if last := waitForRun(t, h.Jobs, "hold_expiry"); last.State != jobs.StateSucceeded || last.Result["expired"] != int32(1) {
+		t.Errorf("Resultado inesperado: %+v", last)
+	}
+	for id, status := range map[primitive.ObjectID]string{stale: models.HoldExpired, fresh: models.HoldReady, waiting: models.HoldReady} {
+		var hold models.Hold
+		if err := h.Holds.FindOne(ctx, bson.M{"_id": id}).Decode(&hold); err != nil || hold.Status != status {
+			t.Errorf("Esperada la reserva %s en %s: %+v (%v)", id.Hex(), status, hold, err)
+		}
+	}
+	for _, typ := range []string{events.LoanOverdue, events.HoldExpired, events.HoldReady} {
+		if n, _ := h.Outbox.CountDocuments(ctx, bson.M{"type": typ}); n != 1 {
+			t.Errorf("Esperado un evento %s, obtuvo %d", typ, n)
+		}
+	}
+
+	var runs []jobs.Run
+	code, data = do(http.MethodGet, "/api/v1/jobs/overdue/runs?limit=1")
+	if json.Unmarshal(data, &runs); code != http.StatusOK || len(runs) != 1 || runs[0].ID == run.ID {
+		t.Errorf("Historial inesperado %d: %s", code, data)
+	}
+	if code, _ := do(http.MethodGet, "/api/v1/jobs/overdue/runs?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("Esperado 400 con un limite invalido, obtuvo %d", code)
+	}
+
+	var info jobs.Info
+	code, data = do(http.MethodGet, "/api/v1/jobs/hold_expiry")
+	if json.Unmarshal(data, &info); code != http.StatusOK || info.LastRun == nil || !strings.Contains(info.Description, "reservas") {
+		t.Errorf("Tarea inesperada %d: %s", code, data)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/handlers"
+	"backend/logging"
+	"backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequestIDPropagatedToErrorResponse(t *testing.T) {
+	e := echo.New()
+	e.Use(logging.RequestIDMiddleware())
+	h := &handlers.Handler{}
+	e.POST("/books", h.CreateBook)
+
+	book := models.Book{Title: "", Author: "Sin titulo", Isbn: "ISBN-1", Availability: 1}
+	body, _ := json.Marshal(book)
+	req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRequestID, "req-123")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Esperado 400, obtuvo %d", rec.Code)
+	}
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "req-123" {
+		t.Errorf("Esperado X-Request-ID req-123, obtuvo %q", got)
+	}
+
+	var res map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["request_id"] != "req-123" {
+		t.Errorf("Esperado request_id req-123, obtuvo %v", res["request_id"])
+	}
+}
+
+func TestRequestIDGeneratedAndLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New("info", &buf)
+
+	e := echo.New()
+	e.Use(logging.RequestIDMiddleware())
+	e.Use(logging.AccessLog(logger))
+	e.GET("/ping", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	id := rec.Header().Get(echo.HeaderXRequestID)
+	if id == "" {
+		t.Fatal("Esperaba un X-Request-ID generado")
+	}
+
+	var entry map[string]any
+	line := strings.TrimSpace(buf.String())
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("El log no es JSON: %q", line)
+	}
+	if entry["request_id"] != id {
+		t.Errorf("Esperado request_id %s en el log, obtuvo %v", id, entry["request_id"])
+	}
+	if entry["route"] != "/ping" {
+		t.Errorf("Esperado route /ping, obtuvo %v", entry["route"])
+	}
+}
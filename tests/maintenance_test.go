@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/database"
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRecomputeAvailabilityFromOpenLoans(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	loans := coll.Database().Collection("loans")
+	res, _ := coll.InsertOne(ctx, models.Book{Title: "A", Author: "A", Isbn: "1", Availability: 3})
+	bookId := res.InsertedID.(primitive.ObjectID)
+	_, _ = coll.InsertOne(ctx, models.Book{Title: "B", Author: "B", Isbn: "2", Availability: 5, Copies: 2})
+
+	now := time.Now().UTC()
+	_, _ = loans.InsertMany(ctx, []interface{}{
+		models.Loan{Name: "1", BookId: bookId.Hex(), DueDate: now.Add(-48 * time.Hour)},
+		models.Loan{Name: "2", BookId: bookId.Hex(), DueDate: now.Add(48 * time.Hour)},
+		models.Loan{Name: "3", BookId: bookId.Hex(), IsReturned: true, DueDate: now.Add(-48 * time.Hour)},
+	})
+
+	changes, err := database.RecomputeAvailability(ctx, coll, loans, true)
+	if err != nil {
+		t.Fatalf("Recompute error: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	var book models.Book
+	_ = coll.FindOne(ctx, bson.M{"_id": bookId}).Decode(&book)
+	if book.Availability != 3 {
+		t.Fatal("Dry run modified the book")
+	}
+
+	if _, err := database.RecomputeAvailability(ctx, coll, loans, false); err != nil {
+		t.Fatalf("Recompute error: %v", err)
+	}
+	_ = coll.FindOne(ctx, bson.M{"_id": bookId}).Decode(&book)
+	if book.Availability != 1 || book.Copies != 3 {
+		t.Errorf("Expected availability 1 of 3 copies, got %+v", book)
+	}
+	_ = coll.FindOne(ctx, bson.M{"isbn": "2"}).Decode(&book)
+	if book.Availability != 2 {
+		t.Errorf("Expected availability capped by copies, got %+v", book)
+	}
+
+	// Una segunda ejecucion no cambia nada
+	if changes, _ := database.RecomputeAvailability(ctx, coll, loans, false); len(changes) != 0 {
+		t.Errorf("Expected idempotent recompute, got %+v", changes)
+	}
+
+	overdue, err := database.OverdueLoans(ctx, loans, now)
+	if err != nil || len(overdue) != 1 || overdue[0].Name != "1" {
+		t.Errorf("Unexpected overdue loans: %+v (%v)", overdue, err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	_, _ = coll.InsertOne(ctx, models.Book{Title: "A", Author: "A", Isbn: "1", Availability: 1})
+	_, _ = db.Collection("users").InsertOne(ctx, models.User{Name: "Ana", Email: "ana@example.com"})
+
+	dump, err := database.Export(ctx, db)
+	if err != nil {
+		t.Fatalf("Export error: %v", err)
+	}
+	dump.Books[0].Title = "Cambiado"
+	dump.Loans = append(dump.Loans, models.Loan{Name: "Nuevo", BookId: dump.Books[0].ID.Hex()})
+
+	counts, err := database.Import(ctx, db, dump)
+	if err != nil {
+		t.Fatalf("Import error: %v", err)
+	}
+	if counts != (database.ImportCounts{Books: 1, Users: 1, Loans: 1}) {
+		t.Errorf("Unexpected counts: %+v", counts)
+	}
+
+	// Los documentos con id se reemplazan, no se duplican
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("Expected 1 book, got %d", n)
+	}
+	var book models.Book
+	_ = coll.FindOne(ctx, bson.M{}).Decode(&book)
+	if book.Title != "Cambiado" {
+		t.Errorf("Book not replaced: %+v", book)
+	}
+}
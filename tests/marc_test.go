@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/handlers"
+	"backend/marc"
+	"backend/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func marcRecord(title, author, isbn string) marc.Record {
+	return marc.Record{Fields: []marc.Field{
+		{Tag: "001", Value: "ocm" + isbn},
+		{Tag: "020", Ind1: " ", Ind2: " ", Subfields: []marc.Subfield{{Code: "a", Value: isbn + " (pbk.)"}}},
+		{Tag: "100", Ind1: "1", Ind2: " ", Subfields: []marc.Subfield{{Code: "a", Value: author + ","}}},
+		{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []marc.Subfield{{Code: "a", Value: title + " /"}, {Code: "c", Value: author}}},
+	}}
+}
+
+const marcXML = `<?xml version="1.0" encoding="UTF-8"?>
+<marc:collection xmlns:marc="http://www.loc.gov/MARC21/slim">
+  <marc:record>
+    <marc:leader>00000nam a2200000 a 4500</marc:leader>
+    <marc:controlfield tag="001">12345</marc:controlfield>
+    <marc:datafield tag="020" ind1=" " ind2=" "><marc:subfield code="a">978-0-307-47472-8</marc:subfield></marc:datafield>
+    <marc:datafield tag="100" ind1="1" ind2=" "><marc:subfield code="a">Cortázar, Julio,</marc:subfield></marc:datafield>
+    <marc:datafield tag="245" ind1="1" ind2="0">
+      <marc:subfield code="a">Rayuela :</marc:subfield>
+      <marc:subfield code="b">novela /</marc:subfield>
+    </marc:datafield>
+  </marc:record>
+</marc:collection>`
+
+func TestMARC21BinaryRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(marc.Marshal(marcRecord("Ficciones", "Borges, Jorge Luis", "9788499089515")))
+	buf.Write(marc.Marshal(marcRecord("Pedro Páramo", "Rulfo, Juan", "9788437604183")))
+
+	reader := marc.NewReader(&buf)
+	var books []models.Book
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		books = append(books, marc.ToBook(rec, marc.DefaultRules))
+	}
+
+	if len(books) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(books))
+	}
+	want := models.Book{Title: "Pedro Páramo", Author: "Rulfo, Juan", Isbn: "9788437604183"}
+	if books[1] != want {
+		t.Errorf("Expected %+v, got %+v", want, books[1])
+	}
+}
+
+func TestMARC21RejectsMalformedDirectory(t *testing.T) {
+	// Longitud y posicion negativas en la primera entrada del directorio
+	var buf bytes.Buffer
+	for _, bad := range []string{"-001", "+001"} {
+		raw := marc.Marshal(marcRecord("Ficciones", "Borges, Jorge Luis", "9788499089515"))
+		copy(raw[27:31], bad)
+		buf.Write(raw)
+	}
+	raw := marc.Marshal(marcRecord("Ficciones", "Borges, Jorge Luis", "9788499089515"))
+	copy(raw[31:36], "-0001")
+	buf.Write(raw)
+	buf.Write(marc.Marshal(marcRecord("Pedro Páramo", "Rulfo, Juan", "9788437604183")))
+
+	reader := marc.NewReader(&buf)
+	for i := 0; i < 3; i++ {
+		var recErr *marc.RecordError
+		if _, err := reader.Next(); !errors.As(err, &recErr) {
+			t.Fatalf("Record %d: expected a record error, got %v", i, err)
+		}
+	}
+	rec, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Read error after malformed records: %v", err)
+	}
+	if book := marc.ToBook(rec, marc.DefaultRules); book.Title != "Pedro Páramo" {
+		t.Errorf("Unexpected record after malformed ones: %+v", book)
+	}
+}
+
+func TestMARCXMLMappingWithExtraRule(t *testing.T) {
+	rec, err := marc.NewXMLReader(strings.NewReader(marcXML)).Next()
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+
+	// Una regla adicional mapea el numero de control a la disponibilidad
+	rules := append(append([]marc.Rule{}, marc.DefaultRules...), marc.Rule{
+		Tag:   "001",
+		Apply: func(b *models.Book, v string) { b.Availability = len(v) },
+	})
+	book := marc.ToBook(rec, rules)
+
+	want := models.Book{Title: "Rayuela : novela", Author: "Cortázar, Julio", Isbn: "9780307474728", Availability: 5}
+	if book != want {
+		t.Errorf("Expected %+v, got %+v", want, book)
+	}
+}
+
+func TestImportBooksMARCDetectsDuplicatesAndDryRun(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := coll.InsertOne(ctx, models.Book{Title: "Existente", Author: "A", Isbn: "9788499089515", Availability: 1}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	var file bytes.Buffer
+	file.Write(marc.Marshal(marcRecord("Ficciones", "Borges, Jorge Luis", "9788499089515")))
+	file.Write(marc.Marshal(marcRecord("Pedro Páramo", "Rulfo, Juan", "9788437604183")))
+	file.Write(marc.Marshal(marcRecord("Pedro Páramo", "Rulfo, Juan", "9788437604183")))
+	file.Write(marc.Marshal(marcRecord("", "Sin titulo", "9780000000002")))
+
+	h := &handlers.Handler{Books: coll}
+	e := echo.New()
+	run := func(query string) handlers.ImportReport {
+		req := httptest.NewRequest(http.MethodPost, "/books/import/marc"+query, bytes.NewReader(file.Bytes()))
+		rec := httptest.NewRecorder()
+		if err := h.ImportBooksMARC(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("Handler returned error: %v", err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Data handlers.ImportReport `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return resp.Data
+	}
+
+	dry := run("?dry_run=true")
+	if !dry.DryRun || dry.Created != 1 || dry.Duplicates != 2 || dry.Rejected != 1 {
+		t.Fatalf("Unexpected dry run report: %+v", dry)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Fatalf("Dry run wrote to the catalog: %d books", n)
+	}
+
+	report := run("")
+	if report.Created != 1 || report.Duplicates != 2 || report.Rows[1].Id == "" {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	var book models.Book
+	if err := coll.FindOne(ctx, bson.M{"isbn": "9788437604183"}).Decode(&book); err != nil {
+		t.Fatalf("Book not imported: %v", err)
+	}
+	if book.Title != "Pedro Páramo" || book.Availability != 1 {
+		t.Errorf("Unexpected book: %+v", book)
+	}
+}
+
+// Un choque con un indice unico que la consulta previa no detecta se informa como duplicado
+// y la importacion sigue con los registros siguientes
+func TestImportBooksMARCContinuesOnDuplicateKey(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "title", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if _, err := coll.InsertOne(ctx, models.Book{Title: "Ficciones", Author: "A", Isbn: "9780000000001", Availability: 1}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	var file bytes.Buffer
+	file.Write(marc.Marshal(marcRecord("Ficciones", "Borges, Jorge Luis", "9788499089515")))
+	file.Write(marc.Marshal(marcRecord("Pedro Páramo", "Rulfo, Juan", "9788437604183")))
+
+	h := &handlers.Handler{Books: coll}
+	req := httptest.NewRequest(http.MethodPost, "/books/import/marc", bytes.NewReader(file.Bytes()))
+	rec := httptest.NewRecorder()
+	if err := h.ImportBooksMARC(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data handlers.ImportReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if resp.Data.Created != 1 || resp.Data.Duplicates != 1 || resp.Data.Rows[0].Status != handlers.RowDuplicate {
+		t.Errorf("Unexpected report: %+v", resp.Data)
+	}
+}
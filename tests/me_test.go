@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+	"backend/routes"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMeEndpoints(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	h := &handlers.Handler{
+		Books:      coll,
+		Users:      db.Collection("users"),
+		Loans:      db.Collection("loans"),
+		Holds:      db.Collection("holds"),
+		Tokens:     &auth.TokenSigner{Secret: []byte(strings.Repeat("s", 32)), TTL: time.Hour},
+		LoanPolicy: config.LoanPolicy{LoanDays: 14, MaxRenewals: 1, FinePerDay: 25, MaxFine: 100},
+	}
+	e := echo.New()
+	routes.Register(e, h, routes.Options{
+		AdminToken: "admin-secret",
+		Auth:       &auth.Authenticator{Tokens: h.Tokens, AdminToken: "admin-secret"},
+	})
+
+	ana := primitive.NewObjectID()
+	beto := primitive.NewObjectID()
+	for _, u := range []models.User{{ID: ana, Name: "Ana", Email: "ana@example.com"}, {ID: beto, Name: "Beto", Email: "beto@example.com"}} {
+		if _, err := h.Users.InsertOne(ctx, u); err != nil {
+			t.Fatalf("InsertOne failed: %v", err)
+		}
+	}
+	// El unico ejemplar de Rayuela esta prestado a Beto
+	book := primitive.NewObjectID()
+	available := primitive.NewObjectID()
+	for _, b := range []models.Book{
+		{ID: book, Title: "Rayuela", Author: "Cortazar", Availability: 0},
+		{ID: available, Title: "Ficciones", Author: "Borges", Availability: 2},
+	} {
+		if _, err := coll.InsertOne(ctx, b); err != nil {
+			t.Fatalf("InsertOne failed: %v", err)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	day := 24 * time.Hour
+	returnedAt := now.Add(-5*day + time.Hour)
+	loans := []models.Loan{
+		{Name: "abierto", Description: "d", UserId: ana.Hex(), BookId: primitive.NewObjectID().Hex(), CreatedAt: now, DueDate: now.Add(7 * day)},
+		{Name: "vencido", Description: "d", UserId: ana.Hex(), BookId: primitive.NewObjectID().Hex(), CreatedAt: now.Add(-20 * day), DueDate: now.Add(-3*day - time.Hour)},
+		{Name: "devuelto", Description: "d", UserId: ana.Hex(), BookId: primitive.NewObjectID().Hex(), IsReturned: true, CreatedAt: now.Add(-30 * day), DueDate: now.Add(-10 * day), ReturnedAt: &returnedAt},
+		{Name: "ajeno", Description: "d", UserId: beto.Hex(), BookId: book.Hex(), CreatedAt: now, DueDate: now.Add(7 * day)},
+	}
+	ids := make([]string, len(loans))
+	for i, loan := range loans {
+		res, err := h.Loans.InsertOne(ctx, loan)
+		if err != nil {
+			t.Fatalf("InsertOne failed: %v", err)
+		}
+		ids[i] = res.InsertedID.(primitive.ObjectID).Hex()
+	}
+
+	token := func(id primitive.ObjectID) []string {
+		tok, _, err := h.Tokens.Issue(id.Hex())
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		return []string{echo.HeaderAuthorization, "Bearer " + tok}
+	}
+	asAna, asBeto := token(ana), token(beto)
+
+	do := func(method, path, body string, header []string) (int, json.RawMessage) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if header != nil {
+			req.Header.Set(header[0], header[1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var res struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return rec.Code, res.Data
+	}
+
+	// Solo los tokens de usuario acceden a /me
+	if code, _ := do(http.MethodGet, "/api/v1/me", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("Esperado 401 sin credenciales, obtuvo %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/api/v1/me", "", []string{echo.HeaderAuthorization, "Bearer admin-secret"}); code != http.StatusForbidden {
+		t.Errorf("Esperado 403 con el token de administrador, obtuvo %d", code)
+	}
+
+	var user models.User
+	code, data := do(http.MethodGet, "/api/v1/me", "", asAna)
+	if json.Unmarshal(data, &user); code != http.StatusFound || user.Email != "ana@example.com" {
+		t.Errorf("Perfil inesperado %d: %s", code, data)
+	}
+
+	var current, history []models.Loan
+	code, data = do(http.MethodGet, "/api/v2/me/loans", "", asAna)
+	if json.Unmarshal(data, &current); code != http.StatusOK || len(current) != 2 || current[0].Name != "vencido" {
+		t.Errorf("Prestamos actuales inesperados %d: %s", code, data)
+	}
+	code, data = do(http.MethodGet, "/me/history", "", asAna)
+	if json.Unmarshal(data, &history); code != http.StatusOK || len(history) != 1 || history[0].Name != "devuelto" {
+		t.Errorf("Historial inesperado %d: %s", code, data)
+	}
+
+	// 3 dias de atraso del prestamo abierto y 5 del devuelto, con el tope por prestamo
+	var fines struct {
+		Total int `json:"total_cents"`
+		Loans []struct {
+			LoanId string `json:"loan_id"`
+			Days   int    `json:"days_overdue"`
+			Amount int    `json:"amount_cents"`
+		} `json:"loans"`
+	}
+	code, data = do(http.MethodGet, "/api/v1/me/fines", "", asAna)
+	if json.Unmarshal(data, &fines); code != http.StatusOK || fines.Total != 175 || len(fines.Loans) != 2 {
+		t.Fatalf("Multas inesperadas %d: %s", code, data)
+	}
+	if fines.Loans[0].LoanId != ids[2] || fines.Loans[0].Days != 5 || fines.Loans[0].Amount != 100 || fines.Loans[1].Days != 3 || fines.Loans[1].Amount != 75 {
+		t.Errorf("Detalle de multas inesperado: %s", data)
+	}
+
+	// Renovaciones: solo prestamos propios, abiertos, al dia y dentro del maximo
+	if code, _ := do(http.MethodPost, "/api/v1/me/loans/"+ids[3]+"/renew", "", asAna); code != http.StatusNotFound {
+		t.Errorf("Esperado 404 con un prestamo ajeno, obtuvo %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/loans/"+ids[1]+"/renew", "", asAna); code != http.StatusConflict {
+		t.Errorf("Esperado 409 con un prestamo vencido, obtuvo %d", code)
+	}
+	var renewed models.Loan
+	code, data = do(http.MethodPost, "/api/v1/me/loans/"+ids[0]+"/renew", "", asAna)
+	if json.Unmarshal(data, &renewed); code != http.StatusCreated || renewed.Renewals != 1 || !renewed.DueDate.Equal(loans[0].DueDate.AddDate(0, 0, 14)) {
+		t.Errorf("Renovacion inesperada %d: %s", code, data)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/loans/"+ids[0]+"/renew", "", asAna); code != http.StatusConflict {
+		t.Errorf("Esperado 409 al superar el maximo de renovaciones, obtuvo %d", code)
+	}
+
+	// Reservas
+	var hold models.Hold
+	code, data = do(http.MethodPost, "/api/v1/me/holds", `{"book_id":"`+book.Hex()+`"}`, asAna)
+	if json.Unmarshal(data, &hold); code != http.StatusCreated || hold.Status != models.HoldWaiting || hold.UserId != ana.Hex() {
+		t.Fatalf("Reserva inesperada %d: %s", code, data)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/holds", `{"book_id":"`+book.Hex()+`"}`, asAna); code != http.StatusConflict {
+		t.Errorf("Esperado 409 con una reserva vigente, obtuvo %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/holds", `{"book_id":"`+primitive.NewObjectID().Hex()+`"}`, asAna); code != http.StatusNotFound {
+		t.Errorf("Esperado 404 con un libro inexistente, obtuvo %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/holds", `{"book_id":"`+available.Hex()+`"}`, asAna); code != http.StatusConflict {
+		t.Errorf("Esperado 409 con ejemplares disponibles, obtuvo %d", code)
+	}
+
+	// El libro reservado por Ana no se puede renovar
+	if code, _ := do(http.MethodPost, "/api/v1/me/loans/"+ids[3]+"/renew", "", asBeto); code != http.StatusConflict {
+		t.Errorf("Esperado 409 con un libro reservado, obtuvo %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/v1/me/holds/"+hold.ID.Hex(), "", asBeto); code != http.StatusNotFound {
+		t.Errorf("Esperado 404 al cancelar una reserva ajena, obtuvo %d", code)
+	}
+
+	var holds []models.Hold
+	code, data = do(http.MethodGet, "/api/v1/me/holds", "", asAna)
+	if json.Unmarshal(data, &holds); code != http.StatusOK || len(holds) != 1 {
+		t.Errorf("Reservas inesperadas %d: %s", code, data)
+	}
+	code, data = do(http.MethodDelete, "/api/v1/me/holds/"+hold.ID.Hex(), "", asAna)
+	if json.Unmarshal(data, &hold); code != http.StatusOK || hold.Status != models.HoldCanceled || hold.CanceledAt == nil {
+		t.Errorf("Cancelacion inesperada %d: %s", code, data)
+	}
+	code, data = do(http.MethodGet, "/api/v1/me/holds", "", asAna)
+	if json.Unmarshal(data, &holds); code != http.StatusOK || len(holds) != 0 {
+		t.Errorf("Esperado ninguna reserva vigente, obtuvo %s", data)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/me/loans/"+ids[3]+"/renew", "", asBeto); code != http.StatusCreated {
+		t.Errorf("Esperado 201 sin reservas, obtuvo %d", code)
+	}
+
+	// La devolucion registra la fecha y pasa el prestamo al historial
+	admin := []string{echo.HeaderAuthorization, "Bearer admin-secret"}
+	if code, _ := do(http.MethodPut, "/api/v1/return-loan/"+ids[0], "", admin); code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d", code)
+	}
+	code, data = do(http.MethodGet, "/api/v1/me/history", "", asAna)
+	if json.Unmarshal(data, &history); code != http.StatusOK || len(history) != 2 || history[0].ReturnedAt == nil {
+		t.Errorf("Historial inesperado %d: %s", code, data)
+	}
+}
+
+// Las reservas simultaneas del mismo usuario sobre el mismo libro crean una sola reserva
+func TestPlaceMyHoldConcurrently(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	holds := db.Collection("holds")
+	if _, err := holds.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "active_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	}); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	book := primitive.NewObjectID()
+	if _, err := coll.InsertOne(ctx, models.Book{ID: book, Title: "Rayuela", Author: "Cortazar", Availability: 0}); err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+
+	h := &handlers.Handler{Books: coll, Holds: holds}
+	e := echo.New()
+	var wg sync.WaitGroup
+	codes := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/me/holds", strings.NewReader(`{"book_id":"`+book.Hex()+`"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			auth.SetPrincipal(c, &auth.Principal{ID: "ana", Kind: auth.KindUser})
+			if err := h.PlaceMyHold(c); err != nil {
+				t.Errorf("Handler returned error: %v", err)
+			}
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else if code != http.StatusConflict {
+			t.Errorf("Estado inesperado %d", code)
+		}
+	}
+	if n, _ := holds.CountDocuments(ctx, bson.M{}); created != 1 || n != 1 {
+		t.Errorf("Esperado 1 reserva, %d creadas y %d guardadas", created, n)
+	}
+}
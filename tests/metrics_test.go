@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/metrics"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMetricsRecordsRouteTemplateAndStatus(t *testing.T) {
+	m := metrics.New()
+	e := echo.New()
+	e.Use(m.Middleware())
+	e.GET("/books/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusNotFound)
+	})
+	e.GET("/metrics", m.Handler())
+
+	req := httptest.NewRequest(http.MethodGet, "/books/abc123", nil)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	m.LoanCreated()
+	m.LoanCreated()
+	m.LoanReturned()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Esperado 200, obtuvo %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`library_http_request_duration_seconds_count{method="GET",route="/books/:id",status="404"} 1`,
+		`library_loans_created_total 2`,
+		`library_loans_returned_total 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("No se encontró %q en /metrics", want)
+		}
+	}
+	if strings.Contains(body, "abc123") {
+		t.Error("La métrica no debe incluir el id de la ruta")
+	}
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/database"
+	"backend/migrations"
+	"backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMigrationsUpAndDown(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	loans := db.Collection("loans")
+	_, _ = coll.InsertOne(ctx, models.Book{Title: "A", Author: "A", Isbn: "978-0-307-47472-8", Availability: 1})
+	oldLoan := primitive.NewObjectIDFromTimestamp(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+	_, _ = loans.InsertOne(ctx, bson.M{"_id": oldLoan, "name": "viejo", "is_returned": false})
+
+	runner := &migrations.Runner{DB: db, Owner: "test"}
+	applied, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up error: %v", err)
+	}
+	if len(applied) != len(migrations.All) {
+		t.Fatalf("Expected %d migrations, got %+v", len(migrations.All), applied)
+	}
+
+	var loan models.Loan
+	_ = loans.FindOne(ctx, bson.M{"_id": oldLoan}).Decode(&loan)
+	if !loan.CreatedAt.Equal(oldLoan.Timestamp()) || !loan.DueDate.Equal(oldLoan.Timestamp().AddDate(0, 0, 14)) {
+		t.Errorf("Loan timestamps not backfilled: %+v", loan)
+	}
+	var book models.Book
+	_ = coll.FindOne(ctx, bson.M{}).Decode(&book)
+	if book.Isbn != "9780307474728" {
+		t.Errorf("Isbn not normalized: %q", book.Isbn)
+	}
+
+	// Una segunda ejecucion no aplica nada
+	if again, err := runner.Up(ctx); err != nil || len(again) != 0 {
+		t.Errorf("Expected no pending migrations, got %+v (%v)", again, err)
+	}
+
+	reverted, err := runner.Down(ctx, len(migrations.All))
+	if err != nil || len(reverted) != len(migrations.All) {
+		t.Fatalf("Down error: %+v (%v)", reverted, err)
+	}
+	_ = coll.FindOne(ctx, bson.M{}).Decode(&book)
+	raw, _ := loans.FindOne(ctx, bson.M{"_id": oldLoan}).Raw()
+	if book.Isbn != "978-0-307-47472-8" || raw.Lookup("created_at").Type != 0 {
+		t.Errorf("Down did not restore documents: isbn=%q loan=%s", book.Isbn, raw)
+	}
+
+	status, _ := runner.Status(ctx)
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			t.Errorf("Migration %d still applied", s.Version)
+		}
+	}
+}
+
+func TestMigrationsWaitForLock(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	other := &database.Lock{Coll: db.Collection(database.LocksCollection), Name: migrations.LockName, Owner: "otra", TTL: time.Minute}
+	if ok, err := other.Acquire(ctx); !ok || err != nil {
+		t.Fatalf("Acquire failed: %v %v", ok, err)
+	}
+
+	// Mientras otra instancia tiene el bloqueo no se migra
+	runner := &migrations.Runner{DB: db, Owner: "test", LockPoll: 10 * time.Millisecond}
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := runner.Up(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected to wait for the lock, got %v", err)
+	}
+	if n, _ := db.Collection(migrations.Collection).CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Fatalf("Migrations applied while locked: %d", n)
+	}
+
+	// Al liberarlo la migracion continua y el bloqueo queda libre al terminar
+	if err := other.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := runner.Up(ctx); err != nil {
+		t.Fatalf("Up error: %v", err)
+	}
+	if state, _ := other.State(ctx); state != nil {
+		t.Errorf("Lock not released: %+v", state)
+	}
+}
+
+// Los libros que quedarian con el mismo isbn no detienen la migracion y se dejan sin cambios
+func TestMigrationsSkipConflictingIsbns(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, isbn := range []string{"978-0-307-47472-8", "9780307474728", "84-376-0494-X"} {
+		if _, err := coll.InsertOne(ctx, models.Book{Title: isbn, Author: "A", Isbn: isbn, Availability: 1}); err != nil {
+			t.Fatalf("Seed failed: %v", err)
+		}
+	}
+
+	runner := &migrations.Runner{DB: coll.Database(), Owner: "test"}
+	if _, err := runner.Up(ctx); err != nil {
+		t.Fatalf("Up error: %v", err)
+	}
+
+	for title, want := range map[string]string{
+		"978-0-307-47472-8": "978-0-307-47472-8",
+		"9780307474728":     "9780307474728",
+		"84-376-0494-X":     "843760494X",
+	} {
+		var book models.Book
+		if err := coll.FindOne(ctx, bson.M{"title": title}).Decode(&book); err != nil {
+			t.Fatalf("Book not found: %v", err)
+		}
+		if book.Isbn != want {
+			t.Errorf("Book %s: expected isbn %q, got %q", title, want, book.Isbn)
+		}
+	}
+}
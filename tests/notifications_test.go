@@ -0,0 +1,340 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/handlers"
+	"backend/models"
+	"backend/notifications"
+	"backend/routes"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Correo recibido por el servidor SMTP local, ya decodificado
+type receivedMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Servidor SMTP local minimo que acepta todos los correos y los guarda
+type smtpStub struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu       sync.Mutex
+	received []receivedMail
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &smtpStub{t: t, ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpStub) Addr() string { return s.ln.Addr().String() }
+
+func (s *smtpStub) Mails() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.received...)
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"), strings.HasPrefix(cmd, "RSET"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.store(data.String())
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) store(data string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		s.t.Errorf("Mensaje invalido: %v", err)
+		return
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		s.t.Errorf("Asunto invalido: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		s.t.Errorf("Cuerpo invalido: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, receivedMail{To: msg.Header.Get("To"), Subject: subject, Body: string(body)})
+}
+
+// Sender que falla siempre
+type failingSender struct{}
+
+func (failingSender) Send(context.Context, notifications.Message) error {
+	return errors.New("smtp no disponible")
+}
+
+func TestNotificationTemplates(t *testing.T) {
+	templates, err := notifications.LoadTemplates()
+	if err != nil {
+		t.Fatalf("LoadTemplates failed: %v", err)
+	}
+
+	cases := []struct {
+		lang, kind string
+		days       int
+		want       string
+	}{
+		{"es", notifications.KindDueSoon, 1, "Tu préstamo de «Rayuela» vence mañana"},
+		{"es", notifications.KindDueSoon, 3, "Tu préstamo de «Rayuela» vence en 3 días"},
+		{"en", notifications.KindDueSoon, 0, `Your loan of "Rayuela" is due today`},
+		{"en", notifications.KindOverdue, 0, `Your loan of "Rayuela" is overdue`},
+		{"es", notifications.KindHoldReady, 0, "«Rayuela» está listo para retirar"},
+	}
+	for _, tc := range cases {
+		subject, body, err := templates.Render(tc.lang, tc.kind, notifications.TemplateData{Name: "Ana", Title: "Rayuela", DueDate: "01/02/2026", Days: tc.days})
+		if err != nil {
+			t.Fatalf("Render %s/%s failed: %v", tc.lang, tc.kind, err)
+		}
+		if subject != tc.want || !strings.Contains(body, "Ana") {
+			t.Errorf("Render %s/%s inesperado: %q\n%s", tc.lang, tc.kind, subject, body)
+		}
+	}
+
+	if lang := notifications.Language("en-US", "es"); lang != "en" {
+		t.Errorf("Esperado en, obtuvo %s", lang)
+	}
+	if lang := notifications.Language("fr", "es"); lang != "es" {
+		t.Errorf("Esperado es para un idioma sin plantillas, obtuvo %s", lang)
+	}
+}
+
+func TestNotifierSendsOnce(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	templates, err := notifications.LoadTemplates()
+	if err != nil {
+		t.Fatalf("LoadTemplates failed: %v", err)
+	}
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	ana, john, olga := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	users := []any{
+		models.User{ID: ana, Name: "Ana", Email: "ana@example.com"},
+		models.User{ID: john, Name: "John", Email: "john@example.com", Language: "en"},
+		models.User{ID: olga, Name: "Olga", Email: "olga@example.com", NotificationsOptOut: true},
+	}
+	if _, err := db.Collection("users").InsertMany(ctx, users); err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+	book := primitive.NewObjectID()
+	if _, err := coll.InsertOne(ctx, models.Book{ID: book, Title: "Rayuela", Author: "Cortazar", Availability: 1}); err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+	loans := []any{
+		// Vence en 20 horas: recordatorio de mañana
+		models.Loan{Name: "pronto", Description: "d", UserId: ana.Hex(), BookId: book.Hex(), CreatedAt: now.Add(-13 * day), DueDate: now.Add(20 * time.Hour)},
+		models.Loan{Name: "vencido", Description: "d", UserId: john.Hex(), BookId: book.Hex(), CreatedAt: now.Add(-20 * day), DueDate: now.Add(-2 * day)},
+		models.Loan{Name: "lejano", Description: "d", UserId: ana.Hex(), BookId: book.Hex(), CreatedAt: now, DueDate: now.Add(10 * day)},
+		models.Loan{Name: "devuelto", Description: "d", UserId: ana.Hex(), BookId: book.Hex(), IsReturned: true, CreatedAt: now.Add(-20 * day), DueDate: now.Add(-day)},
+		models.Loan{Name: "sin aviso", Description: "d", UserId: olga.Hex(), BookId: book.Hex(), CreatedAt: now.Add(-20 * day), DueDate: now.Add(-day)},
+	}
+	if _, err := db.Collection("loans").InsertMany(ctx, loans); err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+	holds := []any{
+		models.Hold{ID: primitive.NewObjectID(), UserId: john.Hex(), BookId: book.Hex(), Status: models.HoldReady, CreatedAt: now.Add(-day)},
+		models.Hold{ID: primitive.NewObjectID(), UserId: ana.Hex(), BookId: book.Hex(), Status: models.HoldWaiting, CreatedAt: now},
+	}
+	if _, err := db.Collection("holds").InsertMany(ctx, holds); err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+
+	notifier := &notifications.Notifier{
+		Users:     db.Collection("users"),
+		Books:     coll,
+		Loans:     db.Collection("loans"),
+		Holds:     db.Collection("holds"),
+		Sent:      db.Collection("notifications_sent"),
+		Templates: templates,
+		Sender:    failingSender{},
+		Now:       func() time.Time { return now },
+	}
+
+	// Un envio fallido libera la notificacion para la pasada siguiente
+	report, err := notifier.Scan(ctx)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if report.Failed != 3 || report.Sent != 0 || report.Skipped != 1 {
+		t.Errorf("Reporte inesperado con el envio fallido: %+v", report)
+	}
+	if n, _ := notifier.Sent.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Esperado el registro vacio tras fallar, obtuvo %d", n)
+	}
+
+	stub := newSMTPStub(t)
+	notifier.Sender = &notifications.SMTPSender{Addr: stub.Addr(), From: "Biblioteca <biblioteca@example.com>", Timeout: 5 * time.Second}
+	report, err = notifier.Scan(ctx)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if report.Sent != 3 || report.Failed != 0 || report.Skipped != 1 {
+		t.Errorf("Reporte inesperado: %+v", report)
+	}
+
+	want := map[string]receivedMail{
+		"Tu préstamo de «Rayuela» vence mañana": {To: "ana@example.com", Body: "vence el 11/03/2026"},
+		`Your loan of "Rayuela" is overdue`:     {To: "john@example.com", Body: "was due on March 8, 2026"},
+		`"Rayuela" is ready for pickup`:         {To: "john@example.com", Body: "Hi John,"},
+	}
+	mails := stub.Mails()
+	if len(mails) != len(want) {
+		t.Fatalf("Esperados %d correos, obtuvo %d: %+v", len(want), len(mails), mails)
+	}
+	for _, m := range mails {
+		w, ok := want[m.Subject]
+		if !ok || !strings.Contains(m.To, w.To) || !strings.Contains(m.Body, w.Body) {
+			t.Errorf("Correo inesperado: %+v", m)
+		}
+	}
+
+	// La segunda pasada no repite notificaciones
+	report, err = notifier.Scan(ctx)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if report.Sent != 0 || report.Skipped != 4 || len(stub.Mails()) != 3 {
+		t.Errorf("Esperado ningun envio repetido, obtuvo %+v", report)
+	}
+	var record notifications.Record
+	if err := notifier.Sent.FindOne(ctx, bson.M{"kind": notifications.KindOverdue}).Decode(&record); err != nil || record.State != notifications.StateSent || record.Language != "en" {
+		t.Errorf("Registro inesperado %+v: %v", record, err)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := coll.Database()
+	h := &handlers.Handler{
+		Books:  coll,
+		Users:  db.Collection("users"),
+		Tokens: &auth.TokenSigner{Secret: []byte(strings.Repeat("s", 32)), TTL: time.Hour},
+	}
+	e := echo.New()
+	routes.Register(e, h, routes.Options{Auth: &auth.Authenticator{Tokens: h.Tokens}})
+
+	ana := primitive.NewObjectID()
+	if _, err := h.Users.InsertOne(ctx, models.User{ID: ana, Name: "Ana", Email: "ana@example.com"}); err != nil {
+		t.Fatalf("InsertOne failed: %v", err)
+	}
+	token, _, err := h.Tokens.Issue(ana.Hex())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/me/notifications", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	user := func() models.User {
+		var u models.User
+		if err := h.Users.FindOne(ctx, bson.M{"_id": ana}).Decode(&u); err != nil {
+			t.Fatalf("FindOne failed: %v", err)
+		}
+		return u
+	}
+
+	if code := put(`{}`); code != http.StatusBadRequest {
+		t.Errorf("Esperado 400 sin cambios, obtuvo %d", code)
+	}
+	if code := put(`{"language":"fr"}`); code != http.StatusBadRequest {
+		t.Errorf("Esperado 400 con un idioma sin plantillas, obtuvo %d", code)
+	}
+	if code := put(`{"opt_out":true,"language":"EN"}`); code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d", code)
+	}
+	if u := user(); !u.NotificationsOptOut || u.Language != "en" {
+		t.Errorf("Preferencias inesperadas: %+v", u)
+	}
+	if code := put(`{"opt_out":false,"language":""}`); code != http.StatusCreated {
+		t.Fatalf("Esperado 201, obtuvo %d", code)
+	}
+	if u := user(); u.NotificationsOptOut || u.Language != "" {
+		t.Errorf("Preferencias inesperadas: %+v", u)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/openapi"
+)
+
+// Cada ruta registrada debe estar documentada y cada operacion documentada debe existir
+func TestOpenAPICoversRoutes(t *testing.T) {
+	e := newRoutedEcho("")
+	doc := openapi.Spec()
+
+	registered := map[string]bool{}
+	for _, r := range e.Routes() {
+		// Rutas internas de Echo y los recursos estaticos de Swagger UI
+		if r.Method == "echo_route_not_found" || strings.HasSuffix(r.Path, "/*") {
+			continue
+		}
+		registered[r.Method+" "+openapi.PathTemplate(r.Path)] = true
+		if doc.Operation(r.Method, r.Path) == nil {
+			t.Errorf("Ruta %s %s no documentada en el OpenAPI", r.Method, r.Path)
+		}
+	}
+
+	ids := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("Operacion %s %s documentada pero no registrada", method, path)
+			}
+			if ids[op.OperationID] {
+				t.Errorf("operationId repetido: %s", op.OperationID)
+			}
+			ids[op.OperationID] = true
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s sin respuestas", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	e := newRoutedEcho("")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Esperado 200, obtuvo %d", rec.Code)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Documento invalido: %v", err)
+	}
+	if doc["openapi"] != openapi.Version {
+		t.Errorf("Version inesperada: %v", doc["openapi"])
+	}
+
+	// Todas las referencias apuntan a componentes existentes
+	components, _ := doc["components"].(map[string]any)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				section, _ := components[parts[0]].(map[string]any)
+				if len(parts) != 2 || section[parts[1]] == nil {
+					t.Errorf("Referencia sin destino: %s", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	legacy := doc["paths"].(map[string]any)["/books"].(map[string]any)["get"].(map[string]any)
+	if legacy["deprecated"] != true {
+		t.Error("La ruta sin version deberia estar marcada como obsoleta")
+	}
+}
+
+func TestOpenAPISwaggerUI(t *testing.T) {
+	e := newRoutedEcho("")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/openapi.json") {
+		t.Fatalf("Esperado Swagger UI apuntando a /openapi.json, obtuvo %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/swagger-ui-bundle.js", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Recurso embebido: esperado 200, obtuvo %d", rec.Code)
+	}
+}
@@ -34,7 +34,8 @@ func TestPerformanceCreateUsers(t *testing.T) {
     ctxSetup, cancelSetup := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancelSetup()
 
-    client, err := mongo.Connect(ctxSetup, options.Client().ApplyURI("mongodb://localhost:27017"))
+    cfg := loadTestConfig(t)
+    client, err := mongo.Connect(ctxSetup, options.Client().ApplyURI(cfg.Mongo.URI))
     if err != nil {
         t.Fatal(err)
     }
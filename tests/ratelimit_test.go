@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/handlers"
+	"backend/ratelimit"
+	"backend/routes"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMemoryTokenBucket(t *testing.T) {
+	store := ratelimit.NewMemory()
+	limit := ratelimit.Limit{Requests: 1, Period: time.Second, Burst: 3}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		res, _ := store.Take(context.Background(), "k", limit, now)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("Peticion %d: esperado permitida con %d restantes, obtuvo %+v", i, 2-i, res)
+		}
+	}
+
+	res, _ := store.Take(context.Background(), "k", limit, now)
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Fatalf("Esperado rechazo con Retry-After 1s y Reset 3s, obtuvo %+v", res)
+	}
+
+	// Otra clave tiene su propio bucket
+	if res, _ := store.Take(context.Background(), "otra", limit, now); !res.Allowed {
+		t.Error("Otra clave no deberia estar limitada")
+	}
+
+	// Tras un segundo se recarga una ficha
+	res, _ = store.Take(context.Background(), "k", limit, now.Add(time.Second))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Esperado permitida tras la recarga, obtuvo %+v", res)
+	}
+
+	// El bucket nunca supera su capacidad
+	res, _ = store.Take(context.Background(), "k", limit, now.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Esperado bucket lleno, obtuvo %+v", res)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := &ratelimit.Limiter{
+		Store:   ratelimit.NewMemory(),
+		Default: ratelimit.Limit{Requests: 100, Period: time.Minute},
+		Groups:  map[string]ratelimit.Limit{"loans": {Requests: 2, Period: time.Minute}},
+		Now:     func() time.Time { return now },
+	}
+
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	e.POST("/loans", ok, limiter.Middleware("loans"))
+	e.POST("/kiosk", ok, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth.SetPrincipal(c, &auth.Principal{ID: "kiosk-1", Kind: "apikey"})
+			return next(c)
+		}
+	}, limiter.Middleware("loans"))
+
+	do := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("/loans", "10.0.0.1"); rec.Code != http.StatusNoContent {
+			t.Fatalf("Peticion %d: esperado 204, obtuvo %d", i, rec.Code)
+		}
+	}
+
+	rec := do("/loans", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Esperado 429, obtuvo %d", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":         "30",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60;burst=2",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s: esperado %q, obtuvo %q", header, want, got)
+		}
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["status"] != float64(http.StatusTooManyRequests) {
+		t.Errorf("Sobre de error inesperado: %s", rec.Body.String())
+	}
+
+	// Otra IP y una identidad autenticada tienen su propio bucket
+	if rec := do("/loans", "10.0.0.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("Otra IP: esperado 204, obtuvo %d", rec.Code)
+	}
+	if rec := do("/kiosk", "10.0.0.1"); rec.Code != http.StatusNoContent {
+		t.Errorf("Api key: esperado 204, obtuvo %d", rec.Code)
+	}
+
+	// Pasado el tiempo de espera se permite de nuevo
+	now = now.Add(30 * time.Second)
+	if rec := do("/loans", "10.0.0.1"); rec.Code != http.StatusNoContent {
+		t.Errorf("Tras Retry-After: esperado 204, obtuvo %d", rec.Code)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit, time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("almacen caido")
+}
+
+func TestRateLimitStoreFailureAllows(t *testing.T) {
+	limiter := &ratelimit.Limiter{Store: failingStore{}, Default: ratelimit.Limit{Requests: 1, Period: time.Minute}}
+
+	e := echo.New()
+	e.GET("/books", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }, limiter.Middleware("books"))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Esperado 204 con el almacen caido, obtuvo %d", rec.Code)
+	}
+}
+
+// Las rutas de un grupo comparten la cuota entre versiones y alias
+func TestRateLimitSharedAcrossVersions(t *testing.T) {
+	e := echo.New()
+	routes.Register(e, &handlers.Handler{}, routes.Options{
+		RateLimit: &ratelimit.Limiter{
+			Store:   ratelimit.NewMemory(),
+			Default: ratelimit.Limit{Requests: 100, Period: time.Minute},
+			Groups:  map[string]ratelimit.Limit{routes.GroupLoans: {Requests: 2, Period: time.Minute}},
+		},
+	})
+
+	var codes []int
+	for _, path := range []string{"/api/v1/loans", "/api/v2/loans", "/loans"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		codes = append(codes, rec.Code)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Esperado 429 en la tercera peticion, obtuvo %v", codes)
+	}
+
+	// Otro grupo no se ve afectado
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/books", nil))
+	if rec.Code == http.StatusTooManyRequests {
+		t.Error("El grupo books no deberia estar limitado")
+	}
+}
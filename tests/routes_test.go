@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"backend/handlers"
+	"backend/metrics"
+	"backend/routes"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newRoutedEcho(token string) *echo.Echo {
+	e := echo.New()
+	routes.Register(e, &handlers.Handler{}, routes.Options{
+		AdminToken:        token,
+		Metrics:           metrics.New().Handler(),
+		LegacyDeprecation: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		LegacySunset:      time.Date(2027, 5, 1, 0, 0, 0, 0, time.UTC),
+	})
+	return e
+}
+
+func TestRoutesVersions(t *testing.T) {
+	e := newRoutedEcho("")
+
+	registered := map[string]bool{}
+	for _, r := range e.Routes() {
+		registered[r.Method+" "+r.Path] = true
+	}
+
+	for _, want := range []string{
+		"GET /healthz",
+		"GET /api/v1/books",
+		"PUT /api/v1/return-loan/:id",
+		"GET /api/v1/audit",
+		"POST /api/v2/loans/:id/return",
+		"GET /api/v2/books/:id",
+		"GET /books",
+		"PUT /return-loan/:id",
+	} {
+		if !registered[want] {
+			t.Errorf("Ruta %s no registrada", want)
+		}
+	}
+
+	for _, unwanted := range []string{"PUT /api/v2/return-loan/:id", "POST /loans/:id/return", "GET /api/v1/healthz"} {
+		if registered[unwanted] {
+			t.Errorf("Ruta %s no deberia estar registrada", unwanted)
+		}
+	}
+}
+
+func TestRoutesLegacyHeaders(t *testing.T) {
+	e := newRoutedEcho("secreto")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books/abc", nil))
+
+	deprecation := "@" + strconv.FormatInt(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC).Unix(), 10)
+	if got := rec.Header().Get("Deprecation"); got != deprecation {
+		t.Errorf("Esperado Deprecation %s, obtuvo %q", deprecation, got)
+	}
+	if got := rec.Header().Get("Sunset"); got != "Sat, 01 May 2027 00:00:00 GMT" {
+		t.Errorf("Sunset inesperado: %q", got)
+	}
+	if got := rec.Header().Get("Link"); got != `</api/v1/books/abc>; rel="successor-version"` {
+		t.Errorf("Link inesperado: %q", got)
+	}
+
+	// Las rutas versionadas no se anuncian como obsoletas
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/books/abc", nil))
+	if got := rec.Header().Get("Deprecation"); got != "" {
+		t.Errorf("La ruta v1 no deberia tener Deprecation, obtuvo %q", got)
+	}
+}
+
+func TestRoutesAdminAndUnknown(t *testing.T) {
+	e := newRoutedEcho("secreto")
+
+	for _, path := range []string{"/audit", "/api/v1/audit", "/api/v2/audit"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: esperado 401, obtuvo %d", path, rec.Code)
+		}
+	}
+
+	// Una ruta desconocida no debe caer en el middleware de administracion
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no-existe", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Esperado 404, obtuvo %d", rec.Code)
+	}
+}
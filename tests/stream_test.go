@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/events"
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Abre el flujo y retorna los ids y tipos de los primeros n eventos recibidos
+func readStream(url, lastEventId string, n int) ([][2]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		return nil, fmt.Errorf("expected text/event-stream, got %q", ct)
+	}
+
+	var got [][2]string
+	var id string
+	scanner := bufio.NewScanner(res.Body)
+	for len(got) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			got = append(got, [2]string{id, strings.TrimPrefix(line, "event: ")})
+		}
+	}
+	if len(got) < n {
+		return got, fmt.Errorf("expected %d events, got %v (err=%v)", n, got, scanner.Err())
+	}
+	return got, nil
+}
+
+func TestStreamEventsFiltersByBookAndResumes(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	outbox := coll.Database().Collection("outbox")
+	h := &handlers.Handler{Stream: &events.Stream{Outbox: outbox, PollInterval: 10 * time.Millisecond}}
+	e := echo.New()
+	e.GET("/events/stream", h.StreamEvents)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx := context.Background()
+	book := primitive.NewObjectID().Hex()
+	other := primitive.NewObjectID().Hex()
+	insert := func(evt events.Event) events.Event {
+		if err := events.Append(ctx, outbox, &evt); err != nil {
+			t.Fatalf("Insert event: %v", err)
+		}
+		return evt
+	}
+
+	// Un evento anterior a la conexion no se envia a un cliente nuevo
+	insert(events.New(events.BookUpdated, book, bson.M{"availability": 3}, ""))
+
+	url := srv.URL + "/events/stream?book_id=" + book
+	var got [][2]string
+	var readErr error
+	done := make(chan struct{})
+	go func() {
+		got, readErr = readStream(url, "", 2)
+		close(done)
+	}()
+
+	// Espera a que el flujo este abierto antes de publicar
+	time.Sleep(100 * time.Millisecond)
+	insert(events.New(events.BookUpdated, other, bson.M{"availability": 1}, ""))
+	insert(events.New(events.UserCreated, book, nil, ""))
+	updated := insert(events.New(events.BookUpdated, book, bson.M{"availability": 2}, ""))
+	loan := insert(events.New(events.LoanCreated, primitive.NewObjectID().Hex(), bson.M{"book_id": book}, ""))
+
+	<-done
+	if readErr != nil {
+		t.Fatalf("Stream failed: %v", readErr)
+	}
+	updatedId, loanId := strconv.FormatInt(updated.Seq, 10), strconv.FormatInt(loan.Seq, 10)
+	if got[0] != [2]string{updatedId, events.BookUpdated} || got[1] != [2]string{loanId, events.LoanCreated} {
+		t.Errorf("Unexpected events: %v", got)
+	}
+
+	// Al reanudar desde el primer evento recibe el siguiente, tambien con el id del outbox
+	for _, last := range []string{updatedId, updated.ID.Hex()} {
+		resumed, err := readStream(url, last, 1)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		if resumed[0][0] != loanId {
+			t.Errorf("Expected to resume at %s from %s, got %v", loanId, last, resumed)
+		}
+	}
+}
+
+func TestStreamEventsWaitsForLateEvents(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	outbox := coll.Database().Collection("outbox")
+	h := &handlers.Handler{Stream: &events.Stream{Outbox: outbox, PollInterval: 10 * time.Millisecond, GapTimeout: 300 * time.Millisecond}}
+	e := echo.New()
+	e.GET("/events/stream", h.StreamEvents)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx := context.Background()
+	book := primitive.NewObjectID().Hex()
+	// Simula eventos de otras instancias que se confirman fuera del orden de su secuencia
+	insert := func(seq int64) {
+		evt := events.New(events.BookUpdated, book, bson.M{"availability": seq}, "")
+		evt.Seq = seq
+		if _, err := outbox.InsertOne(ctx, evt); err != nil {
+			t.Fatalf("Insert event: %v", err)
+		}
+	}
+	insert(1)
+
+	var got [][2]string
+	var readErr error
+	done := make(chan struct{})
+	go func() {
+		got, readErr = readStream(srv.URL+"/events/stream", "1", 3)
+		close(done)
+	}()
+
+	// El evento 2 se confirma despues del 3 y aun asi se envia, en orden; el 4 nunca se
+	// guarda y el flujo lo salta tras la espera
+	insert(3)
+	time.Sleep(100 * time.Millisecond)
+	insert(2)
+	insert(5)
+
+	<-done
+	if readErr != nil {
+		t.Fatalf("Stream failed: %v", readErr)
+	}
+	if got[0][0] != "2" || got[1][0] != "3" || got[2][0] != "5" {
+		t.Errorf("Expected events 2, 3 and 5 in order, got %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/telemetry"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestTracingStdoutExporterRecordsHandlerSpans(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.Default().Tracing
+	cfg.Exporter = telemetry.ExporterStdout
+
+	shutdown, err := telemetry.Setup(context.Background(), cfg, &buf)
+	if err != nil {
+		t.Fatalf("Error configuring tracing: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(telemetry.Middleware(cfg.ServiceName))
+	// La validación falla antes de tocar la base de datos, el cliente nunca se conecta
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	h := &handlers.Handler{Loans: client.Database("tracedb").Collection("loans")}
+	e.POST("/loans", h.CreateLoan)
+
+	req := httptest.NewRequest(http.MethodPost, "/loans", strings.NewReader(`{"name":""}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Esperado 400, obtuvo %d", rec.Code)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Error flushing spans: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{`"Name":"loans.validate"`, `"Name":"POST /loans"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("No se encontró el span %s en la salida", want)
+		}
+	}
+}
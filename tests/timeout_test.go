@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/handlers"
+	"backend/models"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setupSlowStore levanta un servidor TCP que acepta conexiones pero nunca responde,
+// simulando una base de datos lenta, y retorna un handler conectado a él.
+func setupSlowStore(t *testing.T, timeout time.Duration) *handlers.Handler {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Mantiene la conexión abierta sin responder
+			conns = append(conns, conn)
+		}
+	}()
+
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+ln.Addr().String()).
+		SetDirect(true))
+	if err != nil {
+		t.Fatalf("Error connecting to slow store: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ln.Close()
+		<-done
+		for _, conn := range conns {
+			conn.Close()
+		}
+		client.Disconnect(context.Background())
+	})
+
+	db := client.Database("slowdb")
+	h := handlers.NewHandler(db.Collection("books"), db.Collection("users"), db.Collection("loans"))
+	h.Timeouts.Read = timeout
+	h.Timeouts.Write = timeout
+	return h
+}
+
+func TestGetBooksSlowStoreReturnsGatewayTimeout(t *testing.T) {
+	h := setupSlowStore(t, 200*time.Millisecond)
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	start := time.Now()
+	if err := h.GetBooks(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Esperado 504, obtuvo %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("La consulta no respetó el límite de tiempo: %s", elapsed)
+	}
+}
+
+func TestCreateBookSlowStoreReturnsGatewayTimeout(t *testing.T) {
+	h := setupSlowStore(t, 200*time.Millisecond)
+	e := echo.New()
+
+	book := models.Book{Title: "Slow", Author: "Store", Isbn: "SLOW-1", Availability: 1}
+	body, _ := json.Marshal(book)
+	req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := h.CreateBook(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Esperado 504, obtuvo %d", rec.Code)
+	}
+}
+
+func TestGetUsersClientDisconnectCancelsQuery(t *testing.T) {
+	// Sin límite propio: solo la cancelación de la petición puede detener la consulta
+	h := setupSlowStore(t, time.Minute)
+	e := echo.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := h.GetUsers(c); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("La consulta no se canceló con la petición: %s", elapsed)
+	}
+	if rec.Code == http.StatusFound {
+		t.Errorf("No esperaba respuesta exitosa, obtuvo %d", rec.Code)
+	}
+}
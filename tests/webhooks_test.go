@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/events"
+	"backend/handlers"
+	"backend/webhooks"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Receptor local que verifica la firma y falla las primeras respuestas
+type webhookReceiver struct {
+	t      *testing.T
+	secret string
+	fails  int
+
+	mu       sync.Mutex
+	received []string
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	ts, _ := strconv.ParseInt(req.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+	if !webhooks.Verify(r.secret, req.Header.Get(webhooks.HeaderSignature), ts, body) {
+		r.t.Errorf("Firma invalida para la entrega %s", req.Header.Get(webhooks.HeaderDelivery))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, req.Header.Get(webhooks.HeaderEvent))
+	if len(r.received) <= r.fails {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhookSignatureVerifies(t *testing.T) {
+	body := []byte(`{"type":"LoanCreated"}`)
+	sig := webhooks.Sign("secreto", 1700000000, body)
+
+	if !webhooks.Verify("secreto", sig, 1700000000, body) {
+		t.Error("La firma deberia ser valida")
+	}
+	if webhooks.Verify("otro", sig, 1700000000, body) {
+		t.Error("La firma no deberia validar con otro secreto")
+	}
+	if webhooks.Verify("secreto", sig, 1700000001, body) {
+		t.Error("La firma no deberia validar con otro timestamp")
+	}
+}
+
+func TestWebhookDeliveryRetriesWithBackoff(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	receiver := &webhookReceiver{t: t, secret: "s3cret", fails: 1}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	svc := &webhooks.Service{
+		Subscriptions: coll.Database().Collection("webhooks"),
+		Deliveries:    coll.Database().Collection("webhook_deliveries"),
+		MaxAttempts:   5,
+		BaseBackoff:   10 * time.Millisecond,
+		MaxBackoff:    10 * time.Millisecond,
+	}
+	h := &handlers.Handler{Webhooks: svc}
+	e := echo.New()
+
+	// Crea la suscripcion solo para prestamos
+	body, _ := json.Marshal(map[string]interface{}{"url": srv.URL, "events": []string{events.LoanCreated}, "secret": "s3cret"})
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := h.CreateWebhook(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	ctx := context.Background()
+	// El evento de libros no coincide con el filtro, el de prestamos si
+	if err := svc.Publish(ctx, events.New(events.BookCreated, "b1", nil, "")); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+	loan := events.New(events.LoanCreated, "l1", bson.M{"user_id": "u1"}, "")
+	if err := svc.Publish(ctx, loan); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	// Publicar solo registra la entrega, el envio lo hacen los workers
+	receiver.mu.Lock()
+	if len(receiver.received) != 0 {
+		t.Errorf("Publish sent the delivery: %v", receiver.received)
+	}
+	receiver.mu.Unlock()
+
+	// El primer intento falla y queda pendiente; el reintento tras la espera lo entrega
+	if n, err := svc.DeliverPending(ctx); err != nil || n != 1 {
+		t.Fatalf("Expected 1 delivery sent, got %d (%v)", n, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := svc.DeliverPending(ctx); err != nil {
+		t.Fatalf("Retry error: %v", err)
+	}
+	// Publicar de nuevo el mismo evento no duplica la entrega
+	if err := svc.Publish(ctx, loan); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	var d webhooks.Delivery
+	if err := svc.Deliveries.FindOne(ctx, bson.M{"event_id": loan.ID.Hex()}).Decode(&d); err != nil {
+		t.Fatalf("Delivery not found: %v", err)
+	}
+	if d.Status != webhooks.StatusDelivered || d.Attempts != 2 {
+		t.Errorf("Expected delivered after 2 attempts, got status=%s attempts=%d", d.Status, d.Attempts)
+	}
+	if n, _ := svc.Deliveries.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+
+	// El reenvio manual vuelve a llamar al receptor
+	res, err := svc.Redeliver(ctx, d.SubscriptionId, d.ID)
+	if err != nil {
+		t.Fatalf("Redeliver error: %v", err)
+	}
+	if res.Attempts != 3 || res.Status != webhooks.StatusDelivered {
+		t.Errorf("Unexpected redelivery result: %+v", res)
+	}
+	if _, err := svc.Redeliver(ctx, d.SubscriptionId, primitive.NewObjectID()); err == nil {
+		t.Error("Expected error redelivering a missing delivery")
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.received) != 3 {
+		t.Errorf("Expected 3 requests at the receiver, got %v", receiver.received)
+	}
+}
+
+func TestWebhookDeliveryClaimedByOneWorker(t *testing.T) {
+	coll, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Receptor lento que retiene la primera entrega hasta que se libera
+	arrived, release := make(chan struct{}, 1), make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	db := coll.Database()
+	if _, err := db.Collection("webhooks").InsertOne(ctx, webhooks.Subscription{URL: srv.URL, Secret: "s", Active: true}); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	service := func(owner string) *webhooks.Service {
+		return &webhooks.Service{
+			Subscriptions: db.Collection("webhooks"),
+			Deliveries:    db.Collection("webhook_deliveries"),
+			Owner:         owner,
+			Lease:         time.Minute,
+		}
+	}
+	a, b := service("a"), service("b")
+	evt := events.New(events.BookCreated, "b1", nil, "")
+	if err := a.Publish(ctx, evt); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	done := make(chan int)
+	go func() {
+		n, _ := a.DeliverPending(ctx)
+		done <- n
+	}()
+	<-arrived
+
+	// Mientras el worker a conserva el reclamo, b no envia la entrega ni puede reenviarla
+	var d webhooks.Delivery
+	if err := b.Deliveries.FindOne(ctx, bson.M{"event_id": evt.ID.Hex()}).Decode(&d); err != nil {
+		t.Fatalf("Delivery not found: %v", err)
+	}
+	if d.Status != webhooks.StatusSending || d.Owner != "a" || d.LockedUntil == nil {
+		t.Errorf("Expected delivery claimed by a, got %+v", d)
+	}
+	if n, err := b.DeliverPending(ctx); err != nil || n != 0 {
+		t.Errorf("Expected no deliveries for b, got %d (%v)", n, err)
+	}
+	if _, err := b.Redeliver(ctx, d.SubscriptionId, d.ID); err != webhooks.ErrBusy {
+		t.Errorf("Expected ErrBusy redelivering a claimed delivery, got %v", err)
+	}
+
+	close(release)
+	if n := <-done; n != 1 {
+		t.Errorf("Expected 1 delivery sent by a, got %d", n)
+	}
+	if err := b.Deliveries.FindOne(ctx, bson.M{"_id": d.ID}).Decode(&d); err != nil {
+		t.Fatalf("Delivery not found: %v", err)
+	}
+	if d.Status != webhooks.StatusDelivered || d.Owner != "" || d.LockedUntil != nil || d.Attempts != 1 {
+		t.Errorf("Expected delivered and released, got %+v", d)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("Expected 1 request at the receiver, got %d", calls)
+	}
+}
@@ -0,0 +1,294 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"backend/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Servicio de webhooks: recibe los eventos del outbox y registra una entrega por
+// suscripcion. Los workers reclaman las entregas vencidas con un plazo de bloqueo y las
+// envian, reintentando con espera exponencial las que fallan.
+type Service struct {
+	Subscriptions *mongo.Collection
+	Deliveries    *mongo.Collection
+	Sender        Sender
+	Logger        *slog.Logger
+
+	// Identifica a la instancia que reclama las entregas, por defecto host:pid
+	Owner string
+	// Vigencia del reclamo de una entrega; vencido, otro worker puede reenviarla
+	Lease time.Duration
+	// Entregas que se envian en paralelo
+	Workers int
+
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	wakeOnce sync.Once
+	wake     chan struct{}
+}
+
+// Entregas que envia un worker por cada consulta
+const deliveriesPerRun = 100
+
+// La entrega la esta enviando otro worker
+var ErrBusy = errors.New("webhooks: la entrega se esta enviando")
+
+// Genera un secreto aleatorio para una suscripcion
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Publica un evento en todas las suscripciones activas que lo reciben. Implementa
+// events.Publisher: solo registra una entrega por suscripcion y evento, aunque el evento
+// llegue dos veces, y avisa a los workers; el envio no bloquea el despacho del outbox.
+func (s *Service) Publish(ctx context.Context, evt events.Event) error {
+	cur, err := s.Subscriptions.Find(ctx, bson.M{"active": true})
+	if err != nil {
+		return err
+	}
+	var subs []Subscription
+	if err := cur.All(ctx, &subs); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if !sub.Matches(evt.Type) {
+			continue
+		}
+
+		d, err := s.register(ctx, sub, evt, body)
+		if err != nil {
+			return err
+		}
+		if d.Status == StatusPending {
+			s.notify()
+		}
+	}
+	return nil
+}
+
+// Registra la entrega de forma idempotente por suscripcion y evento
+func (s *Service) register(ctx context.Context, sub Subscription, evt events.Event, body []byte) (Delivery, error) {
+	now := time.Now().UTC()
+	filter := bson.M{"subscription_id": sub.ID, "event_id": evt.ID.Hex()}
+	update := bson.M{"$setOnInsert": bson.M{
+		"event_type":      evt.Type,
+		"body":            string(body),
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+		"created_at":      now,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var d Delivery
+	err := s.Deliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+	return d, err
+}
+
+// Reclama una entrega marcandola como en envio por este worker hasta que venza el plazo
+func (s *Service) claim(ctx context.Context, filter bson.M) (Delivery, error) {
+	update := bson.M{"$set": bson.M{
+		"status":       StatusSending,
+		"locked_until": time.Now().UTC().Add(s.lease()),
+		"owner":        s.owner(),
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var d Delivery
+	err := s.Deliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+	return d, err
+}
+
+// Envia una entrega reclamada y guarda el resultado en el registro de entregas. El
+// resultado solo se guarda si este worker conserva el reclamo.
+func (s *Service) attempt(ctx context.Context, sub Subscription, d Delivery) Delivery {
+	status, err := s.Sender.Send(ctx, sub, d)
+	now := time.Now().UTC()
+	d.Attempts++
+	d.ResponseStatus = status
+	d.LockedUntil, d.Owner = nil, ""
+
+	set := bson.M{"attempts": d.Attempts, "response_status": status}
+	if err == nil {
+		d.Status = StatusDelivered
+		d.DeliveredAt = now
+		d.LastError = ""
+		set["status"] = d.Status
+		set["delivered_at"] = now
+		set["last_error"] = ""
+	} else {
+		d.Status = StatusPending
+		if s.MaxAttempts > 0 && d.Attempts >= s.MaxAttempts {
+			d.Status = StatusFailed
+		}
+		d.LastError = err.Error()
+		d.NextAttemptAt = now.Add(events.Backoff(d.Attempts, s.BaseBackoff, s.MaxBackoff))
+		set["status"] = d.Status
+		set["last_error"] = d.LastError
+		set["next_attempt_at"] = d.NextAttemptAt
+
+		s.logger().WarnContext(ctx, "webhook delivery failed",
+			"delivery_id", d.ID.Hex(), "subscription_id", sub.ID.Hex(), "attempts", d.Attempts, "error", err)
+	}
+
+	s.release(ctx, d.ID, set)
+	return d
+}
+
+// Guarda el resultado de una entrega reclamada y libera el reclamo
+func (s *Service) release(ctx context.Context, id primitive.ObjectID, set bson.M) {
+	set["locked_until"], set["owner"] = nil, ""
+	res, err := s.Deliveries.UpdateOne(ctx, bson.M{"_id": id, "status": StatusSending, "owner": s.owner()}, bson.M{"$set": set})
+	if err != nil {
+		s.logger().ErrorContext(ctx, "webhook delivery not recorded", "delivery_id", id.Hex(), "error", err)
+	} else if res.MatchedCount == 0 {
+		// El plazo vencio y otro worker reclamo la entrega
+		s.logger().WarnContext(ctx, "webhook delivery lease lost", "delivery_id", id.Hex())
+	}
+}
+
+// Reclama y envia las entregas pendientes cuyo siguiente intento ya vencio, junto con las
+// reclamadas por un worker cuyo plazo vencio sin guardar el resultado. Retorna cuantas envio.
+func (s *Service) DeliverPending(ctx context.Context) (int, error) {
+	for n := 0; n < deliveriesPerRun; n++ {
+		now := time.Now().UTC()
+		d, err := s.claim(ctx, bson.M{"$or": bson.A{
+			bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"status": StatusSending, "locked_until": bson.M{"$lte": now}},
+		}})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		var sub Subscription
+		err = s.Subscriptions.FindOne(ctx, bson.M{"_id": d.SubscriptionId}).Decode(&sub)
+		if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && !sub.Active) {
+			// La suscripcion ya no existe o esta inactiva: la entrega no se reintenta
+			s.release(ctx, d.ID, bson.M{"status": StatusFailed, "last_error": "suscripcion eliminada o inactiva"})
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		s.attempt(ctx, sub, d)
+	}
+	return deliveriesPerRun, nil
+}
+
+// Reenvia manualmente una entrega, sin importar su estado. Retorna ErrBusy si otro
+// worker la esta enviando.
+func (s *Service) Redeliver(ctx context.Context, subscriptionId, deliveryId primitive.ObjectID) (Delivery, error) {
+	var sub Subscription
+	if err := s.Subscriptions.FindOne(ctx, bson.M{"_id": subscriptionId}).Decode(&sub); err != nil {
+		return Delivery{}, err
+	}
+
+	d, err := s.claim(ctx, bson.M{"_id": deliveryId, "subscription_id": subscriptionId, "$or": bson.A{
+		bson.M{"status": bson.M{"$ne": StatusSending}},
+		bson.M{"locked_until": bson.M{"$lte": time.Now().UTC()}},
+	}})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Distingue una entrega inexistente de una reclamada por otro worker
+		if err := s.Deliveries.FindOne(ctx, bson.M{"_id": deliveryId, "subscription_id": subscriptionId}).Err(); err != nil {
+			return Delivery{}, err
+		}
+		return Delivery{}, ErrBusy
+	} else if err != nil {
+		return Delivery{}, err
+	}
+	return s.attempt(ctx, sub, d), nil
+}
+
+// Ejecuta los workers de entrega hasta que se cancele el contexto. Cada worker consulta
+// las entregas pendientes en cada intervalo o cuando se registra una nueva.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := s.DeliverPending(ctx); err != nil && ctx.Err() == nil {
+					s.logger().Error("webhook deliveries not processed", "error", err)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.wakeup():
+				case <-time.After(interval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Avisa a un worker que hay una entrega nueva sin bloquear al que publica
+func (s *Service) notify() {
+	select {
+	case s.wakeup() <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) wakeup() chan struct{} {
+	s.wakeOnce.Do(func() { s.wake = make(chan struct{}, 1) })
+	return s.wake
+}
+
+func (s *Service) owner() string {
+	if s.Owner != "" {
+		return s.Owner
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (s *Service) lease() time.Duration {
+	if s.Lease <= 0 {
+		return time.Minute
+	}
+	return s.Lease
+}
+
+func (s *Service) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
@@ -0,0 +1,129 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Encabezados de las entregas
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Estados de una entrega
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Suscripcion a eventos de la biblioteca
+type Subscription struct {
+	ID  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL string             `json:"url" bson:"url"`
+	// Tipos de evento, vacio recibe todos
+	Events []string `json:"events" bson:"events"`
+	// Secreto compartido para firmar las entregas, solo se retorna al crear la suscripcion
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	Active    bool      `json:"active" bson:"active"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Indica si la suscripcion recibe el tipo de evento
+func (s Subscription) Matches(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Registro de una entrega de un evento a una suscripcion
+type Delivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionId primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	EventId        string             `json:"event_id" bson:"event_id"`
+	EventType      string             `json:"event_type" bson:"event_type"`
+	Body           string             `json:"body" bson:"body"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	ResponseStatus int                `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	LastError      string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	DeliveredAt    time.Time          `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	// Reclamo del worker que la esta enviando
+	LockedUntil *time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	Owner       string     `json:"owner,omitempty" bson:"owner,omitempty"`
+}
+
+// Calcula la firma HMAC-SHA256 de una entrega sobre "timestamp.cuerpo"
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifica la firma de una entrega recibida, para uso de los receptores
+func Verify(secret, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// Envia las entregas firmadas por HTTP
+type Sender struct {
+	Client *http.Client
+}
+
+// Envia una entrega y retorna el estado HTTP recibido. Solo los estados 2xx son exitosos.
+func (s Sender) Send(ctx context.Context, sub Subscription, d Delivery) (int, error) {
+	body := []byte(d.Body)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "library-webhooks/1")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, d.ID.Hex())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, body))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhooks: el receptor respondio %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}